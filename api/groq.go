@@ -1,10 +1,8 @@
 package api
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
 	"fmt"
-	"io"
 	"net/http"
 	"os"
 	"strings"
@@ -14,7 +12,8 @@ import (
 /*
 ENV ПЕРЕМЕННЫЕ (пример в /opt/poster_bot/.env):
 
-# цепочка провайдеров: по порядку пробуем groq → openrouter → (можно убрать любого)
+# цепочка провайдеров: по порядку пробуем groq → openrouter → (можно убрать любого
+# или добавить свой OpenAI-совместимый, см. provider.go)
 LLM_PROVIDER_CHAIN=groq,openrouter
 
 # --- GROQ ---
@@ -27,7 +26,8 @@ OPENROUTER_API_KEY=sk-or-...         # если задан — сможем па
 OPENROUTER_BASE_URL=https://openrouter.ai/api/v1
 OPENROUTER_MODEL=meta-llama/llama-3.1-70b-instruct
 
-# Поведение HTTP‑клиента
+# Поведение HTTP‑клиента: таймаут одной попытки у одного провайдера.
+# Общий дедлайн задаёт вызывающий через context (InteractiveTimeout / BackgroundTimeout).
 LLM_TIMEOUT_SECONDS=30
 */
 
@@ -37,10 +37,22 @@ type Message struct {
 }
 
 type ChatRequest struct {
-	Model       string    `json:"model"`
-	Messages    []Message `json:"messages"`
-	Temperature float64   `json:"temperature,omitempty"`
-	MaxTokens   int       `json:"max_tokens,omitempty"`
+	Model          string          `json:"model"`
+	Messages       []Message       `json:"messages"`
+	Temperature    float64         `json:"temperature,omitempty"`
+	MaxTokens      int             `json:"max_tokens,omitempty"`
+	Stream         bool            `json:"stream,omitempty"`
+	StreamOptions  *StreamOptions  `json:"stream_options,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// ResponseFormat — {"type": "json_object"} включает JSON-режим у провайдеров, которые его умеют
+type ResponseFormat struct {
+	Type string `json:"type"`
 }
 
 type ChatResponse struct {
@@ -55,8 +67,19 @@ type ChatResponse struct {
 	} `json:"usage"`
 }
 
-var httpClient = &http.Client{
-	Timeout: time.Second * time.Duration(getIntEnv("LLM_TIMEOUT_SECONDS", 30)),
+// Дедлайны на всю цепочку провайдеров: пользователь ждёт ответа в чате — коротко,
+// фоновая публикация — подольше
+const (
+	InteractiveTimeout = 45 * time.Second
+	BackgroundTimeout  = 3 * time.Minute
+)
+
+// Без своего Timeout: время ограничивает context вызова
+var httpClient = &http.Client{}
+
+// attemptTimeout — сколько даём одному провайдеру (LLM_TIMEOUT_SECONDS)
+func attemptTimeout() time.Duration {
+	return time.Second * time.Duration(getIntEnv("LLM_TIMEOUT_SECONDS", 30))
 }
 
 func getEnv(k, def string) string {
@@ -79,37 +102,7 @@ func getIntEnv(k string, def int) int {
 
 // ========================= PUBLIC API =========================
 
-func GeneratePostFromPrompt(prompt string) (string, string, error) {
-	// Системный промпт — оставил твой, слегка подчистил
-	systemPrompt := `Ты профессиональный копирайтер.
-Пиши строго по заданной теме, без воды и "ИИ‑стиля". Конкретика, факты, детали.
-В конце добавь отдельной строкой JSON с ключевыми словами (англ.), без пояснений.
-Пример: { "keywords": "technology, gadgets, innovation" }`
-
-	messages := []Message{
-		{Role: "system", Content: systemPrompt},
-		{Role: "user", Content: prompt},
-	}
-
-	// По цепочке провайдеров: groq → openrouter (можно менять через LLM_PROVIDER_CHAIN)
-	providers := getProviderChain()
-
-	var lastErr error
-	for _, p := range providers {
-		text, keywords, err := callProvider(p, messages, 0.7, 512)
-		if err == nil {
-			return text, keywords, nil
-		}
-		lastErr = err
-		fmt.Printf("llm[%s] error: %v\n", p, err) // виден в journalctl
-	}
-	if lastErr == nil {
-		lastErr = fmt.Errorf("no providers configured")
-	}
-	return "", "", lastErr
-}
-
-func Translate(text string, toLang string) (string, error) {
+func Translate(ctx context.Context, text string, toLang string) (string, error) {
 	if strings.TrimSpace(toLang) == "" {
 		toLang = "английский"
 	}
@@ -122,130 +115,83 @@ func Translate(text string, toLang string) (string, error) {
 		{Role: "user", Content: user},
 	}
 
-	providers := getProviderChain()
-	var lastErr error
-	for _, p := range providers {
-		content, _, err := callProvider(p, messages, 0.3, 256)
-		if err == nil {
-			return strings.TrimSpace(content), nil
-		}
-		lastErr = err
-		fmt.Printf("llm[%s] translate error: %v\n", p, err)
-	}
-	if lastErr == nil {
-		lastErr = fmt.Errorf("no providers configured")
+	res, err := complete(ctx, PurposeTranslate, messages, CompletionOptions{Temperature: 0.3, MaxTokens: 256})
+	if err != nil {
+		return "", err
 	}
-	return "", lastErr
+	return strings.TrimSpace(res.Text), nil
 }
 
 // ========================= CORE =========================
 
-func getProviderChain() []string {
-	raw := getEnv("LLM_PROVIDER_CHAIN", "groq,openrouter")
-	parts := strings.Split(raw, ",")
-	var out []string
-	for _, p := range parts {
-		p = strings.TrimSpace(strings.ToLower(p))
-		switch p {
-		case "groq", "openrouter":
-			out = append(out, p)
+// complete идёт по цепочке провайдеров до первого успешного ответа (своя модель канала — первой).
+// Каждой попытке — свой таймаут; если отменён или истёк сам ctx, цепочка прерывается.
+// Каждая попытка попадает в учёт расходов с назначением purpose.
+func complete(ctx context.Context, purpose string, messages []Message, opts CompletionOptions) (Completion, error) {
+	var lastErr error
+	for _, p := range providerChainFor(ctx) {
+		if err := ctx.Err(); err != nil {
+			return Completion{}, err
 		}
+		attemptCtx, cancel := context.WithTimeout(ctx, attemptTimeout())
+		started := time.Now()
+		res, err := p.Complete(attemptCtx, messages, opts)
+		cancel()
+		recordUsage(ctx, p, purpose, res, err, time.Since(started))
+		if err == nil {
+			return res, nil
+		}
+		if ctx.Err() != nil {
+			return Completion{}, fmt.Errorf("llm[%s]: %w", p.Name(), ctx.Err())
+		}
+		lastErr = err
+		fmt.Printf("llm[%s] %s error: %v\n", p.Name(), purpose, err) // виден в journalctl
 	}
-	if len(out) == 0 {
-		out = []string{"groq"}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no providers configured")
 	}
-	return out
+	return Completion{}, lastErr
 }
 
-func callProvider(provider string, messages []Message, temp float64, maxTokens int) (string, string, error) {
-	switch provider {
-	case "groq":
-		base := getEnv("GROQ_BASE_URL", "https://api.groq.com/openai/v1")
-		model := getEnv("GROQ_MODEL", "llama3-70b-8192")
-		key := os.Getenv("GROQ_API_KEY")
-		if key == "" {
-			return "", "", fmt.Errorf("GROQ_API_KEY is empty")
+// completeStream — как complete, но провайдеры со стримингом отдают текст по кусочкам.
+// onText получает весь накопленный текст текущей попытки.
+func completeStream(ctx context.Context, purpose string, messages []Message, opts CompletionOptions, onText func(full string)) (Completion, error) {
+	var lastErr error
+	for _, p := range providerChainFor(ctx) {
+		if err := ctx.Err(); err != nil {
+			return Completion{}, err
 		}
-		return callOpenAICompat(base, key, model, messages, temp, maxTokens, nil)
-
-	case "openrouter":
-		base := getEnv("OPENROUTER_BASE_URL", "https://openrouter.ai/api/v1")
-		model := getEnv("OPENROUTER_MODEL", "meta-llama/llama-3.1-70b-instruct")
-		key := os.Getenv("OPENROUTER_API_KEY")
-		if key == "" {
-			return "", "", fmt.Errorf("OPENROUTER_API_KEY is empty")
+		attemptCtx, cancel := context.WithTimeout(ctx, attemptTimeout())
+		started := time.Now()
+		var res Completion
+		var err error
+		if sp, ok := p.(StreamingProvider); ok {
+			var sb strings.Builder
+			res, err = sp.Stream(attemptCtx, messages, opts, func(delta string) {
+				sb.WriteString(delta)
+				onText(sb.String())
+			})
+		} else {
+			res, err = p.Complete(attemptCtx, messages, opts)
+			if err == nil {
+				onText(res.Text)
+			}
 		}
-		headers := map[string]string{
-			"HTTP-Referer": "https://t.me/poster_refact_bot",
-			"X-Title":      "Poster Bot",
+		cancel()
+		recordUsage(ctx, p, purpose, res, err, time.Since(started))
+		if err == nil {
+			return res, nil
 		}
-		return callOpenAICompat(base, key, model, messages, temp, maxTokens, headers)
-	}
-	return "", "", fmt.Errorf("unknown provider: %s", provider)
-}
-
-// Универсальный OpenAI‑совместимый вызов /chat/completions
-func callOpenAICompat(baseURL, apiKey, model string, messages []Message, temperature float64, maxTokens int, extraHeaders map[string]string) (string, string, error) {
-	if baseURL == "" || apiKey == "" {
-		return "", "", fmt.Errorf("missing baseURL or apiKey")
-	}
-	url := strings.TrimRight(baseURL, "/") + "/chat/completions"
-
-	reqBody := ChatRequest{
-		Model:       model,
-		Messages:    messages,
-		Temperature: temperature,
-		MaxTokens:   maxTokens,
-	}
-	bs, _ := json.Marshal(reqBody)
-
-	req, _ := http.NewRequest("POST", url, bytes.NewReader(bs))
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-	req.Header.Set("Content-Type", "application/json")
-	for k, v := range extraHeaders {
-		req.Header.Set(k, v)
-	}
-
-	resp, err := httpClient.Do(req)
-	if err != nil {
-		return "", "", fmt.Errorf("http: %w", err)
-	}
-	defer resp.Body.Close()
-
-	bodyBytes, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode/100 != 2 {
-		// Вернём статус и кусок тела — чтобы в логах было видно истинную причину (401/404/429/400 …)
-		short := string(bodyBytes)
-		if len(short) > 400 {
-			short = short[:400] + "...(truncated)"
+		if ctx.Err() != nil {
+			return Completion{}, fmt.Errorf("llm[%s]: %w", p.Name(), ctx.Err())
 		}
-		return "", "", fmt.Errorf("bad status %d: %s", resp.StatusCode, short)
-	}
-
-	var parsed ChatResponse
-	if err := json.Unmarshal(bodyBytes, &parsed); err != nil {
-		return "", "", fmt.Errorf("json: %v; raw=%s", err, truncate(bodyBytes, 400))
-	}
-	if len(parsed.Choices) == 0 {
-		return "", "", fmt.Errorf("empty choices: %s", truncate(bodyBytes, 400))
+		lastErr = err
+		fmt.Printf("llm[%s] %s stream error: %v\n", p.Name(), purpose, err)
 	}
-
-	fullText := strings.TrimSpace(parsed.Choices[0].Message.Content)
-	text, keywordsJSON := splitTextAndKeywords(fullText)
-
-	// Разобрать JSON с ключевыми словами, но это необязательно — мягкий фоллбэк
-	kws := ""
-	if keywordsJSON != "" {
-		var keywordData struct {
-			Keywords string `json:"keywords"`
-		}
-		if err := json.Unmarshal([]byte(keywordsJSON), &keywordData); err == nil {
-			kws = strings.TrimSpace(keywordData.Keywords)
-		} else {
-			fmt.Printf("⚠️ keywords json parse error: %v\n", err)
-		}
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no providers configured")
 	}
-	return text, kws, nil
+	return Completion{}, lastErr
 }
 
 func truncate(b []byte, n int) string {
@@ -255,21 +201,3 @@ func truncate(b []byte, n int) string {
 	}
 	return s
 }
-
-// Выдёргиваем из ответа последний JSON‑блок как keywords, остальное — текст поста.
-func splitTextAndKeywords(response string) (postText string, keywords string) {
-	lines := strings.Split(response, "\n")
-	var jsonLine string
-
-	for i := len(lines) - 1; i >= 0; i-- {
-		line := strings.TrimSpace(lines[i])
-		if strings.HasPrefix(line, "{") && strings.HasSuffix(line, "}") {
-			jsonLine = line
-			lines = lines[:i]
-			break
-		}
-	}
-	postText = strings.TrimSpace(strings.Join(lines, "\n"))
-	keywords = strings.TrimSpace(jsonLine)
-	return
-}
@@ -0,0 +1,248 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"mybot/format"
+)
+
+// Post — структурированный ответ модели на генерацию поста
+type Post struct {
+	Title      string     `json:"title"`
+	Body       string     `json:"body"`
+	Hashtags   stringList `json:"hashtags"`
+	ImageQuery string     `json:"image_query"` // запрос для стоков картинок, на английском
+	Keywords   stringList `json:"keywords"`    // ключевые слова, на английском
+}
+
+// Text — готовый текст поста в разметке format: заголовок, тело, хэштеги
+func (p Post) Text() string {
+	return composeText(p.Title, p.Body, p.Hashtags)
+}
+
+func composeText(title, body string, hashtags []string) string {
+	var parts []string
+	if title = strings.Trim(strings.TrimSpace(title), "*#"); title != "" {
+		parts = append(parts, "**"+strings.TrimSpace(title)+"**")
+	}
+	if body = strings.TrimSpace(body); body != "" {
+		parts = append(parts, body)
+	}
+	if len(hashtags) > 0 {
+		parts = append(parts, strings.Join(hashtags, " "))
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// stringList принимает и массив строк, и строку через запятую (модели путают)
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(b []byte) error {
+	var arr []string
+	if err := json.Unmarshal(b, &arr); err == nil {
+		*l = arr
+		return nil
+	}
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return fmt.Errorf("ожидается массив строк")
+	}
+	*l = nil
+	for _, x := range strings.Split(str, ",") {
+		*l = append(*l, x)
+	}
+	return nil
+}
+
+const postSchemaPrompt = `Ответ — строго один JSON-объект без пояснений и без markdown-блоков кода, поля в таком порядке:
+{
+  "title": "короткий заголовок поста",
+  "body": "текст поста",
+  "hashtags": ["#тег1", "#тег2"],
+  "image_query": "2-4 English words to search a stock photo",
+  "keywords": ["english", "keywords"]
+}
+title — до 100 символов, можно пустым; body обязателен; hashtags — от 0 до 5 слов без пробелов;
+image_query обязателен и только на английском.
+Разметка (только внутри body):
+`
+
+func postMessages(prompt string) []Message {
+	systemPrompt := `Ты профессиональный копирайтер.
+Пиши строго по заданной теме, без воды и "ИИ‑стиля". Конкретика, факты, детали.
+` + postSchemaPrompt + format.PromptRules
+
+	return []Message{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: prompt},
+	}
+}
+
+// Параметры генерации поста: JSON-режим, запас токенов под экранирование и служебные поля
+var postOptions = CompletionOptions{Temperature: 0.7, MaxTokens: 1024, JSON: true}
+
+// GeneratePostFromPrompt генерирует пост по цепочке провайдеров и проверяет ответ по схеме.
+// Невалидный ответ один раз отправляется модели на исправление.
+func GeneratePostFromPrompt(ctx context.Context, prompt string) (Post, error) {
+	return generatePost(ctx, prompt, func(messages []Message) (Completion, error) {
+		return complete(ctx, PurposeGenerate, messages, postOptions)
+	})
+}
+
+// GeneratePostStream — то же, но со стримингом: onText получает заголовок и тело поста,
+// вынутые из недописанного JSON, по мере генерации. Если провайдер упал посреди ответа
+// (или ответ ушёл на исправление), текст начинается заново.
+func GeneratePostStream(ctx context.Context, prompt string, onText func(text string)) (Post, error) {
+	return generatePost(ctx, prompt, func(messages []Message) (Completion, error) {
+		return completeStream(ctx, PurposeGenerate, messages, postOptions, func(full string) {
+			onText(partialPostText(full))
+		})
+	})
+}
+
+func generatePost(ctx context.Context, prompt string, call func([]Message) (Completion, error)) (Post, error) {
+	messages := postMessages(prompt)
+	res, err := call(messages)
+	if err != nil {
+		return Post{}, err
+	}
+	post, verr := parsePost(res.Text)
+	if verr == nil {
+		return post, nil
+	}
+
+	fmt.Printf("⚠️ llm[%s] ответ не по схеме, просим исправить: %v\n", res.Provider, verr)
+	messages = append(messages,
+		Message{Role: "assistant", Content: res.Text},
+		Message{Role: "user", Content: fmt.Sprintf(
+			"Ответ не прошёл проверку: %v. Верни исправленный ответ — один JSON-объект строго по схеме, без пояснений.", verr)},
+	)
+	res, err = call(messages)
+	if err != nil {
+		return Post{}, err
+	}
+	post, verr = parsePost(res.Text)
+	if verr != nil {
+		return Post{}, fmt.Errorf("ответ модели не по схеме: %w; raw=%s", verr, truncate([]byte(res.Text), 400))
+	}
+	return post, nil
+}
+
+// extractJSON вырезает объект из ответа: модели любят оборачивать его в ```json … ```
+func extractJSON(raw string) string {
+	start := strings.Index(raw, "{")
+	end := strings.LastIndex(raw, "}")
+	if start < 0 || end < start {
+		return raw
+	}
+	return raw[start : end+1]
+}
+
+// parsePost разбирает и проверяет ответ по схеме, нормализуя хэштеги и ключевые слова
+func parsePost(raw string) (Post, error) {
+	var p Post
+	if err := json.Unmarshal([]byte(extractJSON(raw)), &p); err != nil {
+		return Post{}, fmt.Errorf("невалидный JSON: %v", err)
+	}
+
+	p.Title = strings.TrimSpace(p.Title)
+	p.Body = strings.TrimSpace(p.Body)
+	p.ImageQuery = strings.TrimSpace(p.ImageQuery)
+
+	switch {
+	case p.Body == "":
+		return Post{}, fmt.Errorf("поле body пустое")
+	case p.ImageQuery == "":
+		return Post{}, fmt.Errorf("поле image_query пустое")
+	case utf8.RuneCountInString(p.Title) > 200:
+		return Post{}, fmt.Errorf("поле title длиннее 200 символов")
+	}
+
+	p.Hashtags = normalizeHashtags(p.Hashtags)
+	var kws stringList
+	for _, k := range p.Keywords {
+		if k = strings.TrimSpace(k); k != "" {
+			kws = append(kws, k)
+		}
+	}
+	p.Keywords = kws
+	return p, nil
+}
+
+func normalizeHashtags(tags []string) stringList {
+	seen := map[string]bool{}
+	var out stringList
+	for _, t := range tags {
+		t = strings.TrimLeft(strings.TrimSpace(t), "#")
+		t = strings.Join(strings.Fields(t), "_")
+		if t == "" || seen[strings.ToLower(t)] {
+			continue
+		}
+		seen[strings.ToLower(t)] = true
+		out = append(out, "#"+t)
+		if len(out) == 5 {
+			break
+		}
+	}
+	return out
+}
+
+// partialPostText — заголовок и тело из недописанного JSON (для живого предпросмотра)
+func partialPostText(raw string) string {
+	return composeText(partialField(raw, "title"), partialField(raw, "body"), nil)
+}
+
+// partialField достаёт значение строкового поля, даже если строка ещё не закрыта
+func partialField(raw, key string) string {
+	i := strings.Index(raw, `"`+key+`"`)
+	if i < 0 {
+		return ""
+	}
+	rest := strings.TrimLeft(raw[i+len(key)+2:], " \t\r\n")
+	if !strings.HasPrefix(rest, ":") {
+		return ""
+	}
+	rest = strings.TrimLeft(rest[1:], " \t\r\n")
+	if !strings.HasPrefix(rest, `"`) {
+		return ""
+	}
+	rest = rest[1:]
+
+	var b strings.Builder
+	for j := 0; j < len(rest); j++ {
+		c := rest[j]
+		switch {
+		case c == '"':
+			return b.String()
+		case c != '\\':
+			b.WriteByte(c)
+		case j+1 >= len(rest):
+			return b.String() // экранирование оборвано на середине
+		default:
+			j++
+			switch rest[j] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+			case 'u':
+				if j+4 >= len(rest) {
+					return b.String()
+				}
+				if r, err := strconv.ParseUint(rest[j+1:j+5], 16, 32); err == nil {
+					b.WriteRune(rune(r))
+				}
+				j += 4
+			default:
+				b.WriteByte(rest[j])
+			}
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,400 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+/*
+Провайдеры LLM. Любой OpenAI-совместимый сервер (llama.cpp, Ollama, vLLM, Together …)
+подключается только через env — достаточно добавить имя в LLM_PROVIDER_CHAIN и задать
+<ИМЯ>_BASE_URL:
+
+LLM_PROVIDER_CHAIN=local,groq,openrouter
+
+LOCAL_BASE_URL=http://127.0.0.1:8080/v1
+LOCAL_MODEL=qwen2.5-7b-instruct
+LOCAL_API_KEY=                       # необязателен, если сервер без авторизации
+LOCAL_HEADERS=X-Title=Poster Bot;X-Env=prod
+LOCAL_JSON_MODE=0                    # сервер не понимает response_format — просим JSON только промптом
+
+Имя приводится к верхнему регистру, "-" и "." заменяются на "_" (together.ai → TOGETHER_AI_*).
+*/
+
+// CompletionOptions — параметры генерации
+type CompletionOptions struct {
+	Temperature float64
+	MaxTokens   int
+	JSON        bool // ответ — один JSON-объект (response_format, если провайдер умеет)
+}
+
+// Completion — ответ модели
+type Completion struct {
+	Text             string
+	Provider         string
+	Model            string
+	PromptTokens     int
+	CompletionTokens int
+}
+
+// Provider — источник ответов LLM
+type Provider interface {
+	Name() string
+	Complete(ctx context.Context, messages []Message, opts CompletionOptions) (Completion, error)
+}
+
+// StreamingProvider — провайдер, умеющий отдавать ответ по кусочкам (SSE).
+// onDelta вызывается с каждым новым фрагментом текста.
+type StreamingProvider interface {
+	Provider
+	Stream(ctx context.Context, messages []Message, opts CompletionOptions, onDelta func(delta string)) (Completion, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = map[string]Provider{}
+)
+
+// RegisterProvider добавляет провайдера в реестр (перекрывает env-настройки с тем же именем)
+func RegisterProvider(p Provider) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[strings.ToLower(p.Name())] = p
+}
+
+// Встроенные значения по умолчанию для известных провайдеров
+var builtinProviders = map[string]OpenAICompatProvider{
+	"groq": {
+		BaseURL:    "https://api.groq.com/openai/v1",
+		Model:      "llama3-70b-8192",
+		RequireKey: true,
+	},
+	"openrouter": {
+		BaseURL: "https://openrouter.ai/api/v1",
+		Model:   "meta-llama/llama-3.1-70b-instruct",
+		Headers: map[string]string{
+			"HTTP-Referer": "https://t.me/poster_refact_bot",
+			"X-Title":      "Poster Bot",
+		},
+		RequireKey: true,
+	},
+}
+
+func envPrefix(name string) string {
+	return strings.NewReplacer("-", "_", ".", "_").Replace(strings.ToUpper(name)) + "_"
+}
+
+// parseHeaders: "A=1;B=2"
+func parseHeaders(raw string) map[string]string {
+	out := map[string]string{}
+	for _, kv := range strings.Split(raw, ";") {
+		k, v, ok := strings.Cut(kv, "=")
+		if ok && strings.TrimSpace(k) != "" {
+			out[strings.TrimSpace(k)] = strings.TrimSpace(v)
+		}
+	}
+	return out
+}
+
+// providerFromEnv собирает OpenAI-совместимого провайдера из <ИМЯ>_* переменных.
+// false — провайдер с таким именем не настроен.
+func providerFromEnv(name string) (Provider, bool) {
+	prefix := envPrefix(name)
+	p, builtin := builtinProviders[name]
+	if !builtin && os.Getenv(prefix+"BASE_URL") == "" {
+		return nil, false
+	}
+
+	p.ProviderName = name
+	p.BaseURL = getEnv(prefix+"BASE_URL", p.BaseURL)
+	p.Model = getEnv(prefix+"MODEL", p.Model)
+	p.APIKey = getEnv(prefix+"API_KEY", "")
+	p.NoJSONMode = os.Getenv(prefix+"JSON_MODE") == "0"
+	if raw := os.Getenv(prefix + "HEADERS"); raw != "" {
+		headers := map[string]string{}
+		for k, v := range p.Headers {
+			headers[k] = v
+		}
+		for k, v := range parseHeaders(raw) {
+			headers[k] = v
+		}
+		p.Headers = headers
+	}
+	return &p, true
+}
+
+// LookupProvider ищет провайдера в реестре, затем в env
+func LookupProvider(name string) (Provider, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	registryMu.RLock()
+	p, ok := registry[name]
+	registryMu.RUnlock()
+	if ok {
+		return p, true
+	}
+	return providerFromEnv(name)
+}
+
+// ProviderChain — провайдеры из LLM_PROVIDER_CHAIN в порядке фоллбэка.
+// Ненастроенные имена пропускаются с предупреждением в логе.
+func ProviderChain() []Provider {
+	var out []Provider
+	for _, name := range strings.Split(getEnv("LLM_PROVIDER_CHAIN", "groq,openrouter"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		p, ok := LookupProvider(name)
+		if !ok {
+			fmt.Printf("⚠️ llm[%s]: провайдер не настроен (нет %sBASE_URL), пропускаем\n", name, envPrefix(name))
+			continue
+		}
+		out = append(out, p)
+	}
+	return out
+}
+
+// ProviderNames — имена провайдеров цепочки (для выбора своей модели канала)
+func ProviderNames() []string {
+	var names []string
+	for _, p := range ProviderChain() {
+		names = append(names, strings.ToLower(p.Name()))
+	}
+	return names
+}
+
+// ModelChoice — своя модель канала: провайдер из реестра или env и, по желанию, модель
+type ModelChoice struct {
+	Provider string
+	Model    string // "" — модель провайдера по умолчанию
+}
+
+type modelChoiceKey struct{}
+
+// WithModelChoice — вызовы LLM в ctx сначала идут к выбранным провайдеру и модели,
+// общая цепочка остаётся запасной
+func WithModelChoice(ctx context.Context, choice ModelChoice) context.Context {
+	return context.WithValue(ctx, modelChoiceKey{}, choice)
+}
+
+// providerChainFor — цепочка для вызова: провайдер, выбранный в ctx, первым
+func providerChainFor(ctx context.Context) []Provider {
+	chain := ProviderChain()
+	choice, _ := ctx.Value(modelChoiceKey{}).(ModelChoice)
+	if choice.Provider == "" {
+		return chain
+	}
+	p, ok := LookupProvider(choice.Provider)
+	if !ok {
+		fmt.Printf("⚠️ llm[%s]: выбранный каналом провайдер не настроен, берём общую цепочку\n", choice.Provider)
+		return chain
+	}
+	if oc, ok := p.(*OpenAICompatProvider); ok && choice.Model != "" {
+		custom := *oc
+		custom.Model = choice.Model
+		p = &custom
+	}
+	out := []Provider{p}
+	for _, q := range chain {
+		if !strings.EqualFold(q.Name(), p.Name()) {
+			out = append(out, q)
+		}
+	}
+	return out
+}
+
+// HasConfiguredProvider — в цепочке есть провайдер, которого реально можно вызвать:
+// с ключом API или с явно заданным <ИМЯ>_BASE_URL (свой сервер может работать без ключа).
+// Встроенные groq/openrouter попадают в цепочку и без ключа, поэтому одной длины цепочки мало.
+func HasConfiguredProvider() bool {
+	for _, p := range ProviderChain() {
+		oc, ok := p.(*OpenAICompatProvider)
+		if !ok {
+			// зарегистрирован из кода — считаем настроенным
+			return true
+		}
+		if oc.APIKey != "" || os.Getenv(envPrefix(oc.ProviderName)+"BASE_URL") != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// OpenAICompatProvider — любой сервер с OpenAI-совместимым /chat/completions
+type OpenAICompatProvider struct {
+	ProviderName string
+	BaseURL      string
+	APIKey       string
+	Model        string
+	Headers      map[string]string
+	RequireKey   bool
+	NoJSONMode   bool // не отправлять response_format
+}
+
+func (p *OpenAICompatProvider) Name() string { return p.ProviderName }
+
+func (p *OpenAICompatProvider) Complete(ctx context.Context, messages []Message, opts CompletionOptions) (Completion, error) {
+	resp, err := p.post(ctx, ChatRequest{
+		Model:          p.Model,
+		Messages:       messages,
+		Temperature:    opts.Temperature,
+		MaxTokens:      opts.MaxTokens,
+		ResponseFormat: p.responseFormat(opts),
+	})
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	var parsed ChatResponse
+	if err := json.Unmarshal(bodyBytes, &parsed); err != nil {
+		return Completion{}, fmt.Errorf("json: %v; raw=%s", err, truncate(bodyBytes, 400))
+	}
+	if len(parsed.Choices) == 0 {
+		return Completion{}, fmt.Errorf("empty choices: %s", truncate(bodyBytes, 400))
+	}
+
+	return Completion{
+		Text:             strings.TrimSpace(parsed.Choices[0].Message.Content),
+		Provider:         p.ProviderName,
+		Model:            p.Model,
+		PromptTokens:     parsed.Usage.PromptTokens,
+		CompletionTokens: parsed.Usage.CompletionTokens,
+	}, nil
+}
+
+// streamChunk — одно событие SSE из /chat/completions со stream=true
+type streamChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+func (p *OpenAICompatProvider) Stream(ctx context.Context, messages []Message, opts CompletionOptions, onDelta func(delta string)) (Completion, error) {
+	resp, err := p.post(ctx, ChatRequest{
+		Model:          p.Model,
+		Messages:       messages,
+		Temperature:    opts.Temperature,
+		MaxTokens:      opts.MaxTokens,
+		Stream:         true,
+		StreamOptions:  &StreamOptions{IncludeUsage: true},
+		ResponseFormat: p.responseFormat(opts),
+	})
+	if err != nil {
+		return Completion{}, err
+	}
+	defer resp.Body.Close()
+
+	res := Completion{Provider: p.ProviderName, Model: p.Model}
+	var text strings.Builder
+	done := false
+
+	sc := bufio.NewScanner(resp.Body)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		// пустые строки разделяют события, ":" — комментарии/keep-alive
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			done = true
+			break
+		}
+
+		var chunk streamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return Completion{}, fmt.Errorf("stream json: %v; raw=%s", err, truncate([]byte(data), 400))
+		}
+		if chunk.Error != nil {
+			return Completion{}, fmt.Errorf("stream error: %s", chunk.Error.Message)
+		}
+		if chunk.Usage != nil {
+			res.PromptTokens = chunk.Usage.PromptTokens
+			res.CompletionTokens = chunk.Usage.CompletionTokens
+		}
+		for _, c := range chunk.Choices {
+			if c.Delta.Content != "" {
+				text.WriteString(c.Delta.Content)
+				onDelta(c.Delta.Content)
+			}
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return Completion{}, fmt.Errorf("stream read: %w", err)
+	}
+	if !done {
+		return Completion{}, fmt.Errorf("stream оборвался без [DONE]")
+	}
+
+	res.Text = strings.TrimSpace(text.String())
+	if res.Text == "" {
+		return Completion{}, fmt.Errorf("empty stream")
+	}
+	return res, nil
+}
+
+func (p *OpenAICompatProvider) responseFormat(opts CompletionOptions) *ResponseFormat {
+	if !opts.JSON || p.NoJSONMode {
+		return nil
+	}
+	return &ResponseFormat{Type: "json_object"}
+}
+
+// post отправляет запрос на /chat/completions; не-2xx превращается в ошибку
+func (p *OpenAICompatProvider) post(ctx context.Context, body ChatRequest) (*http.Response, error) {
+	if p.BaseURL == "" {
+		return nil, fmt.Errorf("%sBASE_URL is empty", envPrefix(p.ProviderName))
+	}
+	if p.RequireKey && p.APIKey == "" {
+		return nil, fmt.Errorf("%sAPI_KEY is empty", envPrefix(p.ProviderName))
+	}
+	url := strings.TrimRight(p.BaseURL, "/") + "/chat/completions"
+	bs, _ := json.Marshal(body)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(bs))
+	if err != nil {
+		return nil, err
+	}
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if body.Stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	for k, v := range p.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		// Вернём статус и кусок тела — чтобы в логах было видно истинную причину (401/404/429/400 …)
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return nil, fmt.Errorf("bad status %d: %s", resp.StatusCode, truncate(bodyBytes, 400))
+	}
+	return resp, nil
+}
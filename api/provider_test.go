@@ -0,0 +1,48 @@
+package api
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+type fakeProvider struct{ name string }
+
+func (p fakeProvider) Name() string { return p.name }
+func (p fakeProvider) Complete(context.Context, []Message, CompletionOptions) (Completion, error) {
+	return Completion{Provider: p.name}, nil
+}
+
+func TestProviderChainFor(t *testing.T) {
+	RegisterProvider(fakeProvider{"fake-a"})
+	RegisterProvider(fakeProvider{"fake-b"})
+	t.Setenv("LLM_PROVIDER_CHAIN", "fake-a,fake-b")
+	t.Setenv("MINE_BASE_URL", "http://127.0.0.1:8080/v1")
+
+	tests := []struct {
+		name   string
+		choice ModelChoice
+		want   []string
+		model  string // модель первого провайдера, если он OpenAI-совместимый
+	}{
+		{"без выбора — общая цепочка", ModelChoice{}, []string{"fake-a", "fake-b"}, ""},
+		{"выбранный из цепочки — первым, без повтора", ModelChoice{Provider: "fake-b"}, []string{"fake-b", "fake-a"}, ""},
+		{"свой сервер со своей моделью", ModelChoice{Provider: "mine", Model: "qwen2.5"}, []string{"mine", "fake-a", "fake-b"}, "qwen2.5"},
+		{"ненастроенный — общая цепочка", ModelChoice{Provider: "nope"}, []string{"fake-a", "fake-b"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			chain := providerChainFor(WithModelChoice(context.Background(), tt.choice))
+			var names []string
+			for _, p := range chain {
+				names = append(names, p.Name())
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Fatalf("цепочка %v, want %v", names, tt.want)
+			}
+			if oc, ok := chain[0].(*OpenAICompatProvider); ok && oc.Model != tt.model {
+				t.Errorf("модель %q, want %q", oc.Model, tt.model)
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package api
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/*
+Учёт расходов на LLM. Каждая попытка у каждого провайдера передаётся в UsageRecorder
+(main пишет её в таблицу llm_usage).
+
+Цены — долларов за 1M токенов, вход/выход, по имени модели:
+LLM_PRICES=llama3-70b-8192=0.59/0.79;meta-llama/llama-3.1-70b-instruct=0.52/0.75
+Модели без цены учитываются с нулевой стоимостью (токены всё равно сохраняются).
+*/
+
+// Назначение вызова
+const (
+	PurposeGenerate  = "generate"
+	PurposeTranslate = "translate"
+)
+
+// UsageMeta — к кому относится вызов: кладётся в context вызывающим
+type UsageMeta struct {
+	ChannelID int64
+	ClientID  int64
+}
+
+type usageKey struct{}
+
+// WithUsageMeta помечает все вызовы LLM в ctx каналом и клиентом
+func WithUsageMeta(ctx context.Context, meta UsageMeta) context.Context {
+	return context.WithValue(ctx, usageKey{}, meta)
+}
+
+func usageMeta(ctx context.Context) UsageMeta {
+	meta, _ := ctx.Value(usageKey{}).(UsageMeta)
+	return meta
+}
+
+// Usage — одна попытка вызова LLM
+type Usage struct {
+	Provider         string
+	Model            string
+	Purpose          string
+	PromptTokens     int
+	CompletionTokens int
+	Latency          time.Duration
+	CostUSD          float64
+	Error            string
+	ChannelID        int64
+	ClientID         int64
+}
+
+var (
+	recorderMu sync.RWMutex
+	recorder   func(Usage)
+)
+
+// SetUsageRecorder задаёт, куда сохранять учёт вызовов
+func SetUsageRecorder(fn func(Usage)) {
+	recorderMu.Lock()
+	defer recorderMu.Unlock()
+	recorder = fn
+}
+
+// ModelPrice — цена модели из LLM_PRICES (USD за 1M входных/выходных токенов)
+func ModelPrice(model string) (in, out float64, ok bool) {
+	for _, item := range strings.Split(os.Getenv("LLM_PRICES"), ";") {
+		name, price, found := strings.Cut(strings.TrimSpace(item), "=")
+		if !found || strings.TrimSpace(name) != model {
+			continue
+		}
+		inStr, outStr, _ := strings.Cut(price, "/")
+		in, err1 := strconv.ParseFloat(strings.TrimSpace(inStr), 64)
+		out, err2 := strconv.ParseFloat(strings.TrimSpace(outStr), 64)
+		if err1 != nil || err2 != nil {
+			return 0, 0, false
+		}
+		return in, out, true
+	}
+	return 0, 0, false
+}
+
+// recordUsage фиксирует попытку: res — ответ (при ошибке может быть пустым)
+func recordUsage(ctx context.Context, p Provider, purpose string, res Completion, err error, latency time.Duration) {
+	recorderMu.RLock()
+	fn := recorder
+	recorderMu.RUnlock()
+	if fn == nil {
+		return
+	}
+
+	model := res.Model
+	if op, ok := p.(*OpenAICompatProvider); ok && model == "" {
+		model = op.Model
+	}
+	meta := usageMeta(ctx)
+	u := Usage{
+		Provider:         p.Name(),
+		Model:            model,
+		Purpose:          purpose,
+		PromptTokens:     res.PromptTokens,
+		CompletionTokens: res.CompletionTokens,
+		Latency:          latency,
+		ChannelID:        meta.ChannelID,
+		ClientID:         meta.ClientID,
+	}
+	if in, out, ok := ModelPrice(model); ok {
+		u.CostUSD = (float64(u.PromptTokens)*in + float64(u.CompletionTokens)*out) / 1_000_000
+	}
+	if err != nil {
+		u.Error = truncate([]byte(err.Error()), 500)
+	}
+	fn(u)
+}
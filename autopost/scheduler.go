@@ -1,22 +1,70 @@
 package autopost
 
 import (
+	"context"
 	"database/sql"
+	"log"
 	"time"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"mybot/bot2"
+	"mybot/db"
 )
 
-func Start(bot *tgbotapi.BotAPI, db *sql.DB) {
+// На сколько вперёд разворачиваем регулярные расписания в конкретные посты
+const recurringHorizon = 48 * time.Hour
+
+// Start запускает фоновую публикацию; останавливается, когда ctx отменён
+func Start(ctx context.Context, bot *tgbotapi.BotAPI, db *sql.DB) {
 	ticker := time.NewTicker(30 * time.Second)
+	recurringTicker := time.NewTicker(5 * time.Minute)
 
 	go func() {
+		defer ticker.Stop()
 		for {
 			select {
+			case <-ctx.Done():
+				log.Println("🛑 Автопостинг остановлен")
+				return
 			case <-ticker.C:
-				bot2.PublishScheduledPosts(bot, db)
+				bot2.PublishScheduledPosts(ctx, bot, db)
+			}
+		}
+	}()
+
+	// Расписания — отдельно: генерация черновиков небыстрая и не должна задерживать публикацию
+	go func() {
+		defer recurringTicker.Stop()
+
+		expandRecurring(ctx, bot, db)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-recurringTicker.C:
+				expandRecurring(ctx, bot, db)
 			}
 		}
 	}()
 }
+
+// expandRecurring разворачивает расписания в черновики и готовит их владельцу на одобрение;
+// черновики, которые так и не одобрили к сроку, закрываются
+func expandRecurring(ctx context.Context, bot *tgbotapi.BotAPI, conn *sql.DB) {
+	expired, err := db.ExpireUnapprovedDrafts(conn, time.Now())
+	if err != nil {
+		log.Println("❌ Ошибка при закрытии неодобренных черновиков:", err)
+	}
+	if expired > 0 {
+		log.Printf("⌛ Не одобрено к сроку, закрыто черновиков: %d", expired)
+	}
+
+	n, err := db.ExpandRecurringRules(conn, time.Now(), recurringHorizon)
+	if err != nil {
+		log.Println("❌ Ошибка при развёртывании регулярных расписаний:", err)
+	}
+	if n > 0 {
+		log.Printf("🔁 Из регулярных расписаний создано черновиков: %d", n)
+	}
+	bot2.PrepareRecurringDrafts(ctx, bot, conn)
+}
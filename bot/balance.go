@@ -0,0 +1,82 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"mybot/db"
+	"mybot/sub"
+)
+
+// /balance — баланс и подписка каналов владельца
+
+// Сколько последних операций показываем по каждому каналу
+const balanceHistoryRows = 5
+
+func sendBalance(chatID int64) {
+	channels, err := db.GetChannelsByUser(database, chatID)
+	if err != nil {
+		log.Printf("❌ Не удалось получить каналы chat_id=%d: %v", chatID, err)
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось получить баланс."))
+		return
+	}
+	if len(channels) == 0 {
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Каналы не найдены. Сначала привяжи хотя бы один канал."))
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("💰 Баланс каналов\n")
+	for _, c := range channels {
+		ch, err := db.GetChannelByID(database, c.ID)
+		if err != nil {
+			continue
+		}
+		balance, err := db.GetChannelBalance(database, int64(ch.ID))
+		if err != nil {
+			log.Printf("⚠️ Не удалось получить баланс канала id=%d: %v", ch.ID, err)
+			continue
+		}
+
+		fmt.Fprintf(&b, "\n@%s — %s TON\n", ch.ChannelTitle, sub.FormatTON(balance))
+		if plan, err := sub.ChannelPlan(database, &ch); err == nil {
+			fmt.Fprintf(&b, "Тариф: %s (%s TON / %d дн.)\n", plan.Name, plan.PriceTON, plan.DurationDays)
+		}
+		if sub.IsSubscriptionActive(&ch) {
+			fmt.Fprintf(&b, "Подписка до %s\n", ch.SubscriptionUntil.In(clientLocation(chatID)).Format("02.01.06 15:04"))
+		} else {
+			b.WriteString("Подписка неактивна\n")
+		}
+
+		entries, err := db.GetChannelCredits(database, int64(ch.ID), balanceHistoryRows)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			sign := "+"
+			if e.AmountNano.Sign() < 0 {
+				sign = ""
+			}
+			fmt.Fprintf(&b, "  %s %s%s TON · %s\n", e.CreatedAt.In(clientLocation(chatID)).Format("02.01.06"),
+				sign, sub.FormatTON(e.AmountNano), creditKindTitle(e.Kind))
+		}
+	}
+	b.WriteString("\nНеполные платежи копятся на балансе, переплата идёт в счёт следующего продления.")
+
+	Bot.Send(tgbotapi.NewMessage(chatID, b.String()))
+}
+
+func creditKindTitle(kind string) string {
+	switch kind {
+	case db.CreditPayment:
+		return "платёж"
+	case db.CreditCharge:
+		return "оплата периода"
+	case db.CreditRefund:
+		return "возврат"
+	}
+	return kind
+}
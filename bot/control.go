@@ -1,16 +1,18 @@
 package bot
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
 
 	"log"
-	"mybot/api"
 	"mybot/bot2"
-	"mybot/pexels"
+	"mybot/format"
+	"mybot/images"
 	"mybot/sub"
 	"strconv"
 	"strings"
@@ -26,12 +28,16 @@ import (
 var Bot *tgbotapi.BotAPI
 var database *sql.DB
 
+// appCtx отменяется при остановке бота; от него считаются дедлайны генерации
+var appCtx = context.Background()
+
 var sessions *session.Manager
 var postCache = make(map[int64][]db.ScheduledPost)
 
-func SetupHandlers(bot *tgbotapi.BotAPI, conn *sql.DB) {
+func SetupHandlers(ctx context.Context, bot *tgbotapi.BotAPI, conn *sql.DB) {
 	Bot = bot
 	database = conn
+	appCtx = ctx
 	sub.SetDB(conn)
 	sessions = session.NewManager()
 
@@ -39,8 +45,22 @@ func SetupHandlers(bot *tgbotapi.BotAPI, conn *sql.DB) {
 	u.Timeout = 60
 
 	updates := bot.GetUpdatesChan(u)
+	go func() {
+		<-ctx.Done()
+		bot.StopReceivingUpdates()
+	}()
 
 	for update := range updates {
+		// оплата в Telegram Stars: подтверждение перед списанием и результат
+		if update.PreCheckoutQuery != nil {
+			handlePreCheckout(update.PreCheckoutQuery)
+			continue
+		}
+		if update.Message != nil && update.Message.SuccessfulPayment != nil {
+			sub.ProcessStarsPayment(Bot, database, update.Message)
+			continue
+		}
+
 		if update.Message != nil {
 			chatID := update.Message.Chat.ID
 			s := sessions.Get(chatID)
@@ -49,6 +69,10 @@ func SetupHandlers(bot *tgbotapi.BotAPI, conn *sql.DB) {
 				s.Data = make(map[string]string)
 			}
 
+			if update.Message.IsCommand() && handleInfoCommand(update.Message) {
+				continue
+			}
+
 			if s.State != "" || len(update.Message.Photo) > 0 {
 				handleState(update, Bot, s, database)
 				continue
@@ -171,7 +195,7 @@ func allowAccess(requesterUsername string, channel db.Channel, chatID int64) boo
 	}
 
 	log.Printf("⛔ Доступ запрещён: нет активной подписки у канала @%s", channel.ChannelTitle)
-	sub.SendPaymentPrompt(Bot, chatID, channel.ChannelTitle)
+	sub.SendPaymentPrompt(Bot, database, chatID, channel.ChannelTitle)
 	return false
 }
 
@@ -217,10 +241,10 @@ func handleText(msg *tgbotapi.Message, s *session.Session) {
 	if s.State == "choosing_channel" {
 		log.Println("📡 Обработка выбора канала...")
 
-		// проверяем доступ к выбранному каналу (креатор/активная подписка)
+		// проверяем доступ к выбранному каналу (креатор/активная подписка, лимит генераций)
 		if id, err := channelIDByUsername(database, text); err == nil {
 			if ch, err := db.GetChannelByID(database, id); err == nil {
-				if !allowAccess(msg.From.UserName, ch, chatID) {
+				if !allowGeneration(msg.From.UserName, ch, chatID) {
 					return
 				}
 			}
@@ -264,9 +288,10 @@ func handleText(msg *tgbotapi.Message, s *session.Session) {
 			return
 		}
 
+		loc := clientLocation(chatID)
 		var list string
 		for i, post := range posts {
-			list += fmt.Sprintf("%d — %s, %s\n", i+1, post.Content, post.PostAt.Format("02.01.06 15:04"))
+			list += fmt.Sprintf("%d — %s, %s\n", i+1, bot2.PostSummary(&post), post.PostAt.In(loc).Format("02.01.06 15:04"))
 		}
 		postCache[chatID] = posts
 
@@ -282,7 +307,7 @@ func handleText(msg *tgbotapi.Message, s *session.Session) {
 		if username := s.Data["channel_username"]; username != "" {
 			if channelID, err := db.GetChannelIDByUsername(database, username); err == nil {
 				if channel, err := db.GetChannelByID(database, channelID); err == nil {
-					if !allowAccess(msg.From.UserName, channel, chatID) {
+					if !allowGeneration(msg.From.UserName, channel, chatID) {
 						return
 					}
 				}
@@ -453,6 +478,11 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 	}
 	log.Printf("▶️ handleState: %s | text: %s", s.State, text)
 
+	if strings.HasPrefix(s.State, "recurring_") {
+		handleRecurringState(msg, s)
+		return
+	}
+
 	switch s.State {
 
 	case "choosing_channel":
@@ -461,19 +491,19 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 		s.Data["channel_username"] = text
 		s.State = "main_menu"
 
-		msg := tgbotapi.NewMessage(chatID, "✅ Канал выбран: "+text+"\nВыберите, что хотите сделать:")
+		msg := tgbotapi.NewMessage(chatID, "✅ Канал выбран: "+text+quotaLine(text)+"\n\nВыберите, что хотите сделать:")
 		msg.ReplyMarkup = bot2.MainKeyboardWithBack()
 		Bot.Send(msg)
 
 	case "main_menu":
 		switch text {
 		case "📥 Сгенерировать пост":
-			// не даём генерировать, если у выбранного канала нет подписки
+			// не даём генерировать, если у выбранного канала нет подписки или кончился лимит
 			if username := s.Data["channel_username"]; username != "" {
 				channelID, err := db.GetChannelIDByUsername(database, username)
 				if err == nil {
 					if channel, err := db.GetChannelByID(database, channelID); err == nil {
-						if !allowAccess(msg.From.UserName, channel, chatID) {
+						if !allowGeneration(msg.From.UserName, channel, chatID) {
 							return
 						}
 					}
@@ -491,7 +521,7 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 				if err == nil {
 					channel, err := db.GetChannelByID(database, channelID)
 					if err == nil {
-						if !allowAccess(msg.From.UserName, channel, chatID) {
+						if !allowGeneration(msg.From.UserName, channel, chatID) || !allowMorePosts(channel, chatID) {
 							return
 						}
 					}
@@ -536,9 +566,10 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 
 			postCache[chatID] = posts
 
+			loc := clientLocation(chatID)
 			var list string
 			for i, post := range posts {
-				list += fmt.Sprintf("%d — %s, %s\n", i+1, post.Content, post.PostAt.Format("02.01.06 15:04"))
+				list += fmt.Sprintf("%d — %s, %s\n", i+1, bot2.PostSummary(&post), post.PostAt.In(loc).Format("02.01.06 15:04"))
 			}
 
 			msg := tgbotapi.NewMessage(chatID, "Ваши посты:\n\n"+list)
@@ -574,6 +605,7 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 
 			postCache[chatID] = posts
 
+			loc := clientLocation(chatID)
 			var list string
 			for i, post := range posts {
 				imgType := "(pexels)"
@@ -583,7 +615,7 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 				content := fmt.Sprintf("📝 Тема: %s\n✍️ Стиль: %s\n🌐 Язык: %s\n📄 Длина: %s",
 					post.Theme, post.Style, post.Language, post.Length)
 
-				list += fmt.Sprintf("%d — %s, %s %s\n", i+1, content, post.PostAt.Format("02.01.06 15:04"), imgType)
+				list += fmt.Sprintf("%d — %s, %s %s\n", i+1, content, post.PostAt.In(loc).Format("02.01.06 15:04"), imgType)
 			}
 
 			msg := tgbotapi.NewMessage(chatID, "Выберите пост для редактирования:\n\n"+list)
@@ -592,6 +624,76 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 
 			s.State = "editing_list"
 
+		case "🔁 Регулярные посты":
+			username := s.Data["channel_username"]
+			if username == "" {
+				Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не выбран."))
+				return
+			}
+			channelID, err := db.GetChannelIDByUsername(database, username)
+			if err != nil {
+				Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не найден."))
+				return
+			}
+			if channel, err := db.GetChannelByID(database, channelID); err == nil {
+				if !allowAccess(msg.From.UserName, channel, chatID) || !allowRecurring(channel, chatID) {
+					return
+				}
+			}
+
+			showRecurringMenu(chatID, s)
+
+		case "🌍 Часовой пояс":
+			s.State = "choosing_timezone"
+			msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(
+				"🌍 Сейчас: %s\nВыберите часовой пояс или пришлите свой (например, Asia/Yekaterinburg):",
+				clientLocation(chatID)))
+			msg.ReplyMarkup = bot2.Timezone
+			Bot.Send(msg)
+
+		case "🔤 Форматирование":
+			channelID, err := db.GetChannelIDByUsername(database, s.Data["channel_username"])
+			if err != nil {
+				Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не выбран."))
+				return
+			}
+			current := format.ModeHTML
+			if ch, err := db.GetChannelByID(database, channelID); err == nil {
+				current = format.NormalizeMode(ch.ParseMode)
+			}
+
+			s.State = "choosing_parse_mode"
+			msg := tgbotapi.NewMessage(chatID, "🔤 Как оформлять посты канала? Сейчас: "+current+
+				"\nЕсли Telegram не примет разметку, пост уйдёт простым текстом.")
+			msg.ReplyMarkup = bot2.ParseMode
+			Bot.Send(msg)
+
+		case "🖼 Картинки":
+			channelID, err := db.GetChannelIDByUsername(database, s.Data["channel_username"])
+			if err != nil {
+				Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не выбран."))
+				return
+			}
+			current := "по умолчанию (" + strings.Join(images.DefaultOrder(), ", ") + ")"
+			credit := false
+			if ch, err := db.GetChannelByID(database, channelID); err == nil {
+				if ch.ImageProviders != "" {
+					current = strings.ReplaceAll(ch.ImageProviders, ",", ", ")
+				}
+				credit = ch.ImageCredit
+			}
+
+			s.State = "choosing_image_providers"
+			msg := tgbotapi.NewMessage(chatID, "🖼 Откуда брать картинки для постов? Сейчас: "+current+
+				"\nИсточники: "+strings.Join(images.Names, ", ")+" (local — папка с фирменными картинками, ai — генерация нейросетью)."+
+				"\nПришлите порядок через запятую — если первый ничего не найдёт, возьмём следующий."+
+				"\n\n"+imageCreditStatus(credit)+" — кнопка «"+bot2.ImageCreditButton+"» переключает.")
+			msg.ReplyMarkup = bot2.ImageProviders
+			Bot.Send(msg)
+
+		case "🧠 Модель":
+			showModelMenu(chatID, s, msg.From.UserName)
+
 		case "🔄 Сменить канал":
 			channels, err := safeGetUserChannels(database, chatID, s)
 			if err != nil || len(channels) == 0 {
@@ -608,6 +710,88 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 			Bot.Send(tgbotapi.NewMessage(chatID, "Пожалуйста, выбери опцию из меню."))
 		}
 
+	case "choosing_timezone":
+		tz := strings.TrimSpace(text)
+		loc, err := time.LoadLocation(tz)
+		if err != nil || tz == "" || strings.EqualFold(tz, "local") {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Неизвестный часовой пояс. Пример: Europe/Moscow"))
+			return
+		}
+		if err := db.SetClientTimezone(database, chatID, loc.String()); err != nil {
+			log.Printf("❌ Не удалось сохранить часовой пояс для chat_id=%d: %v", chatID, err)
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось сохранить часовой пояс."))
+			return
+		}
+
+		s.State = "main_menu"
+		msg := tgbotapi.NewMessage(chatID, fmt.Sprintf("✅ Часовой пояс: %s (сейчас там %s)",
+			loc, time.Now().In(loc).Format("15:04")))
+		msg.ReplyMarkup = bot2.MainKeyboardWithBack()
+		Bot.Send(msg)
+
+	case "choosing_parse_mode":
+		mode := map[string]string{
+			"HTML":               format.ModeHTML,
+			"MarkdownV2":         format.ModeMarkdownV2,
+			"Без форматирования": format.ModePlain,
+		}[text]
+		if mode == "" {
+			Bot.Send(tgbotapi.NewMessage(chatID, "Пожалуйста, выбери вариант на клавиатуре."))
+			return
+		}
+		channelID, err := db.GetChannelIDByUsername(database, s.Data["channel_username"])
+		if err != nil {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не найден."))
+			return
+		}
+		if err := db.SetChannelParseMode(database, channelID, mode); err != nil {
+			log.Printf("❌ Не удалось сохранить parse_mode для channel_id=%d: %v", channelID, err)
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось сохранить настройку."))
+			return
+		}
+
+		s.State = "main_menu"
+		msg := tgbotapi.NewMessage(chatID, "✅ Форматирование: "+text)
+		msg.ReplyMarkup = bot2.MainKeyboardWithBack()
+		Bot.Send(msg)
+
+	case "choosing_image_providers":
+		if text == bot2.ImageCreditButton {
+			toggleImageCredit(chatID, s)
+			return
+		}
+		var order []string
+		if text != "По умолчанию" {
+			var err error
+			order, err = images.ParseOrder(text)
+			if err != nil || len(order) == 0 {
+				Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не понял порядок. Пример: unsplash, pexels, local"))
+				return
+			}
+		}
+		channelID, err := db.GetChannelIDByUsername(database, s.Data["channel_username"])
+		if err != nil {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не найден."))
+			return
+		}
+		if err := db.SetChannelImageProviders(database, channelID, strings.Join(order, ",")); err != nil {
+			log.Printf("❌ Не удалось сохранить image_providers для channel_id=%d: %v", channelID, err)
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось сохранить настройку."))
+			return
+		}
+
+		s.State = "main_menu"
+		result := text
+		if len(order) > 0 {
+			result = strings.Join(order, ", ")
+		}
+		msg := tgbotapi.NewMessage(chatID, "✅ Картинки: "+result)
+		msg.ReplyMarkup = bot2.MainKeyboardWithBack()
+		Bot.Send(msg)
+
+	case "choosing_llm_model":
+		handleModelChoice(chatID, text, s)
+
 	case "waiting_for_topic":
 		s.Data["theme"] = text
 		delete(s.Data, "photo")
@@ -624,7 +808,7 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 	case "editing_list":
 		if text == "⬅️ Назад" {
 			s.State = "main_menu"
-			msg := tgbotapi.NewMessage(chatID, "Выберите действие:")
+			msg := tgbotapi.NewMessage(chatID, "Выберите действие:"+quotaLine(s.Data["channel_username"]))
 			msg.ReplyMarkup = bot2.MainKeyboardWithBack()
 			Bot.Send(msg)
 			return
@@ -681,7 +865,7 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 			msg.ReplyMarkup = tgbotapi.NewReplyKeyboard(
 				tgbotapi.NewKeyboardButtonRow(
 					tgbotapi.NewKeyboardButton("📤 Загрузить свою"),
-					tgbotapi.NewKeyboardButton("🖼 Взять из Pexels"),
+					tgbotapi.NewKeyboardButton("🖼 Подобрать автоматически"),
 				),
 				tgbotapi.NewKeyboardButtonRow(
 					tgbotapi.NewKeyboardButton("⬅️ Назад"),
@@ -696,13 +880,14 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 			posts, _ := db.GetScheduledPostsByChannelID(database, int64(channelID))
 			postCache[chatID] = posts
 
+			loc := clientLocation(chatID)
 			var list string
 			for i, post := range posts {
 				imgType := "(pexels)"
 				if post.Photo != "" {
 					imgType = "(Ваше фото)"
 				}
-				list += fmt.Sprintf("%d — %s, %s %s\n", i+1, post.Content, post.PostAt.Format("02.01.06 15:04"), imgType)
+				list += fmt.Sprintf("%d — %s, %s %s\n", i+1, bot2.PostSummary(&post), post.PostAt.In(loc).Format("02.01.06 15:04"), imgType)
 			}
 
 			msg := tgbotapi.NewMessage(chatID, "Выберите пост для редактирования:\n\n"+list)
@@ -753,7 +938,7 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 		msg.ReplyMarkup = bot2.MainKeyboardWithBack()
 		Bot.Send(msg)
 
-		// Генерация и публикация в фоне
+		// Генерация в фоне: текст появится в чате, публикация — после подтверждения
 		go generatePost(s, chatID, fileID)
 
 	case "scheduling_date":
@@ -819,7 +1004,7 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 	case "scheduling_length":
 		s.Data["length"] = text
 
-		postAt, err := parseDateTime(s.Data["planned_date"], s.Data["planned_time"])
+		postAt, err := parseDateTime(s.Data["planned_date"], s.Data["planned_time"], clientLocation(chatID))
 		if err != nil {
 			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка при разборе даты и времени."))
 			return
@@ -832,29 +1017,13 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 			return
 		}
 
-		// Краткое описание для списка постов
-		content := fmt.Sprintf("📝 Тема: %s\n✍️ Стиль: %s\n🌐 Язык: %s\n📄 Длина: %s",
-			s.Data["theme"], s.Data["style"], s.Data["language"], s.Data["length"])
-
-		err = db.SaveScheduledPostFull(
-			database,
-			int64(channelID),
-			content,
-			postAt,
-			s.Data["theme"],
-			s.Data["style"],
-			s.Data["language"],
-			s.Data["length"],
-			s.Data["photo"],
-		)
+		msg := tgbotapi.NewMessage(chatID, "⏳ Генерирую текст поста для предпросмотра…")
+		msg.ReplyMarkup = bot2.MainKeyboardWithBack()
+		Bot.Send(msg)
 
-		if err != nil {
-			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось сохранить пост."))
-		} else {
-			msg := tgbotapi.NewMessage(chatID, "✅ Пост запланирован!")
-			msg.ReplyMarkup = bot2.MainKeyboardWithBack()
-			Bot.Send(msg)
-		}
+		// Генерация и сохранение черновика в фоне, затем предпросмотр с кнопками
+		go prepareScheduledPost(chatID, int64(channelID), postAt,
+			s.Data["theme"], s.Data["style"], s.Data["language"], s.Data["length"], s.Data["photo"])
 
 		s.State = "main_menu"
 
@@ -862,17 +1031,13 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 		if text == "📤 Загрузить свою" {
 			s.State = "edit_photo_upload"
 			Bot.Send(tgbotapi.NewMessage(chatID, "📸 Пришлите фото одним изображением (не документом):"))
-		} else if text == "🖼 Взять из Pexels" {
+		} else if text == "🖼 Подобрать автоматически" {
 			postID, _ := strconv.Atoi(s.Data["editing_post_id"])
 			err := db.UpdatePostField(database, int64(postID), "photo", "")
 			if err != nil {
 				Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось обновить фото."))
 			} else {
-				post, _ := db.GetScheduledPostByID(database, int64(postID))
-				newContent := bot2.RegenerateContent(&post)
-				_ = db.UpdatePostField(database, int64(postID), "content", newContent)
-
-				Bot.Send(tgbotapi.NewMessage(chatID, "✅ Теперь изображение будет выбрано из Pexels."))
+				Bot.Send(tgbotapi.NewMessage(chatID, "✅ Картинка будет подобрана автоматически."))
 			}
 			s.State = "editing_field"
 			msg := tgbotapi.NewMessage(chatID, "Что хотите изменить?")
@@ -896,7 +1061,9 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 		}
 
 		post, _ := db.GetScheduledPostByID(database, int64(postID))
-		postAt := time.Date(parsed.Year(), parsed.Month(), parsed.Day(), post.PostAt.Hour(), post.PostAt.Minute(), 0, 0, time.Local)
+		loc := clientLocation(chatID)
+		old := post.PostAt.In(loc)
+		postAt := time.Date(parsed.Year(), parsed.Month(), parsed.Day(), old.Hour(), old.Minute(), 0, 0, loc)
 
 		err = db.UpdatePostField(database, int64(postID), "post_at", postAt)
 		if err != nil {
@@ -904,10 +1071,6 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 			return
 		}
 
-		post.PostAt = postAt
-		newContent := bot2.RegenerateContent(&post)
-		_ = db.UpdatePostField(database, int64(postID), "content", newContent)
-
 		channelID, _ := db.GetChannelIDByUsername(database, s.Data["channel_username"])
 		updatedPosts, _ := db.GetScheduledPostsByChannelID(database, int64(channelID))
 		postCache[chatID] = updatedPosts
@@ -918,7 +1081,7 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 			if post.Photo != "" {
 				imgType = "(Ваше фото)"
 			}
-			list += fmt.Sprintf("%d — %s, %s %s\n", i+1, post.Content, post.PostAt.Format("02.01.06 15:04"), imgType)
+			list += fmt.Sprintf("%d — %s, %s %s\n", i+1, bot2.PostSummary(&post), post.PostAt.In(loc).Format("02.01.06 15:04"), imgType)
 		}
 
 		s.State = "editing_list"
@@ -936,22 +1099,21 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 			return
 		}
 
-		post, _ := db.GetScheduledPostByID(database, int64(postID))
-		post.Theme = newTheme
-		newContent := bot2.RegenerateContent(&post)
-		_ = db.UpdatePostField(database, int64(postID), "content", newContent)
+		// Параметры изменились — текст нужно сгенерировать заново и одобрить
+		go refreshDraft(chatID, int64(postID))
 
 		channelID, _ := db.GetChannelIDByUsername(database, s.Data["channel_username"])
 		updatedPosts, _ := db.GetScheduledPostsByChannelID(database, int64(channelID))
 		postCache[chatID] = updatedPosts
 
+		loc := clientLocation(chatID)
 		var list string
 		for i, post := range updatedPosts {
 			imgType := "(pexels)"
 			if post.Photo != "" {
 				imgType = "(Ваше фото)"
 			}
-			list += fmt.Sprintf("%d — %s, %s %s\n", i+1, post.Content, post.PostAt.Format("02.01.06 15:04"), imgType)
+			list += fmt.Sprintf("%d — %s, %s %s\n", i+1, bot2.PostSummary(&post), post.PostAt.In(loc).Format("02.01.06 15:04"), imgType)
 		}
 
 		s.State = "editing_list"
@@ -969,22 +1131,21 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 			return
 		}
 
-		post, _ := db.GetScheduledPostByID(database, int64(postID))
-		post.Style = newStyle
-		newContent := bot2.RegenerateContent(&post)
-		_ = db.UpdatePostField(database, int64(postID), "content", newContent)
+		// Параметры изменились — текст нужно сгенерировать заново и одобрить
+		go refreshDraft(chatID, int64(postID))
 
 		channelID, _ := db.GetChannelIDByUsername(database, s.Data["channel_username"])
 		updatedPosts, _ := db.GetScheduledPostsByChannelID(database, int64(channelID))
 		postCache[chatID] = updatedPosts
 
+		loc := clientLocation(chatID)
 		var list string
 		for i, post := range updatedPosts {
 			imgType := "(pexels)"
 			if post.Photo != "" {
 				imgType = "(Ваше фото)"
 			}
-			list += fmt.Sprintf("%d — %s, %s %s\n", i+1, post.Content, post.PostAt.Format("02.01.06 15:04"), imgType)
+			list += fmt.Sprintf("%d — %s, %s %s\n", i+1, bot2.PostSummary(&post), post.PostAt.In(loc).Format("02.01.06 15:04"), imgType)
 		}
 
 		s.State = "editing_list"
@@ -1002,10 +1163,6 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 			if err != nil {
 				Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось обновить фото."))
 			} else {
-				post, _ := db.GetScheduledPostByID(database, int64(postID))
-				newContent := bot2.RegenerateContent(&post)
-				_ = db.UpdatePostField(database, int64(postID), "content", newContent)
-
 				s.State = "editing_field"
 				Bot.Send(tgbotapi.NewMessage(chatID, "✅ Фото обновлено. Что хотите изменить?"))
 				msg := tgbotapi.NewMessage(chatID, "Выберите:")
@@ -1023,9 +1180,7 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка при обновлении языка."))
 			return
 		}
-		post, _ := db.GetScheduledPostByID(database, int64(postID))
-		newContent := bot2.RegenerateContent(&post)
-		_ = db.UpdatePostField(database, int64(postID), "content", newContent)
+		go refreshDraft(chatID, int64(postID))
 
 		s.State = "editing_field"
 		msg := tgbotapi.NewMessage(chatID, "✅ Язык обновлён. Что хотите изменить?")
@@ -1039,9 +1194,7 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка при обновлении длины."))
 			return
 		}
-		post, _ := db.GetScheduledPostByID(database, int64(postID))
-		newContent := bot2.RegenerateContent(&post)
-		_ = db.UpdatePostField(database, int64(postID), "content", newContent)
+		go refreshDraft(chatID, int64(postID))
 
 		s.State = "editing_field"
 		msg := tgbotapi.NewMessage(chatID, "✅ Длина обновлена. Что хотите изменить?")
@@ -1064,15 +1217,17 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 			return
 		}
 
-		// Берём старую дату, вставляем новое время
+		// Берём старую дату (в поясе пользователя), вставляем новое время
+		loc := clientLocation(chatID)
+		old := post.PostAt.In(loc)
 		postAt := time.Date(
-			post.PostAt.Year(),
-			post.PostAt.Month(),
-			post.PostAt.Day(),
+			old.Year(),
+			old.Month(),
+			old.Day(),
 			parsedTime.Hour(),
 			parsedTime.Minute(),
 			0, 0,
-			time.Local,
+			loc,
 		)
 
 		err = db.UpdatePostField(database, int64(postID), "post_at", postAt)
@@ -1081,20 +1236,45 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 			return
 		}
 
-		// Обновляем content
-		post.PostAt = postAt
-		newContent := bot2.RegenerateContent(&post)
-		_ = db.UpdatePostField(database, int64(postID), "content", newContent)
-
 		s.State = "editing_field"
 		msg := tgbotapi.NewMessage(chatID, "✅ Время обновлено. Что хотите изменить?")
 		msg.ReplyMarkup = bot2.EditFieldKeyboard()
 		Bot.Send(msg)
 
+	case "edit_post_text":
+		postID, _ := strconv.ParseInt(s.Data["editing_post_id"], 10, 64)
+		newText := strings.TrimSpace(text)
+		if newText == "" {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Пришлите текст поста."))
+			return
+		}
+		if _, ok := ownedPost(chatID, postID); !ok {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Пост не найден."))
+			s.State = "main_menu"
+			return
+		}
+		if err := db.SetScheduledPostDraft(database, postID, newText, ""); err != nil {
+			if errors.Is(err, db.ErrPostNotEditable) {
+				s.State = "main_menu"
+				Bot.Send(tgbotapi.NewMessage(chatID, "⏳ Пост уже публикуется или опубликован — изменить его нельзя."))
+				return
+			}
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось сохранить текст."))
+			return
+		}
+
+		s.State = "main_menu"
+		post, err := db.GetScheduledPostByID(database, postID)
+		if err != nil {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось получить пост."))
+			return
+		}
+		sendDraftPreview(chatID, post)
+
 	case "viewing_posts":
 		if text == "⬅️ Назад" {
 			s.State = "main_menu"
-			msg := tgbotapi.NewMessage(chatID, "Выберите действие:")
+			msg := tgbotapi.NewMessage(chatID, "Выберите действие:"+quotaLine(s.Data["channel_username"]))
 			msg.ReplyMarkup = bot2.MainKeyboardWithBack()
 			Bot.Send(msg)
 			return
@@ -1132,6 +1312,7 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 			updatedPosts, _ := db.GetScheduledPostsByChannelID(database, int64(channelID))
 			postCache[chatID] = updatedPosts
 
+			loc := clientLocation(chatID)
 			var list string
 			for i, post := range updatedPosts {
 				list += fmt.Sprintf("%d — 📝 Тема: %s\n", i+1, post.Theme)
@@ -1139,7 +1320,7 @@ func handleState(update tgbotapi.Update, Bot *tgbotapi.BotAPI, s *session.Sessio
 				list += fmt.Sprintf("🌐 Язык: %s\n", post.Language)
 				list += fmt.Sprintf("📄 Длина: %s, %s",
 					post.Length,
-					post.PostAt.Format("02.01.06 15:04"),
+					post.PostAt.In(loc).Format("02.01.06 15:04"),
 				)
 				if post.Photo != "" {
 					if strings.Contains(post.Photo, "pexels") {
@@ -1170,6 +1351,18 @@ func handleCallback(query *tgbotapi.CallbackQuery, s *session.Session) {
 		return
 	}
 
+	if handleGeneratedCallback(query) {
+		return
+	}
+
+	if handlePlanCallback(query) {
+		return
+	}
+
+	if handleDraftCallback(query, s) {
+		return
+	}
+
 	if strings.HasPrefix(data, "delete_") {
 		idStr := strings.TrimPrefix(data, "delete_")
 		postID, _ := strconv.Atoi(idStr)
@@ -1194,34 +1387,8 @@ func parseTime(text string) (time.Time, error) {
 	return time.Parse(layout, text)
 }
 
+// generatePost генерирует пост со стримингом в чат; в канал он уходит после подтверждения
 func generatePost(s *session.Session, chatID int64, fileID string) {
-	style := map[string]string{
-		"🤓 Экспертный":     "expert",
-		"😊 Дружелюбный":    "friendly",
-		"📢 Информационный": "informational",
-		"🎭 Лирический":     "lyrical",
-	}[s.Data["style"]]
-
-	lang := map[string]string{
-		"🇷🇺 Русский":    "ru",
-		"🇬🇧 Английский": "en",
-	}[s.Data["language"]]
-
-	length := map[string]string{
-		"✏️ Короткий": "short",
-		"📄 Средний":   "medium",
-		"📚 Длинный":   "long",
-	}[s.Data["length"]]
-
-	theme := s.Data["theme"]
-	prompt := fmt.Sprintf("Сгенерируй %s пост на тему \"%s\" в стиле %s на языке %s", length, theme, style, lang)
-
-	text, _, err := api.GeneratePostFromPrompt(prompt)
-	if err != nil {
-		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка генерации поста"))
-		return
-	}
-
 	channelUsername := s.Data["channel_username"]
 	if channelUsername == "" {
 		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не выбран."))
@@ -1231,50 +1398,95 @@ func generatePost(s *session.Session, chatID int64, fileID string) {
 		channelUsername = "@" + channelUsername
 	}
 
-	var sendErr error
+	streamGeneratedPost(chatID, generatedPost{
+		ChannelUsername: channelUsername,
+		Theme:           s.Data["theme"],
+		Style:           s.Data["style"],
+		Language:        s.Data["language"],
+		Length:          s.Data["length"],
+		FileID:          fileID,
+	})
+}
 
-	// Отправляем фото без подписи
-	if fileID != "" {
-		photo := tgbotapi.NewPhotoToChannel(channelUsername, tgbotapi.FileID(fileID))
-		_, sendErr = Bot.Send(photo)
-	} else {
-		translated, err := api.Translate(theme, "en")
-		if err != nil || translated == "" {
-			log.Printf("⚠️ Не удалось перевести тему %q, использую как есть", theme)
-			translated = theme
-		} else {
-			log.Printf("🌐 Тема %q переведена как %q", theme, translated)
+// publishGenerated отправляет подтверждённый пост в канал
+func publishGenerated(chatID int64, p generatedPost) {
+	theme := p.Theme
+
+	// Режим разметки (HTML по умолчанию), источники картинок и подпись автора картинки
+	parseMode := format.ModeHTML
+	imageOrder := ""
+	imageCredit := false
+	channelID, err := channelIDByUsername(database, p.ChannelUsername)
+	if err == nil {
+		if ch, err := db.GetChannelByID(database, channelID); err == nil {
+			parseMode = ch.ParseMode
+			imageOrder = ch.ImageProviders
+			imageCredit = ch.ImageCredit
 		}
+	}
 
-		imgURL, err := pexels.FetchImage(translated)
+	var img bot2.PostImage
+	if p.FileID != "" {
+		img.FileID = p.FileID
+	} else {
+		ctx, cancel := context.WithTimeout(channelUsageContext(p.ChannelUsername), bot2.ImageTimeout(imageOrder))
+		defer cancel()
 
-		if err != nil || imgURL == "" {
-			log.Printf("❌ Не удалось найти фото в Pexels по теме: %s", theme)
-			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось найти картинку по теме. Попробуй другую тему."))
-			return
+		query := bot2.ImageSearchQuery(ctx, p.ImageQuery, theme)
+		log.Printf("🌐 Картинка для темы %q: запрос %q", theme, query)
+
+		found, err := bot2.FindImage(ctx, database, channelID, imageOrder, query, p.Text)
+		if err != nil {
+			// как и у запланированных: без картинки пост всё равно уходит текстом
+			log.Printf("⚠️ Не удалось найти фото по теме %s: %v", theme, err)
+			Bot.Send(tgbotapi.NewMessage(chatID, "⚠️ Картинку подобрать не удалось — публикую пост без неё. "+
+				"Источники картинок можно поменять в «🖼 Картинки»."))
+		} else {
+			img = found
 		}
-		photo := tgbotapi.NewPhotoToChannel(channelUsername, tgbotapi.FileURL(imgURL))
-		_, sendErr = Bot.Send(photo)
 	}
 
-	if sendErr != nil {
-		log.Printf("❌ Ошибка при публикации фото в канал %s: %v", channelUsername, sendErr)
-		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка при публикации изображения."))
+	// Фото с подписью одним сообщением; длинный текст — ответом на фото (фото откатывается при ошибке)
+	if err := bot2.SendPost(Bot, p.ChannelUsername, img, bot2.WithCredit(p.Text, img, imageCredit), parseMode); err != nil {
+		log.Printf("❌ Ошибка при публикации поста в канал %s: %v", p.ChannelUsername, err)
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка при публикации поста."))
 		return
 	}
 
-	// Отправляем текст отдельно
-	textMsg := tgbotapi.NewMessageToChannel(channelUsername, text)
-	_, sendErr = Bot.Send(textMsg)
-	if sendErr != nil {
-		log.Printf("❌ Ошибка при публикации текста в канал %s: %v", channelUsername, sendErr)
-		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка при публикации текста."))
-		return
+	// Если сюда дошли — всё ок
+	bot2.RememberImage(database, channelID, img)
+	Bot.Send(tgbotapi.NewMessage(chatID, "✅ Пост опубликован в "+p.ChannelUsername))
+}
+
+func imageCreditStatus(on bool) string {
+	if on {
+		return "📷 Подпись автора картинки (например, «📷 Имя / Pexels»): включена"
 	}
+	return "📷 Подпись автора картинки (например, «📷 Имя / Pexels»): выключена"
+}
 
-	// Если сюда дошли — всё ок
-	Bot.Send(tgbotapi.NewMessage(chatID, "✅ Пост опубликован в "+channelUsername))
+// toggleImageCredit переключает подпись автора картинки под постами канала
+func toggleImageCredit(chatID int64, s *session.Session) {
+	channelID, err := db.GetChannelIDByUsername(database, s.Data["channel_username"])
+	if err != nil {
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не найден."))
+		return
+	}
+	ch, err := db.GetChannelByID(database, channelID)
+	if err != nil {
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не найден."))
+		return
+	}
+	if err := db.SetChannelImageCredit(database, channelID, !ch.ImageCredit); err != nil {
+		log.Printf("❌ Не удалось сохранить image_credit для channel_id=%d: %v", channelID, err)
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось сохранить настройку."))
+		return
+	}
 
+	s.State = "main_menu"
+	msg := tgbotapi.NewMessage(chatID, "✅ "+imageCreditStatus(!ch.ImageCredit))
+	msg.ReplyMarkup = bot2.MainKeyboardWithBack()
+	Bot.Send(msg)
 }
 
 func showStyleOptions(chatID int64) {
@@ -1325,6 +1537,16 @@ func isValidTime(timeStr string) bool {
 	return err == nil
 }
 
-func parseDateTime(dateStr, timeStr string) (time.Time, error) {
-	return time.Parse("02.01.06 15:04", dateStr+" "+timeStr)
+// parseDateTime разбирает дату и время в часовом поясе пользователя
+func parseDateTime(dateStr, timeStr string, loc *time.Location) (time.Time, error) {
+	return time.ParseInLocation("02.01.06 15:04", dateStr+" "+timeStr, loc)
+}
+
+// clientLocation — часовой пояс пользователя (для ввода и показа дат)
+func clientLocation(chatID int64) *time.Location {
+	c, err := db.GetClientByChatID(database, chatID)
+	if err != nil {
+		c = &db.Client{}
+	}
+	return c.Location()
 }
@@ -0,0 +1,89 @@
+package bot
+
+import (
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"mybot/api"
+	"mybot/bot2"
+	"mybot/db"
+	"mybot/session"
+)
+
+// Своя модель LLM канала: провайдер из цепочки LLM_PROVIDER_CHAIN и, по желанию, модель.
+// Доступна в тарифах с allow_custom_model; после понижения тарифа генерация идёт общей цепочкой.
+
+// showModelMenu — текущий выбор и подсказка, как его поменять
+func showModelMenu(chatID int64, s *session.Session, username string) {
+	channelID, err := db.GetChannelIDByUsername(database, s.Data["channel_username"])
+	if err != nil {
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не выбран."))
+		return
+	}
+	ch, err := db.GetChannelByID(database, channelID)
+	if err != nil {
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не найден."))
+		return
+	}
+	if !allowAccess(username, ch, chatID) || !allowCustomModel(ch, chatID) {
+		return
+	}
+
+	providers := api.ProviderNames()
+	s.State = "choosing_llm_model"
+	msg := tgbotapi.NewMessage(chatID, "🧠 Какой моделью писать посты канала? Сейчас: "+modelTitle(ch.LLMProvider, ch.LLMModel)+
+		"\nПровайдеры: "+strings.Join(providers, ", ")+
+		"\nПришлите имя провайдера и, если нужно, модель через пробел (например, groq llama-3.3-70b-versatile)."+
+		" Если выбранная модель не ответит, пост напишет общая цепочка.")
+	msg.ReplyMarkup = bot2.LLMModelKeyboard(providers)
+	Bot.Send(msg)
+}
+
+// handleModelChoice сохраняет выбор из choosing_llm_model
+func handleModelChoice(chatID int64, text string, s *session.Session) {
+	var provider, model string
+	if text != "По умолчанию" {
+		fields := strings.Fields(text)
+		if len(fields) == 0 || len(fields) > 2 {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не понял. Пример: groq llama-3.3-70b-versatile"))
+			return
+		}
+		provider = strings.ToLower(fields[0])
+		if len(fields) == 2 {
+			model = fields[1]
+		}
+		if _, ok := api.LookupProvider(provider); !ok {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Провайдер «"+provider+"» не настроен. Доступны: "+
+				strings.Join(api.ProviderNames(), ", ")))
+			return
+		}
+	}
+
+	channelID, err := db.GetChannelIDByUsername(database, s.Data["channel_username"])
+	if err != nil {
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не найден."))
+		return
+	}
+	if err := db.SetChannelLLMModel(database, channelID, provider, model); err != nil {
+		log.Printf("❌ Не удалось сохранить модель LLM для channel_id=%d: %v", channelID, err)
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось сохранить настройку."))
+		return
+	}
+
+	s.State = "main_menu"
+	msg := tgbotapi.NewMessage(chatID, "✅ Модель: "+modelTitle(provider, model))
+	msg.ReplyMarkup = bot2.MainKeyboardWithBack()
+	Bot.Send(msg)
+}
+
+func modelTitle(provider, model string) string {
+	switch {
+	case provider == "":
+		return "по умолчанию (общая цепочка)"
+	case model == "":
+		return provider + " (модель по умолчанию)"
+	}
+	return provider + " / " + model
+}
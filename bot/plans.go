@@ -0,0 +1,107 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"mybot/db"
+	"mybot/sub"
+)
+
+// Тарифы: выбор при оплате и проверка функций, доступных каналу.
+
+// handlePlanCallback — кнопки тарифа под сообщением об оплате: TON ("plan:<код>:<канал>")
+// и Telegram Stars ("stars:<код>:<канал>"). false — не наш callback.
+func handlePlanCallback(query *tgbotapi.CallbackQuery) bool {
+	rest, ok := strings.CutPrefix(query.Data, "plan:")
+	stars := false
+	if !ok {
+		if rest, ok = strings.CutPrefix(query.Data, "stars:"); !ok {
+			return false
+		}
+		stars = true
+	}
+	chatID := query.Message.Chat.ID
+	Bot.Request(tgbotapi.NewCallback(query.ID, ""))
+
+	code, channelUsername, _ := strings.Cut(rest, ":")
+	plan, err := db.GetPlanByCode(database, code)
+	if err != nil {
+		log.Printf("⚠️ Тариф %q не найден: %v", code, err)
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Этот тариф больше недоступен."))
+		return true
+	}
+	if stars {
+		sub.SendStarsInvoice(Bot, database, chatID, channelUsername, plan)
+		return true
+	}
+	sub.SendPlanPayment(Bot, database, chatID, channelUsername, plan)
+	return true
+}
+
+// handlePreCheckout — Telegram спрашивает, можно ли списать звёзды; ответить нужно в течение 10 секунд
+func handlePreCheckout(q *tgbotapi.PreCheckoutQuery) {
+	answer := tgbotapi.PreCheckoutConfig{PreCheckoutQueryID: q.ID, OK: true}
+	if err := sub.CheckStarsPayment(database, q); err != nil {
+		log.Printf("⚠️ Оплата Stars отклонена (payload=%q): %v", q.InvoicePayload, err)
+		answer = tgbotapi.PreCheckoutConfig{PreCheckoutQueryID: q.ID, ErrorMessage: err.Error()}
+	}
+	if _, err := Bot.Request(answer); err != nil {
+		log.Println("⚠️ Не удалось ответить на pre_checkout_query:", err)
+	}
+}
+
+// channelPlan — тариф канала; при ошибке БД ограничений не вводим
+func channelPlan(channel db.Channel) (db.Plan, bool) {
+	plan, err := sub.ChannelPlan(database, &channel)
+	if err != nil {
+		log.Printf("⚠️ Не удалось получить тариф канала @%s: %v", channel.ChannelTitle, err)
+		return plan, false
+	}
+	return plan, true
+}
+
+// allowRecurring — регулярные посты входят в тариф канала
+func allowRecurring(channel db.Channel, chatID int64) bool {
+	plan, ok := channelPlan(channel)
+	if !ok || plan.AllowRecurring {
+		return true
+	}
+	Bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(
+		"🔒 Регулярные посты не входят в тариф «%s». Выберите другой тариф при продлении подписки.", plan.Name)))
+	return false
+}
+
+// allowCustomModel — своя модель LLM входит в тариф канала
+func allowCustomModel(channel db.Channel, chatID int64) bool {
+	plan, ok := channelPlan(channel)
+	if !ok || plan.AllowCustomModel {
+		return true
+	}
+	Bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(
+		"🔒 Своя модель LLM не входит в тариф «%s». Выберите другой тариф при продлении подписки.", plan.Name)))
+	return false
+}
+
+// allowMorePosts — в очереди канала есть место под ещё один пост
+func allowMorePosts(channel db.Channel, chatID int64) bool {
+	plan, ok := channelPlan(channel)
+	if !ok || plan.MaxScheduledPosts <= 0 {
+		return true
+	}
+	n, err := db.CountUpcomingPosts(database, int64(channel.ID))
+	if err != nil {
+		log.Printf("⚠️ Не удалось посчитать посты канала id=%d: %v", channel.ID, err)
+		return true
+	}
+	if n < plan.MaxScheduledPosts {
+		return true
+	}
+	Bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(
+		"🔒 В очереди уже %d постов — это максимум для тарифа «%s». Дождитесь публикации или удалите лишние.",
+		n, plan.Name)))
+	return false
+}
@@ -0,0 +1,189 @@
+package bot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"mybot/api"
+	"mybot/bot2"
+	"mybot/db"
+	"mybot/format"
+	"mybot/session"
+)
+
+// Предпросмотр и одобрение запланированных постов.
+// Текст генерируется при планировании, владелец видит его и решает:
+// одобрить, перегенерировать или поправить руками. Публикуются только одобренные.
+
+// Запас под заголовок предпросмотра в пределах лимита Telegram (4096)
+const previewTextLimit = 3800
+
+// prepareScheduledPost — генерация текста и сохранение черновика (запускается в фоне)
+func prepareScheduledPost(chatID int64, channelID int64, postAt time.Time, theme, style, language, length, photo string) {
+	draft := db.ScheduledPost{
+		ChannelID: channelID,
+		PostAt:    postAt,
+		Theme:     theme,
+		Style:     style,
+		Language:  language,
+		Length:    length,
+		Photo:     photo,
+	}
+
+	if !reserveGeneration(chatID, channelID) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(bot2.UsageContext(appCtx, database, channelID), api.InteractiveTimeout)
+	defer cancel()
+
+	generated, err := bot2.RegenerateContent(ctx, &draft)
+	if err != nil {
+		refundGeneration(channelID)
+		log.Printf("❌ Не удалось сгенерировать текст для запланированного поста: %v", err)
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка генерации поста. Попробуй запланировать ещё раз."))
+		return
+	}
+	text := generated.Text()
+
+	id, err := db.SaveScheduledPostFull(database, channelID, text, postAt, theme, style, language, length, photo, generated.ImageQuery)
+	if err != nil {
+		log.Printf("❌ Не удалось сохранить запланированный пост: %v", err)
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось сохранить пост."))
+		return
+	}
+
+	draft.ID = id
+	draft.Content = text
+	sendDraftPreview(chatID, draft)
+}
+
+// refreshDraft — перегенерировать текст после смены параметров и снова показать предпросмотр
+func refreshDraft(chatID int64, postID int64) {
+	post, err := db.GetScheduledPostByID(database, postID)
+	if err != nil {
+		log.Printf("❌ refreshDraft: пост #%d не найден: %v", postID, err)
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Пост не найден."))
+		return
+	}
+
+	if !reserveGeneration(chatID, post.ChannelID) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(bot2.UsageContext(appCtx, database, post.ChannelID), api.InteractiveTimeout)
+	defer cancel()
+
+	generated, err := bot2.RegenerateContent(ctx, &post)
+	if err != nil {
+		refundGeneration(post.ChannelID)
+		log.Printf("❌ refreshDraft: ошибка генерации для поста #%d: %v", postID, err)
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Ошибка генерации поста. Попробуй ещё раз."))
+		return
+	}
+
+	text := generated.Text()
+	if err := db.SetScheduledPostDraft(database, postID, text, generated.ImageQuery); err != nil {
+		if errors.Is(err, db.ErrPostNotEditable) {
+			// пока генерировали, пост ушёл в публикацию — генерация не понадобилась
+			refundGeneration(post.ChannelID)
+			Bot.Send(tgbotapi.NewMessage(chatID, "⏳ Пост уже публикуется или опубликован — изменить его нельзя."))
+			return
+		}
+		log.Printf("❌ refreshDraft: не удалось сохранить текст поста #%d: %v", postID, err)
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось сохранить пост."))
+		return
+	}
+
+	post.Content = text
+	sendDraftPreview(chatID, post)
+}
+
+func sendDraftPreview(chatID int64, post db.ScheduledPost) {
+	mode := format.ModeHTML
+	if ch, err := db.GetChannelByID(database, int(post.ChannelID)); err == nil {
+		mode = ch.ParseMode
+	}
+	header := fmt.Sprintf("👀 Предпросмотр поста на %s:\n\n", post.PostAt.In(clientLocation(chatID)).Format("02.01.06 15:04"))
+	if err := bot2.SendDraftPreview(Bot, chatID, header, post, mode); err != nil {
+		log.Printf("⚠️ Не удалось отправить предпросмотр поста #%d: %v", post.ID, err)
+	}
+}
+
+// ownedPost проверяет, что пост принадлежит каналу этого пользователя
+func ownedPost(chatID int64, postID int64) (db.ScheduledPost, bool) {
+	post, err := db.GetScheduledPostByID(database, postID)
+	if err != nil {
+		return post, false
+	}
+	ch, err := db.GetChannelByID(database, int(post.ChannelID))
+	if err != nil {
+		return post, false
+	}
+	client, err := db.GetClientByID(database, ch.ClientID)
+	if err != nil {
+		return post, false
+	}
+	return post, client.ChatID == chatID
+}
+
+// handleDraftCallback обрабатывает кнопки под предпросмотром. Возвращает false, если это не наш callback.
+func handleDraftCallback(query *tgbotapi.CallbackQuery, s *session.Session) bool {
+	action, idStr, ok := strings.Cut(query.Data, ":")
+	if !ok || !strings.HasPrefix(action, "post_") {
+		return false
+	}
+	chatID := query.Message.Chat.ID
+	Bot.Request(tgbotapi.NewCallback(query.ID, ""))
+
+	postID, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return true
+	}
+	post, ok := ownedPost(chatID, postID)
+	if !ok {
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Пост не найден."))
+		return true
+	}
+	if post.Status != db.PostStatusDraft && post.Status != db.PostStatusPending {
+		Bot.Send(tgbotapi.NewMessage(chatID, "ℹ️ Этот пост уже нельзя изменить."))
+		return true
+	}
+
+	switch action {
+	case "post_approve":
+		if post.Status == db.PostStatusDraft && !post.PostAt.After(time.Now()) {
+			// публиковать задним числом не будем: пусть владелец выберет новое время
+			Bot.Send(tgbotapi.NewMessage(chatID, "⌛ Время публикации ("+post.PostAt.In(clientLocation(chatID)).Format("02.01.06 15:04")+
+				") уже прошло — пост не одобрен. Запланируйте его заново на новое время."))
+			return true
+		}
+		approved, err := db.ApproveScheduledPost(database, postID)
+		if err != nil {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось одобрить пост."))
+			return true
+		}
+		if !approved {
+			Bot.Send(tgbotapi.NewMessage(chatID, "ℹ️ Пост уже одобрен."))
+			return true
+		}
+		msg := tgbotapi.NewMessage(chatID, "✅ Пост одобрен и будет опубликован "+post.PostAt.In(clientLocation(chatID)).Format("02.01.06 15:04"))
+		msg.ReplyMarkup = bot2.MainKeyboardWithBack()
+		Bot.Send(msg)
+
+	case "post_regen":
+		Bot.Send(tgbotapi.NewMessage(chatID, "⏳ Генерирую новый вариант…"))
+		go refreshDraft(chatID, postID)
+
+	case "post_edit":
+		s.Data["editing_post_id"] = strconv.FormatInt(postID, 10)
+		s.State = "edit_post_text"
+		Bot.Send(tgbotapi.NewMessage(chatID, "✏️ Пришлите новый текст поста одним сообщением:"))
+	}
+	return true
+}
@@ -0,0 +1,69 @@
+package bot
+
+import (
+	"log"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"mybot/db"
+	"mybot/sub"
+)
+
+// Лимит генераций на период подписки. Проверяем при входе в генерацию/планирование,
+// а списываем перед каждым вызовом LLM (неудачная генерация возвращается).
+
+// allowGeneration — доступ к каналу + остались генерации в текущем периоде
+func allowGeneration(requesterUsername string, channel db.Channel, chatID int64) bool {
+	if !allowAccess(requesterUsername, channel, chatID) {
+		return false
+	}
+	if sub.HasGenerationsLeft(&channel) {
+		return true
+	}
+	log.Printf("⛔ Лимит генераций исчерпан у канала @%s", channel.ChannelTitle)
+	sendQuotaExceeded(chatID, channel)
+	return false
+}
+
+func sendQuotaExceeded(chatID int64, channel db.Channel) {
+	Bot.Send(tgbotapi.NewMessage(chatID, "⛔ Лимит генераций на этот период исчерпан.\n"+
+		sub.QuotaStatus(&channel)+"\n\nЛимит обновится при продлении подписки."))
+}
+
+// reserveGeneration списывает генерацию перед вызовом LLM. false — лимит исчерпан (пользователь уведомлён).
+func reserveGeneration(chatID int64, channelID int64) bool {
+	ok, err := db.UseGeneration(database, channelID)
+	if err != nil {
+		log.Printf("❌ Не удалось списать генерацию для channel_id=%d: %v", channelID, err)
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось проверить лимит генераций. Попробуй позже."))
+		return false
+	}
+	if !ok {
+		if ch, err := db.GetChannelByID(database, int(channelID)); err == nil {
+			sendQuotaExceeded(chatID, ch)
+		} else {
+			Bot.Send(tgbotapi.NewMessage(chatID, "⛔ Лимит генераций на этот период исчерпан."))
+		}
+	}
+	return ok
+}
+
+// refundGeneration возвращает генерацию, если модель не ответила
+func refundGeneration(channelID int64) {
+	if err := db.RefundGeneration(database, channelID); err != nil {
+		log.Printf("❌ Не удалось вернуть генерацию каналу id=%d: %v", channelID, err)
+	}
+}
+
+// quotaLine — строка с расходом генераций для главного меню (пустая, если канал не найден)
+func quotaLine(channelUsername string) string {
+	id, err := channelIDByUsername(database, channelUsername)
+	if err != nil {
+		return ""
+	}
+	ch, err := db.GetChannelByID(database, id)
+	if err != nil {
+		return ""
+	}
+	return "\n\n" + sub.QuotaStatus(&ch)
+}
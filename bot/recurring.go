@@ -0,0 +1,280 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"mybot/bot2"
+	"mybot/db"
+	"mybot/session"
+)
+
+// Регулярные расписания: "каждые будни в 09:00", "пн и чт в 18:30" и т.п.
+// Правила хранятся в recurring_schedules, autopost разворачивает их в scheduled_posts заранее.
+
+var ruleCache = make(map[int64][]db.RecurringRule)
+
+var weekdayNames = map[string]int{
+	"пн": 1, "вт": 2, "ср": 3, "чт": 4, "пт": 5, "сб": 6, "вс": 7,
+}
+
+var weekdayShort = []string{"", "пн", "вт", "ср", "чт", "пт", "сб", "вс"}
+
+// parseWeekdays: "пн,чт", "будни", "выходные", "ежедневно"
+func parseWeekdays(text string) ([]int, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	switch text {
+	case "будни":
+		return []int{1, 2, 3, 4, 5}, nil
+	case "выходные":
+		return []int{6, 7}, nil
+	case "ежедневно", "каждый день":
+		return []int{1, 2, 3, 4, 5, 6, 7}, nil
+	}
+
+	seen := map[int]bool{}
+	var out []int
+	for _, p := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' }) {
+		d, ok := weekdayNames[p]
+		if !ok {
+			return nil, fmt.Errorf("неизвестный день: %q", p)
+		}
+		if !seen[d] {
+			seen[d] = true
+			out = append(out, d)
+		}
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("не указаны дни")
+	}
+	sort.Ints(out)
+	return out, nil
+}
+
+// parseTimes: "09:00, 18:30"
+func parseTimes(text string) ([]string, error) {
+	var out []string
+	for _, p := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || r == ' ' }) {
+		t, err := time.Parse("15:04", p)
+		if err != nil {
+			return nil, fmt.Errorf("неверное время: %q", p)
+		}
+		out = append(out, t.Format("15:04"))
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("не указано время")
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func formatRule(r db.RecurringRule) string {
+	days := make([]string, 0, len(r.Weekdays))
+	for _, d := range r.Weekdays {
+		if d >= 1 && d <= 7 {
+			days = append(days, weekdayShort[d])
+		}
+	}
+	state := "▶️ активно"
+	if !r.IsActive {
+		state = "⏸ на паузе"
+	}
+	return fmt.Sprintf("🗓 %s в %s (%s)\n📝 Темы: %s\n%s",
+		strings.Join(days, ","), strings.Join(r.Times, ", "), r.Timezone,
+		strings.Join(r.Themes, "; "), state)
+}
+
+func showRecurringMenu(chatID int64, s *session.Session) {
+	channelID, err := db.GetChannelIDByUsername(database, s.Data["channel_username"])
+	if err != nil {
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не найден."))
+		return
+	}
+
+	rules, err := db.GetRecurringRulesByChannelID(database, int64(channelID))
+	if err != nil {
+		log.Printf("❌ Не удалось получить правила для channel_id=%d: %v", channelID, err)
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось получить расписания."))
+		return
+	}
+	ruleCache[chatID] = rules
+
+	text := "🔁 Регулярных расписаний пока нет."
+	if len(rules) > 0 {
+		var list string
+		for i, r := range rules {
+			list += fmt.Sprintf("%d — %s\n\n", i+1, formatRule(r))
+		}
+		text = "🔁 Регулярные расписания:\n\n" + list
+	}
+
+	msg := tgbotapi.NewMessage(chatID, text)
+	msg.ReplyMarkup = bot2.RecurringRulesKeyboard(rules)
+	Bot.Send(msg)
+	s.State = "recurring_menu"
+}
+
+// ruleByButton находит правило по номеру из кнопки "⏸ Пауза 2" и т.п.
+func ruleByButton(chatID int64, text, prefix string) (db.RecurringRule, bool) {
+	index, err := strconv.Atoi(strings.TrimPrefix(text, prefix))
+	rules := ruleCache[chatID]
+	if err != nil || index < 1 || index > len(rules) {
+		return db.RecurringRule{}, false
+	}
+	return rules[index-1], true
+}
+
+func handleRecurringState(msg *tgbotapi.Message, s *session.Session) {
+	chatID := msg.Chat.ID
+	text := strings.TrimSpace(msg.Text)
+
+	switch s.State {
+	case "recurring_menu":
+		switch {
+		case text == "⬅️ Назад":
+			s.State = "main_menu"
+			m := tgbotapi.NewMessage(chatID, "Выберите действие:"+quotaLine(s.Data["channel_username"]))
+			m.ReplyMarkup = bot2.MainKeyboardWithBack()
+			Bot.Send(m)
+
+		case text == "➕ Новое правило":
+			s.State = "recurring_days"
+			m := tgbotapi.NewMessage(chatID, "📅 В какие дни публиковать? Например: пн,чт — или выберите кнопкой:")
+			m.ReplyMarkup = bot2.Weekdays
+			Bot.Send(m)
+
+		case strings.HasPrefix(text, "⏸ Пауза "), strings.HasPrefix(text, "▶️ Включить "):
+			active := strings.HasPrefix(text, "▶️ Включить ")
+			prefix := "⏸ Пауза "
+			if active {
+				prefix = "▶️ Включить "
+			}
+			r, ok := ruleByButton(chatID, text, prefix)
+			if !ok {
+				Bot.Send(tgbotapi.NewMessage(chatID, "❌ Неверный номер правила"))
+				return
+			}
+			if err := db.SetRecurringRuleActive(database, r.ID, active); err != nil {
+				log.Printf("❌ Не удалось изменить правило #%d: %v", r.ID, err)
+				Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось изменить правило."))
+				return
+			}
+			showRecurringMenu(chatID, s)
+
+		case strings.HasPrefix(text, "🗑 Удалить правило "):
+			r, ok := ruleByButton(chatID, text, "🗑 Удалить правило ")
+			if !ok {
+				Bot.Send(tgbotapi.NewMessage(chatID, "❌ Неверный номер правила"))
+				return
+			}
+			if err := db.DeleteRecurringRule(database, r.ID); err != nil {
+				log.Printf("❌ Не удалось удалить правило #%d: %v", r.ID, err)
+				Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось удалить правило."))
+				return
+			}
+			Bot.Send(tgbotapi.NewMessage(chatID, "🗑 Правило удалено."))
+			showRecurringMenu(chatID, s)
+
+		default:
+			Bot.Send(tgbotapi.NewMessage(chatID, "Пожалуйста, выбери опцию из меню."))
+		}
+
+	case "recurring_days":
+		if _, err := parseWeekdays(text); err != nil {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ "+err.Error()+". Пример: пн,ср,пт"))
+			return
+		}
+		s.Data["rec_days"] = text
+		s.State = "recurring_times"
+		Bot.Send(tgbotapi.NewMessage(chatID, "⏰ Во сколько? Можно несколько через запятую, например: 09:00, 18:30"))
+
+	case "recurring_times":
+		if _, err := parseTimes(text); err != nil {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ "+err.Error()+". Пример: 09:00, 18:30"))
+			return
+		}
+		s.Data["rec_times"] = text
+		s.State = "recurring_timezone"
+		m := tgbotapi.NewMessage(chatID, fmt.Sprintf("🌍 Часовой пояс расписания (ваш: %s):", clientLocation(chatID)))
+		m.ReplyMarkup = bot2.Timezone
+		Bot.Send(m)
+
+	case "recurring_timezone":
+		if _, err := time.LoadLocation(text); err != nil {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Неизвестный часовой пояс. Пример: Europe/Moscow"))
+			return
+		}
+		s.Data["rec_tz"] = text
+		s.State = "recurring_themes"
+		m := tgbotapi.NewMessage(chatID, "📝 Пришлите темы — каждую с новой строки. Они будут чередоваться по кругу.")
+		m.ReplyMarkup = tgbotapi.NewRemoveKeyboard(true)
+		Bot.Send(m)
+
+	case "recurring_themes":
+		if text == "" {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Пришлите хотя бы одну тему."))
+			return
+		}
+		s.Data["rec_themes"] = text
+		s.State = "recurring_style"
+		showStyleOptions(chatID)
+
+	case "recurring_style":
+		s.Data["style"] = text
+		s.State = "recurring_language"
+		showLanguageOptions(chatID)
+
+	case "recurring_language":
+		s.Data["language"] = text
+		s.State = "recurring_length"
+		showLengthOptions(chatID)
+
+	case "recurring_length":
+		s.Data["length"] = text
+
+		channelID, err := db.GetChannelIDByUsername(database, s.Data["channel_username"])
+		if err != nil {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не найден."))
+			return
+		}
+		days, _ := parseWeekdays(s.Data["rec_days"])
+		times, _ := parseTimes(s.Data["rec_times"])
+
+		var themes []string
+		for _, t := range strings.Split(s.Data["rec_themes"], "\n") {
+			if t = strings.TrimSpace(t); t != "" {
+				themes = append(themes, t)
+			}
+		}
+
+		rule := db.RecurringRule{
+			ChannelID: int64(channelID),
+			Weekdays:  days,
+			Times:     times,
+			Timezone:  s.Data["rec_tz"],
+			Themes:    themes,
+			Style:     s.Data["style"],
+			Language:  s.Data["language"],
+			Length:    s.Data["length"],
+			IsActive:  true,
+		}
+		if _, err := db.CreateRecurringRule(database, &rule); err != nil {
+			log.Printf("❌ Не удалось сохранить правило: %v", err)
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось сохранить правило."))
+			return
+		}
+		for _, k := range []string{"rec_days", "rec_times", "rec_tz", "rec_themes"} {
+			delete(s.Data, k)
+		}
+
+		Bot.Send(tgbotapi.NewMessage(chatID, "✅ Правило сохранено:\n\n"+formatRule(rule)+
+			"\n\nЗа двое суток до публикации пришлю текст каждого поста — выйдут только одобренные."))
+		showRecurringMenu(chatID, s)
+	}
+}
@@ -0,0 +1,174 @@
+package bot
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"mybot/api"
+	"mybot/bot2"
+	"mybot/format"
+)
+
+// Генерация «вживую»: текст появляется в чате по мере того, как его пишет модель,
+// а в канал пост уходит только после подтверждения пользователем.
+
+// Не чаще одного редактирования в streamEditInterval — лимиты Telegram на editMessageText
+const streamEditInterval = 1500 * time.Millisecond
+
+// generatedPost — сгенерированный, но ещё не опубликованный пост
+type generatedPost struct {
+	ChannelUsername string
+	Theme           string
+	Style           string
+	Language        string
+	Length          string
+	FileID          string
+	Text            string
+	ImageQuery      string
+	MessageID       int // сообщение с предпросмотром и кнопками
+}
+
+// Ждущие подтверждения посты по chat_id (генерация идёт в горутине, отсюда мьютекс)
+var (
+	generatedMu    sync.Mutex
+	generatedPosts = make(map[int64]generatedPost)
+)
+
+// streamPreview — текст предпросмотра в чате: в пределах лимита сообщения и с закрытой разметкой
+func streamPreview(text string, done bool) string {
+	if len([]rune(text)) > previewTextLimit {
+		text = string([]rune(text)[:previewTextLimit]) + "…"
+	}
+	if !done {
+		text += " ▌"
+	}
+	return format.Render(text, format.ModeHTML)
+}
+
+func editPreview(chatID int64, messageID int, text string, markup *tgbotapi.InlineKeyboardMarkup) error {
+	edit := tgbotapi.NewEditMessageText(chatID, messageID, text)
+	edit.ParseMode = tgbotapi.ModeHTML
+	edit.ReplyMarkup = markup
+	_, err := Bot.Send(edit)
+	if err != nil && strings.Contains(err.Error(), "message is not modified") {
+		return nil
+	}
+	return err
+}
+
+// channelUsageContext — appCtx с пометкой канала для учёта расходов на LLM
+func channelUsageContext(channelUsername string) context.Context {
+	id, err := channelIDByUsername(database, channelUsername)
+	if err != nil {
+		return appCtx
+	}
+	return bot2.UsageContext(appCtx, database, int64(id))
+}
+
+// streamGeneratedPost генерирует текст, обновляя сообщение в чате, и показывает кнопки подтверждения
+func streamGeneratedPost(chatID int64, p generatedPost) {
+	channelID, err := channelIDByUsername(database, p.ChannelUsername)
+	if err != nil {
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не найден."))
+		return
+	}
+	if !reserveGeneration(chatID, int64(channelID)) {
+		return
+	}
+
+	sent, err := Bot.Send(tgbotapi.NewMessage(chatID, "✍️ …"))
+	if err != nil {
+		log.Printf("❌ Не удалось отправить черновик в chat_id=%d: %v", chatID, err)
+		refundGeneration(int64(channelID))
+		return
+	}
+	p.MessageID = sent.MessageID
+
+	ctx, cancel := context.WithTimeout(channelUsageContext(p.ChannelUsername), api.InteractiveTimeout)
+	defer cancel()
+
+	var lastEdit time.Time
+	var lastText string
+	prompt := bot2.BuildPrompt(p.Theme, p.Style, p.Language, p.Length)
+	generated, err := api.GeneratePostStream(ctx, prompt, func(partial string) {
+		// колбэк вызывается синхронно из цикла чтения, поэтому редактируем с троттлингом прямо здесь
+		if partial == "" || partial == lastText || time.Since(lastEdit) < streamEditInterval {
+			return
+		}
+		lastEdit, lastText = time.Now(), partial
+		if err := editPreview(chatID, p.MessageID, streamPreview(partial, false), nil); err != nil {
+			log.Printf("⚠️ Не удалось обновить черновик в chat_id=%d: %v", chatID, err)
+		}
+	})
+	if err != nil {
+		log.Printf("❌ Ошибка генерации поста для chat_id=%d: %v", chatID, err)
+		refundGeneration(int64(channelID))
+		editPreview(chatID, p.MessageID, "❌ Ошибка генерации поста", nil)
+		return
+	}
+
+	text := generated.Text()
+	p.Text, p.ImageQuery = text, generated.ImageQuery
+	generatedMu.Lock()
+	generatedPosts[chatID] = p
+	generatedMu.Unlock()
+
+	markup := bot2.GeneratedPostKeyboard()
+	if err := editPreview(chatID, p.MessageID, streamPreview(text, true), &markup); err != nil {
+		log.Printf("⚠️ Не удалось показать готовый пост в chat_id=%d: %v", chatID, err)
+		// редактирование не удалось (например, разметку не приняли) — присылаем отдельным сообщением
+		msg := tgbotapi.NewMessage(chatID, format.Plain(text))
+		msg.ReplyMarkup = markup
+		if m, err := Bot.Send(msg); err == nil {
+			generatedMu.Lock()
+			p.MessageID = m.MessageID
+			generatedPosts[chatID] = p
+			generatedMu.Unlock()
+		}
+	}
+}
+
+// handleGeneratedCallback — кнопки под сгенерированным постом. false — не наш callback.
+func handleGeneratedCallback(query *tgbotapi.CallbackQuery) bool {
+	if !strings.HasPrefix(query.Data, "gen_") {
+		return false
+	}
+	chatID := query.Message.Chat.ID
+	Bot.Request(tgbotapi.NewCallback(query.ID, ""))
+
+	generatedMu.Lock()
+	p, ok := generatedPosts[chatID]
+	if ok && p.MessageID == query.Message.MessageID {
+		delete(generatedPosts, chatID)
+	} else {
+		ok = false
+	}
+	generatedMu.Unlock()
+
+	if !ok {
+		Bot.Send(tgbotapi.NewMessage(chatID, "ℹ️ Этот вариант уже неактуален."))
+		return true
+	}
+
+	// убираем кнопки, чтобы не нажали дважды
+	Bot.Request(tgbotapi.NewEditMessageReplyMarkup(chatID, p.MessageID, tgbotapi.InlineKeyboardMarkup{InlineKeyboard: [][]tgbotapi.InlineKeyboardButton{}}))
+
+	switch query.Data {
+	case "gen_publish":
+		Bot.Send(tgbotapi.NewMessage(chatID, "⏳ Публикую…"))
+		go publishGenerated(chatID, p)
+
+	case "gen_regen":
+		p.Text, p.ImageQuery, p.MessageID = "", "", 0
+		go streamGeneratedPost(chatID, p)
+
+	case "gen_cancel":
+		Bot.Send(tgbotapi.NewMessage(chatID, "🚫 Пост не опубликован."))
+	}
+	return true
+}
@@ -0,0 +1,126 @@
+package bot
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"mybot/db"
+)
+
+// Отчёт о расходах на LLM для админов: /usage [ГГГГ-ММ]
+// Админы — chat_id через запятую в ADMIN_CHAT_IDS. Курс TON_USD_RATE (необязателен)
+// позволяет сравнить расходы канала с ценой его тарифа.
+
+// Сколько каналов показываем в отчёте (лимит длины сообщения)
+const usageReportRows = 40
+
+func isAdmin(chatID int64) bool {
+	for _, id := range strings.Split(os.Getenv("ADMIN_CHAT_IDS"), ",") {
+		if v, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64); err == nil && v == chatID {
+			return true
+		}
+	}
+	return false
+}
+
+// handleInfoCommand — команды, которые работают в любом состоянии и не трогают сессию.
+// false — команда не наша.
+func handleInfoCommand(msg *tgbotapi.Message) bool {
+	switch msg.Command() {
+	case "usage":
+		if !isAdmin(msg.Chat.ID) {
+			return false
+		}
+		sendUsageReport(msg.Chat.ID, msg.CommandArguments())
+		return true
+	case "balance":
+		sendBalance(msg.Chat.ID)
+		return true
+	}
+	return false
+}
+
+func sendUsageReport(chatID int64, arg string) {
+	now := time.Now().UTC()
+	from := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	if arg = strings.TrimSpace(arg); arg != "" {
+		m, err := time.Parse("2006-01", arg)
+		if err != nil {
+			Bot.Send(tgbotapi.NewMessage(chatID, "❌ Формат: /usage 2025-09"))
+			return
+		}
+		from = m
+	}
+	to := from.AddDate(0, 1, 0)
+
+	rows, err := db.GetLLMSpendByChannel(database, from, to)
+	if err != nil {
+		log.Printf("❌ Не удалось получить расходы на LLM: %v", err)
+		Bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось получить отчёт."))
+		return
+	}
+	if len(rows) == 0 {
+		Bot.Send(tgbotapi.NewMessage(chatID, "📊 За "+from.Format("2006-01")+" вызовов LLM не было."))
+		return
+	}
+
+	// курс для сравнения с ценой тарифа (0 — не задан)
+	rate, err := strconv.ParseFloat(os.Getenv("TON_USD_RATE"), 64)
+	if err != nil || rate < 0 {
+		rate = 0
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "📊 Расходы на LLM за %s (UTC)\n\n", from.Format("2006-01"))
+
+	var total float64
+	var calls int
+	for i, r := range rows {
+		total += r.CostUSD
+		calls += r.Calls
+		if i >= usageReportRows {
+			continue
+		}
+
+		name := "без канала"
+		if r.ChannelID != 0 {
+			name = fmt.Sprintf("#%d %s", r.ChannelID, r.ChannelTitle)
+		}
+		fmt.Fprintf(&b, "%s — $%.4f · %d выз.", name, r.CostUSD, r.Calls)
+		if r.Failed > 0 {
+			fmt.Fprintf(&b, " (%d ошиб.)", r.Failed)
+		}
+		fmt.Fprintf(&b, " · %d/%d ток.", r.PromptTokens, r.CompletionTokens)
+		if rate > 0 && r.PlanPriceTON > 0 {
+			fmt.Fprintf(&b, " · %.1f%% тарифа", r.CostUSD/(r.PlanPriceTON*rate)*100)
+		}
+		b.WriteString("\n")
+	}
+	if len(rows) > usageReportRows {
+		fmt.Fprintf(&b, "… и ещё каналов: %d\n", len(rows)-usageReportRows)
+	}
+
+	fmt.Fprintf(&b, "\nИтого: $%.4f · %d вызовов", total, calls)
+	if plans, err := db.GetActivePlans(database); err == nil && len(plans) > 0 {
+		var prices []string
+		for _, p := range plans {
+			price := fmt.Sprintf("%s %s TON", p.Name, p.PriceTON)
+			if ton, err := strconv.ParseFloat(p.PriceTON, 64); err == nil && rate > 0 {
+				price += fmt.Sprintf(" ≈ $%.2f", ton*rate)
+			}
+			prices = append(prices, price)
+		}
+		b.WriteString("\nТарифы: " + strings.Join(prices, "; "))
+		if rate == 0 {
+			b.WriteString("\n(задайте TON_USD_RATE, чтобы сравнить с расходами)")
+		}
+	}
+
+	Bot.Send(tgbotapi.NewMessage(chatID, b.String()))
+}
@@ -0,0 +1,119 @@
+package bot2
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"mybot/api"
+	"mybot/db"
+	"mybot/format"
+	"mybot/sub"
+)
+
+// Сколько черновиков регулярных расписаний готовим за один тик
+const draftBatchSize = 10
+
+// recurringDraftLead — за сколько до публикации готовить текст черновика расписания
+// (RECURRING_DRAFT_LEAD_HOURS, по умолчанию 12 ч): генерация тратит лимит тарифа,
+// поэтому весь горизонт развёртывания сразу не готовим
+func recurringDraftLead() time.Duration {
+	if h, err := strconv.Atoi(os.Getenv("RECURRING_DRAFT_LEAD_HOURS")); err == nil && h > 0 {
+		return time.Duration(h) * time.Hour
+	}
+	return 12 * time.Hour
+}
+
+// Запас под заголовок предпросмотра в пределах лимита Telegram (4096)
+const draftPreviewLimit = 3800
+
+// PrepareRecurringDrafts генерирует текст для ближайших черновиков из регулярных расписаний и отправляет
+// владельцу предпросмотр с кнопками одобрения — как у постов, запланированных вручную.
+// Публикуются только одобренные.
+func PrepareRecurringDrafts(ctx context.Context, bot *tgbotapi.BotAPI, database *sql.DB) {
+	now := time.Now()
+	drafts, err := db.RecurringDraftsToPrepare(database, now, now.Add(recurringDraftLead()), draftBatchSize)
+	if err != nil {
+		log.Println("❌ Ошибка при получении черновиков расписаний:", err)
+		return
+	}
+	for _, post := range drafts {
+		if ctx.Err() != nil {
+			return
+		}
+		prepareRecurringDraft(ctx, bot, database, post)
+	}
+}
+
+func prepareRecurringDraft(ctx context.Context, bot *tgbotapi.BotAPI, database *sql.DB, post db.ScheduledPost) {
+	ch, err := db.GetChannelByID(database, int(post.ChannelID))
+	if err != nil {
+		log.Printf("❌ Не удалось получить канал id=%d: %v", post.ChannelID, err)
+		return
+	}
+	client, err := db.GetClientByID(database, ch.ClientID)
+	if err != nil {
+		log.Printf("❌ Не удалось получить владельца канала id=%d: %v", post.ChannelID, err)
+		return
+	}
+	ok, err := sub.GuardGenerationQuota(bot, database, post.ID, int(post.ChannelID), ch.ChannelTitle, ch.ClientID)
+	if err != nil {
+		log.Printf("❌ %v", err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	genCtx, cancel := context.WithTimeout(ctx, api.BackgroundTimeout)
+	defer cancel()
+	genCtx = channelContext(genCtx, database, ch)
+
+	generated, err := RegenerateContent(genCtx, &post)
+	if err != nil {
+		refundGeneration(database, post.ChannelID)
+		log.Printf("❌ Ошибка генерации черновика #%d: %v", post.ID, err)
+		return
+	}
+	post.Content = generated.Text()
+	filled, err := db.FillRecurringDraft(database, post.ID, post.Content, generated.ImageQuery)
+	if err != nil || !filled {
+		refundGeneration(database, post.ChannelID)
+		if err != nil {
+			log.Printf("❌ Не удалось сохранить черновик #%d: %v", post.ID, err)
+		}
+		return
+	}
+
+	header := fmt.Sprintf("🔁 Пост по расписанию для @%s на %s — одобрите, чтобы он вышел:\n\n",
+		ch.ChannelTitle, post.PostAt.In(client.Location()).Format("02.01.06 15:04"))
+	if err := SendDraftPreview(bot, client.ChatID, header, post, ch.ParseMode); err != nil {
+		log.Printf("⚠️ Не удалось отправить предпросмотр поста #%d: %v", post.ID, err)
+	}
+}
+
+// SendDraftPreview — предпросмотр черновика с кнопками одобрения в разметке канала,
+// как он выйдет в канале; если Telegram не примет разметку — простым текстом
+func SendDraftPreview(bot *tgbotapi.BotAPI, chatID int64, header string, post db.ScheduledPost, mode string) error {
+	text := post.Content
+	if len([]rune(text)) > draftPreviewLimit {
+		text = string([]rune(text)[:draftPreviewLimit]) + "…"
+	}
+	mode = format.NormalizeMode(mode)
+	msg := tgbotapi.NewMessage(chatID, format.Render(header+text, mode))
+	msg.ParseMode = format.TelegramParseMode(mode)
+	msg.ReplyMarkup = DraftPreviewKeyboard(post.ID)
+	_, err := bot.Send(msg)
+	if err != nil && mode != format.ModePlain && format.IsEntityError(err) {
+		msg.Text = format.Plain(header + text)
+		msg.ParseMode = ""
+		_, err = bot.Send(msg)
+	}
+	return err
+}
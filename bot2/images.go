@@ -0,0 +1,101 @@
+package bot2
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"mybot/api"
+	"mybot/db"
+	"mybot/images"
+)
+
+// Кандидатов с одного стока: из них выбирается случайная, ещё не использованная картинка
+const imageCandidates = 15
+
+// imageHistorySize — сколько последних картинок канала не повторять (IMAGE_HISTORY_SIZE, по умолчанию 30)
+func imageHistorySize() int {
+	if n, err := strconv.Atoi(os.Getenv("IMAGE_HISTORY_SIZE")); err == nil && n >= 0 {
+		return n
+	}
+	return 30
+}
+
+// FindImage — картинка по запросу из источников канала по порядку (order — channels.image_providers).
+// Из страницы кандидатов берётся случайная с учётом релевантности, недавние картинки канала пропускаются.
+// По тексту поста (text) картинку рисует генерация, если она есть среди источников; стоки ищут по query.
+func FindImage(ctx context.Context, database *sql.DB, channelID int, order, query, text string) (PostImage, error) {
+	chain := images.ForOrder(order)
+	found, err := chain.Search(images.WithPostText(ctx, text), query, imageCandidates)
+	if err != nil {
+		return PostImage{}, err
+	}
+
+	used := map[string]int{}
+	if n := imageHistorySize(); n > 0 && channelID != 0 {
+		recent, err := db.RecentChannelImages(database, channelID, n)
+		if err != nil {
+			log.Printf("⚠️ Не удалось получить историю картинок channel_id=%d: %v", channelID, err)
+		}
+		for i, r := range recent {
+			key := images.Image{Provider: r.Provider, ID: r.ImageID}.Key()
+			if _, ok := used[key]; !ok {
+				used[key] = i
+			}
+		}
+	}
+
+	img, _ := images.Pick(found, used, nil)
+	if len(img.Data) > 0 {
+		log.Printf("🎨 Картинка сгенерирована (%s) по запросу %q: %d байт", img.Provider, query, len(img.Data))
+	} else {
+		log.Printf("🖼 Картинка из %s по запросу %q (%d кандидатов): %s%s", img.Provider, query, len(found), img.URL, img.Path)
+	}
+	return PostImage{URL: img.URL, Path: img.Path, Data: img.Data, Stock: &img}, nil
+}
+
+// ImageTimeout — дедлайн на подбор картинки в чате: генерация идёт дольше поиска в стоках
+func ImageTimeout(order string) time.Duration {
+	if images.Generates(order) {
+		return api.BackgroundTimeout
+	}
+	return api.InteractiveTimeout
+}
+
+// RememberImage — записать картинку опубликованного поста (со стока или сгенерированную)
+// с атрибуцией в историю канала. Возвращает id записи channel_images
+// (0 — картинка своя, например фото пользователя, или записать не удалось).
+func RememberImage(database *sql.DB, channelID int, img PostImage) int64 {
+	if img.Stock == nil || channelID == 0 {
+		return 0
+	}
+	id, err := db.RecordChannelImage(database, db.ChannelImage{
+		ChannelID: channelID,
+		Provider:  img.Stock.Provider,
+		ImageID:   img.Stock.ID,
+		URL:       img.Stock.URL,
+		PageURL:   img.Stock.PageURL,
+		Author:    img.Stock.Author,
+		AuthorURL: img.Stock.AuthorURL,
+		License:   img.Stock.License,
+	})
+	if err != nil {
+		log.Printf("⚠️ Не удалось записать картинку в историю channel_id=%d: %v", channelID, err)
+	}
+	return id
+}
+
+// WithCredit — текст поста с подписью автора картинки в конце, если канал её включил
+func WithCredit(src string, img PostImage, enabled bool) string {
+	if !enabled || img.Stock == nil {
+		return src
+	}
+	if credit := images.Credit(*img.Stock); credit != "" {
+		return strings.TrimRight(src, "\n") + "\n\n" + credit
+	}
+	return src
+}
@@ -18,8 +18,17 @@ func MainKeyboardWithBack() tgbotapi.ReplyKeyboardMarkup {
 			tgbotapi.NewKeyboardButton("✏️ Редактировать пост"),
 		),
 		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("🔁 Регулярные посты"),
 			tgbotapi.NewKeyboardButton("🔄 Сменить канал"),
 		),
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("🌍 Часовой пояс"),
+			tgbotapi.NewKeyboardButton("🔤 Форматирование"),
+		),
+		tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton("🖼 Картинки"),
+			tgbotapi.NewKeyboardButton("🧠 Модель"),
+		),
 	)
 }
 
@@ -82,3 +91,112 @@ func DeletePostKeyboard(posts []db.ScheduledPost) tgbotapi.ReplyKeyboardMarkup {
 	rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("⬅️ Назад")))
 	return tgbotapi.NewReplyKeyboard(rows...)
 }
+
+// Кнопки под предпросмотром запланированного поста
+func DraftPreviewKeyboard(postID int64) tgbotapi.InlineKeyboardMarkup {
+	id := fmt.Sprintf("%d", postID)
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Одобрить", "post_approve:"+id),
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Перегенерировать", "post_regen:"+id),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✏️ Изменить текст", "post_edit:"+id),
+		),
+	)
+}
+
+// Кнопки под постом, сгенерированным в чате: публиковать сразу или попробовать ещё раз
+func GeneratedPostKeyboard() tgbotapi.InlineKeyboardMarkup {
+	return tgbotapi.NewInlineKeyboardMarkup(
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("✅ Опубликовать", "gen_publish"),
+			tgbotapi.NewInlineKeyboardButtonData("🔄 Заново", "gen_regen"),
+		),
+		tgbotapi.NewInlineKeyboardRow(
+			tgbotapi.NewInlineKeyboardButtonData("🚫 Отмена", "gen_cancel"),
+		),
+	)
+}
+
+// Клавиатура регулярных расписаний: создание, пауза/включение и удаление правил
+func RecurringRulesKeyboard(rules []db.RecurringRule) tgbotapi.ReplyKeyboardMarkup {
+	rows := [][]tgbotapi.KeyboardButton{
+		tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("➕ Новое правило")),
+	}
+	for i, r := range rules {
+		toggle := fmt.Sprintf("⏸ Пауза %d", i+1)
+		if !r.IsActive {
+			toggle = fmt.Sprintf("▶️ Включить %d", i+1)
+		}
+		rows = append(rows, tgbotapi.NewKeyboardButtonRow(
+			tgbotapi.NewKeyboardButton(toggle),
+			tgbotapi.NewKeyboardButton(fmt.Sprintf("🗑 Удалить правило %d", i+1)),
+		))
+	}
+	rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("⬅️ Назад")))
+	return tgbotapi.NewReplyKeyboard(rows...)
+}
+
+var Weekdays = tgbotapi.NewReplyKeyboard(
+	tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButton("будни"),
+		tgbotapi.NewKeyboardButton("ежедневно"),
+		tgbotapi.NewKeyboardButton("выходные"),
+	),
+)
+
+var Timezone = tgbotapi.NewReplyKeyboard(
+	tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButton("Europe/Moscow"),
+		tgbotapi.NewKeyboardButton("Europe/Kyiv"),
+	),
+	tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButton("Asia/Almaty"),
+		tgbotapi.NewKeyboardButton("UTC"),
+	),
+)
+
+// Источники картинок: готовые варианты порядка (можно прислать свой)
+var ImageProviders = tgbotapi.NewReplyKeyboard(
+	tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButton("По умолчанию"),
+	),
+	tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButton("unsplash, pexels, pixabay"),
+		tgbotapi.NewKeyboardButton("pixabay, pexels, unsplash"),
+	),
+	tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButton("local"),
+		tgbotapi.NewKeyboardButton("pexels, local"),
+	),
+	tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButton("ai"),
+		tgbotapi.NewKeyboardButton("pexels, unsplash, pixabay, ai"),
+	),
+	tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButton(ImageCreditButton),
+	),
+)
+
+// LLMModelKeyboard — «По умолчанию» и провайдеры цепочки (модель можно дописать через пробел)
+func LLMModelKeyboard(providers []string) tgbotapi.ReplyKeyboardMarkup {
+	rows := [][]tgbotapi.KeyboardButton{tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton("По умолчанию"))}
+	for _, name := range providers {
+		rows = append(rows, tgbotapi.NewKeyboardButtonRow(tgbotapi.NewKeyboardButton(name)))
+	}
+	return tgbotapi.NewReplyKeyboard(rows...)
+}
+
+// ImageCreditButton — включить/выключить подпись автора картинки под постами
+const ImageCreditButton = "📷 Подпись автора"
+
+var ParseMode = tgbotapi.NewReplyKeyboard(
+	tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButton("HTML"),
+		tgbotapi.NewKeyboardButton("MarkdownV2"),
+	),
+	tgbotapi.NewKeyboardButtonRow(
+		tgbotapi.NewKeyboardButton("Без форматирования"),
+	),
+)
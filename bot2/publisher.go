@@ -1,125 +1,313 @@
 package bot2
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"os"
+	"strconv"
 	"time"
+	"unicode/utf8"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 
 	"mybot/api"
 	"mybot/db"
-	"mybot/pexels"
 	"mybot/sub"
 )
 
-// Публикация всех запланированных постов, у которых время наступило
-func PublishScheduledPosts(bot *tgbotapi.BotAPI, database *sql.DB) {
-	now := time.Now()
+// Сколько постов публикуем за один тик. Забираем их по одному: публикация поста идёт
+// до api.BackgroundTimeout, и аренда пачки истекла бы раньше, чем до неё дойдёт очередь.
+const publishBatchSize = 20
 
-	posts, err := db.GetScheduledPostsByTime(database, now)
-	if err != nil {
-		log.Println("❌ Ошибка при получении постов:", err)
-		return
+// Максимум попыток публикации, после которых пост уходит в failed (PUBLISH_MAX_ATTEMPTS)
+func publishMaxAttempts() int {
+	n := 5
+	if v, err := strconv.Atoi(os.Getenv("PUBLISH_MAX_ATTEMPTS")); err == nil && v > 0 {
+		n = v
 	}
+	return n
+}
 
-	for _, post := range posts {
-		// Берём канал (для paywall и служебных полей)
-		ch, err := db.GetChannelByID(database, int(post.ChannelID))
-		if err != nil {
-			log.Printf("❌ Не удалось получить канал id=%d: %v\n", post.ChannelID, err)
-			continue
+// Задержка перед следующей попыткой: 1м, 2м, 4м, ... но не больше 6 часов
+func publishBackoff(attempt int) time.Duration {
+	base := time.Minute
+	if v, err := strconv.Atoi(os.Getenv("PUBLISH_BACKOFF_SECONDS")); err == nil && v > 0 {
+		base = time.Duration(v) * time.Second
+	}
+	const maxDelay = 6 * time.Hour
+	d := base
+	for i := 1; i < attempt; i++ {
+		d *= 2
+		if d >= maxDelay {
+			return maxDelay
 		}
+	}
+	return d
+}
 
-		// 🔒 Paywall: не публикуем без активной подписки (уведомление владельцу делает helper)
-		if !sub.GuardActiveSubscription(bot, database, int(post.ChannelID), ch.ChannelTitle, ch.ClientID) {
-			// подписка неактивна — пропускаем этот пост (оставляем в таблице)
-			continue
+// Аренда взятого поста: если процесс упал после claim, через это время пост снова станет доступен.
+// Не короче api.BackgroundTimeout, иначе пост перехватят, пока он ещё публикуется.
+func publishLease() time.Duration {
+	minutes := 10
+	if m, err := strconv.Atoi(os.Getenv("PUBLISH_LEASE_MINUTES")); err == nil && m > 0 {
+		minutes = m
+	}
+	return max(time.Duration(minutes)*time.Minute, api.BackgroundTimeout+time.Minute)
+}
+
+// Публикация всех запланированных постов, у которых время наступило.
+// ctx отменяется при остановке бота: недоделанные посты возвращаются в очередь.
+func PublishScheduledPosts(ctx context.Context, bot *tgbotapi.BotAPI, database *sql.DB) {
+	// обработанные на этом тике: возвращённый в очередь пост (нет подписки, сбой базы)
+	// не берём снова, иначе он заслонил бы остальные до следующего тика
+	var done []int64
+	for range publishBatchSize {
+		if ctx.Err() != nil {
+			return
+		}
+		posts, err := db.ClaimDueScheduledPosts(database, time.Now(), publishLease(), 1, done)
+		if err != nil {
+			log.Println("❌ Ошибка при получении постов:", err)
+			return
+		}
+		if len(posts) == 0 {
+			return
 		}
+		done = append(done, posts[0].ID)
+
+		postCtx, cancel := context.WithTimeout(ctx, api.BackgroundTimeout)
+		publishPost(postCtx, bot, database, posts[0])
+		cancel()
+	}
+}
 
-		// username канала для публикации (в формате "@channel")
-		channelUsername, err := db.GetChannelUsernameByID(database, int(post.ChannelID))
-		if err != nil || channelUsername == "" {
-			log.Printf("❌ Не удалось получить username канала для channel_id=%d: %v\n", post.ChannelID, err)
-			continue
+// publishPost — публикация одного захваченного поста
+func publishPost(ctx context.Context, bot *tgbotapi.BotAPI, database *sql.DB, post db.ScheduledPost) {
+	// Берём канал (для paywall и служебных полей)
+	ch, err := db.GetChannelByID(database, int(post.ChannelID))
+	if err != nil {
+		log.Printf("❌ Не удалось получить канал id=%d: %v\n", post.ChannelID, err)
+		if err == sql.ErrNoRows {
+			// канала больше нет — публиковать некуда
+			if _, err := db.MarkScheduledPostFailed(database, post.ID, post.ClaimToken, "канал не найден"); err != nil {
+				log.Printf("❌ Не удалось отметить пост #%d как failed: %v", post.ID, err)
+			}
+			return
 		}
+		releasePost(database, post)
+		return
+	}
 
-		// 1) Генерация текста поста
-		style := map[string]string{
-			"🤓 Экспертный":     "expert",
-			"😊 Дружелюбный":    "friendly",
-			"📢 Информационный": "informational",
-			"🎭 Лирический":     "lyrical",
-		}[post.Style]
-
-		lang := map[string]string{
-			"🇷🇺 Русский":    "ru",
-			"🇬🇧 Английский": "en",
-		}[post.Language]
-
-		length := map[string]string{
-			"✏️ Короткий": "short",
-			"📄 Средний":   "medium",
-			"📚 Длинный":   "long",
-		}[post.Length]
-
-		prompt := fmt.Sprintf(
-			"Сгенерируй %s пост на тему %q в стиле %s на языке %s",
-			length, post.Theme, style, lang,
-		)
-
-		text, _, err := api.GeneratePostFromPrompt(prompt)
-		if err != nil || text == "" {
+	// 🔒 Paywall: не публикуем без активной подписки (уведомление владельцу делает helper)
+	if !sub.GuardActiveSubscription(bot, database, int(post.ChannelID), ch.ChannelTitle, ch.ClientID) {
+		// подписка неактивна — возвращаем пост в очередь
+		releasePost(database, post)
+		return
+	}
+
+	// все вызовы LLM ниже учитываются на канал и его владельца
+	ctx = channelContext(ctx, database, ch)
+
+	// username канала для публикации (в формате "@channel")
+	channelUsername, err := db.GetChannelUsernameByID(database, int(post.ChannelID))
+	if err != nil || channelUsername == "" {
+		log.Printf("❌ Не удалось получить username канала для channel_id=%d: %v\n", post.ChannelID, err)
+		releasePost(database, post)
+		return
+	}
+
+	// 1) Текст поста: одобренный владельцем; старые записи без текста генерируем на лету
+	text := post.Content
+	if text == "" {
+		// генерация на лету расходует лимит канала
+		ok, err := sub.GuardGenerationQuota(bot, database, post.ID, int(post.ChannelID), ch.ChannelTitle, ch.ClientID)
+		if err != nil {
+			log.Printf("❌ %v", err)
+			releasePost(database, post)
+			return
+		}
+		if !ok {
+			retryOrFail(bot, database, post, ch, "лимит генераций исчерпан")
+			return
+		}
+		generated, err := RegenerateContent(ctx, &post)
+		if err != nil {
+			refundGeneration(database, post.ChannelID)
+		}
+		if errors.Is(err, context.Canceled) {
+			// бот останавливается — это не неудачная попытка
+			releasePost(database, post)
+			return
+		}
+		if err != nil {
 			log.Printf("❌ Ошибка генерации текста для channel_id=%d: %v", post.ChannelID, err)
-			continue
+			retryOrFail(bot, database, post, ch, fmt.Sprintf("ошибка генерации текста: %v", err))
+			return
 		}
+		text, post.ImageQuery = generated.Text(), generated.ImageQuery
+	}
 
-		// 2) Картинка
-		if post.Photo != "" {
-			// Фото, которое прислал пользователь
-			photo := tgbotapi.NewPhotoToChannel(channelUsername, tgbotapi.FileID(post.Photo))
-			if _, err := bot.Send(photo); err != nil {
-				log.Printf("❌ Ошибка отправки фото в %s: %v", channelUsername, err)
-				// не прерываем — текст всё равно отправим
-			}
+	// 2) Картинка
+	var img PostImage
+	if post.Photo != "" {
+		// Фото, которое прислал пользователь
+		img.FileID = post.Photo
+	} else {
+		// Фото из источников канала по запросу из ответа модели (или по переведённой теме)
+		query := ImageSearchQuery(ctx, post.ImageQuery, post.Theme)
+		found, err := FindImage(ctx, database, ch.ID, ch.ImageProviders, query, text)
+		if err != nil {
+			// без картинки пост всё равно уйдёт текстом
+			log.Printf("⚠️ Не удалось найти фото по теме: %s (запрос: %s): %v", post.Theme, query, err)
 		} else {
-			// Фото с Pexels по теме
-			translated, err := api.Translate(post.Theme, "en")
-			if err != nil || translated == "" {
-				log.Printf("⚠️ Не удалось перевести тему %q, используем как есть", post.Theme)
-				translated = post.Theme
-			}
-
-			imgURL, err := pexels.FetchImage(translated)
-			if err != nil || imgURL == "" {
-				log.Printf("⚠️ Не удалось найти фото по теме: %s (перевод: %s)", post.Theme, translated)
-			} else {
-				photo := tgbotapi.NewPhotoToChannel(channelUsername, tgbotapi.FileURL(imgURL))
-				if _, err := bot.Send(photo); err != nil {
-					log.Printf("❌ Ошибка отправки фото из Pexels в %s: %v", channelUsername, err)
-				}
-			}
+			img = found
 		}
+	}
 
-		// 3) Текст
-		msg := tgbotapi.NewMessageToChannel(channelUsername, text)
-		if _, err := bot.Send(msg); err != nil {
-			log.Printf("❌ Ошибка публикации текста в %s: %v", channelUsername, err)
-			continue
-		}
+	// 3) Фото с подписью одним сообщением (или фото + ответ, если текст длинный)
+	if err := SendPost(bot, channelUsername, img, WithCredit(text, img, ch.ImageCredit), ch.ParseMode); err != nil {
+		log.Printf("❌ Ошибка публикации поста в %s: %v", channelUsername, err)
+		retryOrFail(bot, database, post, ch, fmt.Sprintf("ошибка отправки в канал: %v", err))
+		return
+	}
+
+	log.Printf("✅ Пост опубликован в %s", channelUsername)
+	imageID := RememberImage(database, ch.ID, img)
+
+	// 4) Фиксируем публикацию вместе с картинкой и её атрибуцией — строка остаётся в истории
+	if err := db.MarkScheduledPostPublished(database, post.ID, post.ClaimToken, imageID); err != nil {
+		log.Printf("❌ Не удалось отметить пост #%d опубликованным: %v", post.ID, err)
+	}
+}
 
-		log.Printf("✅ Пост опубликован в %s", channelUsername)
+// retryOrFail фиксирует неудачную попытку: либо откладывает пост с backoff,
+// либо, если попытки кончились, переводит его в failed и один раз пишет владельцу.
+func retryOrFail(bot *tgbotapi.BotAPI, database *sql.DB, post db.ScheduledPost, ch db.Channel, reason string) {
+	// по символам: ошибки на кириллице, срез по байтам оставил бы в last_error битый UTF-8
+	if utf8.RuneCountInString(reason) > 500 {
+		reason = string([]rune(reason)[:500]) + "…"
+	}
 
-		// 4) Удаляем задачу из расписания
-		if err := db.DeleteScheduledPostByID(database, post.ID); err != nil {
-			log.Printf("❌ Не удалось удалить запланированный пост #%d: %v", post.ID, err)
+	attempt := post.Attempts + 1
+	if attempt < publishMaxAttempts() {
+		next := time.Now().Add(publishBackoff(attempt))
+		if err := db.RetryScheduledPostLater(database, post.ID, post.ClaimToken, reason, next); err != nil {
+			log.Printf("❌ Не удалось отложить пост #%d: %v", post.ID, err)
+			return
 		}
+		log.Printf("🔁 Пост #%d: попытка %d не удалась, повтор в %s", post.ID, attempt, next.Format(time.RFC3339))
+		return
+	}
+
+	failed, err := db.MarkScheduledPostFailed(database, post.ID, post.ClaimToken, reason)
+	if err != nil {
+		log.Printf("❌ Не удалось отметить пост #%d как failed: %v", post.ID, err)
+		return
+	}
+	if !failed {
+		return
+	}
+	log.Printf("⛔ Пост #%d снят с публикации после %d попыток: %s", post.ID, attempt, reason)
+
+	client, err := db.GetClientByID(database, ch.ClientID)
+	if err != nil || client.ChatID == 0 {
+		log.Printf("⚠️ Не удалось найти владельца канала id=%d: %v", ch.ID, err)
+		return
+	}
+	msg := tgbotapi.NewMessage(client.ChatID, fmt.Sprintf(
+		"⛔ Запланированный пост для @%s на тему %q (%s) не опубликован после %d попыток.\n\nПричина: %s",
+		ch.ChannelTitle, post.Theme, post.PostAt.In(client.Location()).Format("02.01.06 15:04"), attempt, reason))
+	if _, err := bot.Send(msg); err != nil {
+		log.Printf("⚠️ Не удалось уведомить владельца о посте #%d: %v", post.ID, err)
 	}
 }
 
+func refundGeneration(database *sql.DB, channelID int64) {
+	if err := db.RefundGeneration(database, channelID); err != nil {
+		log.Printf("❌ Не удалось вернуть генерацию каналу id=%d: %v", channelID, err)
+	}
+}
+
+func releasePost(database *sql.DB, post db.ScheduledPost) {
+	if err := db.ReleaseScheduledPost(database, post.ID, post.ClaimToken); err != nil {
+		log.Printf("❌ Не удалось вернуть пост #%d в очередь: %v", post.ID, err)
+	}
+}
+
+// BuildPrompt собирает промпт для LLM из параметров, выбранных кнопками
+func BuildPrompt(theme, style, language, length string) string {
+	st := map[string]string{
+		"🤓 Экспертный":     "expert",
+		"😊 Дружелюбный":    "friendly",
+		"📢 Информационный": "informational",
+		"🎭 Лирический":     "lyrical",
+	}[style]
+
+	lang := map[string]string{
+		"🇷🇺 Русский":    "ru",
+		"🇬🇧 Английский": "en",
+	}[language]
+
+	ln := map[string]string{
+		"✏️ Короткий": "short",
+		"📄 Средний":   "medium",
+		"📚 Длинный":   "long",
+	}[length]
+
+	return fmt.Sprintf("Сгенерируй %s пост на тему %q в стиле %s на языке %s", ln, theme, st, lang)
+}
+
+// RegenerateContent генерирует пост по его параметрам (тема/стиль/язык/длина)
+func RegenerateContent(ctx context.Context, post *db.ScheduledPost) (api.Post, error) {
+	prompt := BuildPrompt(post.Theme, post.Style, post.Language, post.Length)
+	return api.GeneratePostFromPrompt(ctx, prompt)
+}
+
+// UsageContext помечает вызовы LLM каналом и его владельцем — для учёта расходов —
+// и направляет их к своей модели канала, если её включает тариф
+func UsageContext(ctx context.Context, database *sql.DB, channelID int64) context.Context {
+	ch, err := db.GetChannelByID(database, int(channelID))
+	if err != nil {
+		return api.WithUsageMeta(ctx, api.UsageMeta{ChannelID: channelID})
+	}
+	return channelContext(ctx, database, ch)
+}
+
+func channelContext(ctx context.Context, database *sql.DB, ch db.Channel) context.Context {
+	ctx = api.WithUsageMeta(ctx, api.UsageMeta{ChannelID: int64(ch.ID), ClientID: int64(ch.ClientID)})
+	if ch.LLMProvider == "" {
+		return ctx
+	}
+	// тариф могли понизить после выбора модели — тогда общая цепочка
+	if plan, err := sub.ChannelPlan(database, &ch); err != nil || !plan.AllowCustomModel {
+		return ctx
+	}
+	return api.WithModelChoice(ctx, api.ModelChoice{Provider: ch.LLMProvider, Model: ch.LLMModel})
+}
+
+// ImageSearchQuery — запрос для стоков: image_query из ответа модели,
+// а для старых постов и ручных правок — тема, переведённая на английский
+func ImageSearchQuery(ctx context.Context, imageQuery, theme string) string {
+	if imageQuery != "" {
+		return imageQuery
+	}
+	translated, err := api.Translate(ctx, theme, "en")
+	if err != nil || translated == "" {
+		log.Printf("⚠️ Не удалось перевести тему %q, используем как есть", theme)
+		return theme
+	}
+	return translated
+}
+
 // Для предпросмотра в UI/логах
-func RegenerateContent(post *db.ScheduledPost) string {
-	return fmt.Sprintf("📝 Тема: %s\n✍️ Стиль: %s\n🌐 Язык: %s\n📄 Длина: %s",
+func PostSummary(post *db.ScheduledPost) string {
+	summary := fmt.Sprintf("📝 Тема: %s\n✍️ Стиль: %s\n🌐 Язык: %s\n📄 Длина: %s",
 		post.Theme, post.Style, post.Language, post.Length)
+	if post.Status == db.PostStatusDraft {
+		summary += "\n⏸ Ждёт одобрения"
+	}
+	return summary
 }
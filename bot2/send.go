@@ -0,0 +1,170 @@
+package bot2
+
+import (
+	"fmt"
+	"html"
+	"log"
+	"os"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"mybot/format"
+	"mybot/images"
+)
+
+// Лимиты Telegram (в символах UTF-16): подпись к фото и обычное сообщение
+const (
+	captionLimit = 1024
+	messageLimit = 4096
+)
+
+// Запас под номер части "\n\n12/12"
+const numberingReserve = 10
+
+// PostImage — картинка поста: file_id из Telegram (фото пользователя), URL (стоки) или Path (локальная папка)
+type PostImage struct {
+	FileID string
+	URL    string
+	Path   string
+	Data   []byte        // сгенерированная картинка, загружается байтами
+	Stock  *images.Image // откуда картинка (для истории канала); nil — фото пользователя
+}
+
+func (img PostImage) IsEmpty() bool {
+	return img.FileID == "" && img.URL == "" && img.Path == "" && len(img.Data) == 0
+}
+
+func (img PostImage) file() tgbotapi.RequestFileData {
+	if img.FileID != "" {
+		return tgbotapi.FileID(img.FileID)
+	}
+	if img.Path != "" {
+		return tgbotapi.FilePath(img.Path)
+	}
+	if len(img.Data) > 0 {
+		return tgbotapi.FileBytes{Name: "image.png", Bytes: img.Data}
+	}
+	return tgbotapi.FileURL(img.URL)
+}
+
+func textLen(s string) int {
+	return format.Len(s)
+}
+
+// Длинный текст с картинкой-URL можно отправить одним сообщением с превью ссылки (POST_LONG_TEXT_MODE=preview).
+// По умолчанию — фото и текст ответом на него.
+func longTextAsPreview() bool {
+	return os.Getenv("POST_LONG_TEXT_MODE") == "preview"
+}
+
+// Нумеровать части длинного поста (POST_SPLIT_NUMBERING=1): "1/3", "2/3", …
+func numberParts() bool {
+	return os.Getenv("POST_SPLIT_NUMBERING") == "1"
+}
+
+// splitPost режет текст на сообщения в пределах limit и при необходимости нумерует их
+func splitPost(src, mode string, limit int) []string {
+	numbered := numberParts()
+	if numbered && textLen(format.Plain(src)) > limit {
+		limit -= numberingReserve
+	}
+	parts := format.Split(src, mode, limit)
+	if len(parts) == 0 {
+		return []string{format.Render(src, mode)}
+	}
+	if numbered && len(parts) > 1 {
+		for i := range parts {
+			parts[i] = fmt.Sprintf("%s\n\n%d/%d", parts[i], i+1, len(parts))
+		}
+	}
+	return parts
+}
+
+// SendPost публикует пост в канал.
+// Текст влезает в подпись — одно фото с подписью. Не влезает — фото + текст ответом на него
+// (или текст с превью картинки). Текст длиннее лимита сообщения уходит несколькими частями по порядку.
+// Если хоть одна часть не ушла, уже отправленное удаляем: пост считается опубликованным только целиком.
+// src — текст модели в разметке format; mode — режим канала. Если Telegram отверг разметку,
+// пост уходит простым текстом.
+func SendPost(bot *tgbotapi.BotAPI, channelUsername string, img PostImage, src string, mode string) error {
+	mode = format.NormalizeMode(mode)
+	err := sendPost(bot, channelUsername, img, src, mode)
+	if err != nil && mode != format.ModePlain && format.IsEntityError(err) {
+		log.Printf("⚠️ Telegram отклонил разметку %s в %s, отправляем простым текстом: %v", mode, channelUsername, err)
+		return sendPost(bot, channelUsername, img, src, format.ModePlain)
+	}
+	return err
+}
+
+func sendPost(bot *tgbotapi.BotAPI, channelUsername string, img PostImage, src string, mode string) error {
+	parseMode := format.TelegramParseMode(mode)
+
+	if img.IsEmpty() {
+		return sendParts(bot, channelUsername, splitPost(src, mode, messageLimit), parseMode, 0, nil)
+	}
+
+	// лимит подписи считается по видимому тексту, без разметки
+	if textLen(format.Plain(src)) <= captionLimit {
+		photo := tgbotapi.NewPhotoToChannel(channelUsername, img.file())
+		photo.Caption = format.Render(src, mode)
+		photo.ParseMode = parseMode
+		_, err := bot.Send(photo)
+		return err
+	}
+
+	if img.URL != "" && img.FileID == "" && longTextAsPreview() {
+		// Невидимая ссылка в начале первой части — Telegram покажет картинку как превью над текстом
+		var parts []string
+		if mode == format.ModePlain {
+			parts = splitPost(src, format.ModePlain, messageLimit-1)
+			for i := range parts {
+				parts[i] = html.EscapeString(parts[i])
+			}
+		} else {
+			parts = splitPost(src, format.ModeHTML, messageLimit-1)
+		}
+		parts[0] = fmt.Sprintf(`<a href="%s">&#8203;</a>%s`, html.EscapeString(img.URL), parts[0])
+		return sendParts(bot, channelUsername, parts, tgbotapi.ModeHTML, 0, nil)
+	}
+
+	photoMsg, err := bot.Send(tgbotapi.NewPhotoToChannel(channelUsername, img.file()))
+	if err != nil {
+		return fmt.Errorf("фото: %w", err)
+	}
+	return sendParts(bot, channelUsername, splitPost(src, mode, messageLimit), parseMode, photoMsg.MessageID, []int{photoMsg.MessageID})
+}
+
+// sendParts отправляет части по порядку; первая — ответом на replyTo (если задан).
+// sent — уже отправленные сообщения поста: при ошибке они удаляются вместе с ушедшими частями.
+func sendParts(bot *tgbotapi.BotAPI, channelUsername string, parts []string, parseMode string, replyTo int, sent []int) error {
+	for i, part := range parts {
+		msg := tgbotapi.NewMessageToChannel(channelUsername, part)
+		msg.ParseMode = parseMode
+		if i == 0 {
+			msg.ReplyToMessageID = replyTo
+		}
+		m, err := bot.Send(msg)
+		if err != nil {
+			rollback(bot, channelUsername, sent)
+			if len(parts) > 1 {
+				return fmt.Errorf("текст, часть %d/%d: %w", i+1, len(parts), err)
+			}
+			return fmt.Errorf("текст: %w", err)
+		}
+		sent = append(sent, m.MessageID)
+	}
+	if len(parts) > 1 {
+		log.Printf("✂️ Пост в %s отправлен частями: %d", channelUsername, len(parts))
+	}
+	return nil
+}
+
+// rollback удаляет уже отправленные сообщения поста, чтобы в канале не осталось половины поста
+func rollback(bot *tgbotapi.BotAPI, channelUsername string, messageIDs []int) {
+	for _, id := range messageIDs {
+		del := tgbotapi.DeleteMessageConfig{ChannelUsername: channelUsername, MessageID: id}
+		if _, err := bot.Request(del); err != nil {
+			log.Printf("⚠️ Не удалось удалить сообщение #%d в %s: %v", id, channelUsername, err)
+		}
+	}
+}
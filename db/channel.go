@@ -36,6 +36,14 @@ type Channel struct {
 	SubscriptionUntil time.Time
 	CreatedAt         time.Time
 	Username          string
+	ParseMode         string // HTML / MarkdownV2 / plain
+	GenerationQuota   int    // генераций на период подписки, 0 — без лимита
+	GenerationsUsed   int
+	PlanID            int    // 0 — тариф не выбран (берётся тариф по умолчанию)
+	ImageProviders    string // источники картинок по порядку, "" — порядок по умолчанию
+	ImageCredit       bool   // добавлять к посту подпись автора картинки
+	LLMProvider       string // своя модель LLM (если тариф позволяет), "" — общая цепочка провайдеров
+	LLMModel          string // "" — модель провайдера по умолчанию
 }
 
 // Получение канала по внутреннему ID
@@ -55,7 +63,15 @@ func GetChannelByID(db *sql.DB, id int) (Channel, error) {
 			channel_title,
 			subscription_until,
 			is_active,
-			wallet_address
+			wallet_address,
+			parse_mode,
+			generation_quota,
+			generations_used,
+			COALESCE(plan_id, 0),
+			image_providers,
+			image_credit,
+			llm_provider,
+			llm_model
 		FROM channels
 		WHERE id = $1
 	`
@@ -67,6 +83,14 @@ func GetChannelByID(db *sql.DB, id int) (Channel, error) {
 		&nt,
 		&c.IsActive,
 		&walt,
+		&c.ParseMode,
+		&c.GenerationQuota,
+		&c.GenerationsUsed,
+		&c.PlanID,
+		&c.ImageProviders,
+		&c.ImageCredit,
+		&c.LLMProvider,
+		&c.LLMModel,
 	)
 	if err != nil {
 		return c, err
@@ -263,9 +287,35 @@ func GetChannelUsernameByID(db *sql.DB, id int) (string, error) {
 func UpdateChannel(db *sql.DB, ch *Channel) error {
 	_, err := db.Exec(`
 		UPDATE channels
-		SET subscription_until = $1, wallet_address = $2
-		WHERE id = $3
-	`, ch.SubscriptionUntil, ch.WalletAddress, ch.ID)
+		SET subscription_until = $1, wallet_address = $2,
+		    generation_quota = $3, generations_used = $4, plan_id = NULLIF($5, 0)
+		WHERE id = $6
+	`, ch.SubscriptionUntil, ch.WalletAddress, ch.GenerationQuota, ch.GenerationsUsed, ch.PlanID, ch.ID)
+	return err
+}
+
+// UseGeneration списывает одну генерацию из лимита канала.
+// false — лимит на текущий период исчерпан (списания не было).
+func UseGeneration(db *sql.DB, channelID int64) (bool, error) {
+	res, err := db.Exec(`
+		UPDATE channels
+		SET generations_used = generations_used + 1
+		WHERE id = $1 AND (generation_quota <= 0 OR generations_used < generation_quota)
+	`, channelID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// RefundGeneration возвращает генерацию, если она не удалась
+func RefundGeneration(db *sql.DB, channelID int64) error {
+	_, err := db.Exec(`
+		UPDATE channels
+		SET generations_used = GREATEST(generations_used - 1, 0)
+		WHERE id = $1
+	`, channelID)
 	return err
 }
 
@@ -328,3 +378,27 @@ func GetChannelIDByUsernameAndChat(dbConn *sql.DB, chatID int64, username string
 	}
 	return id, nil
 }
+
+// SetChannelImageProviders — порядок источников картинок канала ("" — по умолчанию)
+func SetChannelImageProviders(db *sql.DB, channelID int, order string) error {
+	_, err := db.Exec(`UPDATE channels SET image_providers = $2 WHERE id = $1`, channelID, order)
+	return err
+}
+
+// SetChannelImageCredit — добавлять ли подпись автора картинки к постам канала
+func SetChannelImageCredit(db *sql.DB, channelID int, on bool) error {
+	_, err := db.Exec(`UPDATE channels SET image_credit = $2 WHERE id = $1`, channelID, on)
+	return err
+}
+
+// SetChannelLLMModel — своя модель LLM канала (provider "" — общая цепочка провайдеров)
+func SetChannelLLMModel(db *sql.DB, channelID int, provider, model string) error {
+	_, err := db.Exec(`UPDATE channels SET llm_provider = $2, llm_model = $3 WHERE id = $1`, channelID, provider, model)
+	return err
+}
+
+// SetChannelParseMode — режим разметки, в котором публикуются посты канала
+func SetChannelParseMode(db *sql.DB, channelID int, mode string) error {
+	_, err := db.Exec(`UPDATE channels SET parse_mode = $2 WHERE id = $1`, channelID, mode)
+	return err
+}
@@ -2,23 +2,37 @@ package db
 
 import (
 	"database/sql"
+	"time"
 )
 
 type Client struct {
 	ID       int64
 	ChatID   int64
 	Username string
+	Timezone string
 	SQL      *sql.DB
 }
 
+// Часовой пояс по умолчанию (совпадает с DEFAULT в таблице clients)
+const DefaultTimezone = "Europe/Moscow"
+
+// Location клиента; если пояс не распознан — пояс по умолчанию
+func (c *Client) Location() *time.Location {
+	if loc, err := time.LoadLocation(c.Timezone); err == nil && c.Timezone != "" {
+		return loc
+	}
+	loc, _ := time.LoadLocation(DefaultTimezone)
+	return loc
+}
+
 func GetOrCreateClient(db *sql.DB, chatID int64, username string) (*Client, error) {
 	var c Client
 	err := db.QueryRow(`
 		INSERT INTO clients (chat_id, username)
 		VALUES ($1, $2)
 		ON CONFLICT (chat_id) DO UPDATE SET username = EXCLUDED.username
-		RETURNING id, chat_id, username
-	`, chatID, username).Scan(&c.ID, &c.ChatID, &c.Username)
+		RETURNING id, chat_id, username, timezone
+	`, chatID, username).Scan(&c.ID, &c.ChatID, &c.Username, &c.Timezone)
 	if err != nil {
 		return nil, err
 	}
@@ -36,12 +50,30 @@ func CreateClient(db *sql.DB, chatID int64, username string) error {
 func GetClientByID(db *sql.DB, id int) (*Client, error) {
 	var c Client
 	err := db.QueryRow(`
-		SELECT id, chat_id, username
+		SELECT id, chat_id, username, timezone
 		FROM clients
 		WHERE id = $1
-	`, id).Scan(&c.ID, &c.ChatID, &c.Username)
+	`, id).Scan(&c.ID, &c.ChatID, &c.Username, &c.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func GetClientByChatID(db *sql.DB, chatID int64) (*Client, error) {
+	var c Client
+	err := db.QueryRow(`
+		SELECT id, chat_id, username, timezone
+		FROM clients
+		WHERE chat_id = $1
+	`, chatID).Scan(&c.ID, &c.ChatID, &c.Username, &c.Timezone)
 	if err != nil {
 		return nil, err
 	}
 	return &c, nil
 }
+
+func SetClientTimezone(db *sql.DB, chatID int64, timezone string) error {
+	_, err := db.Exec(`UPDATE clients SET timezone = $2 WHERE chat_id = $1`, chatID, timezone)
+	return err
+}
@@ -0,0 +1,172 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// Баланс канала в нанотонах: платежи зачисляются, оплата периода списывается.
+// Неполные платежи копятся, переплата остаётся на следующий период.
+
+// Виды записей в журнале баланса
+const (
+	CreditPayment = "payment" // входящий платёж
+	CreditCharge  = "charge"  // списание за период подписки
+	CreditRefund  = "refund"  // возврат списания, если продлить не удалось
+)
+
+// CreditEntry — запись журнала баланса канала (AmountNano < 0 — списание)
+type CreditEntry struct {
+	ID         int64
+	ChannelID  int64
+	AmountNano *big.Int
+	Kind       string
+	PlanID     int
+	Ref        string // хэш транзакции для платежей
+	CreatedAt  time.Time
+}
+
+// TonPayment — входящий перевод на кошелёк бота
+type TonPayment struct {
+	Hash      string
+	Utime     int64
+	Value     string // как пришло из API
+	Source    string
+	Comment   string
+	InvoiceID int64 // 0 — перевод без счёта
+	PlanID    int
+}
+
+// CreditTonPayment атомарно фиксирует перевод в ton_payments и журнале платежей и зачисляет его на баланс канала.
+// false — перевод уже был обработан.
+func CreditTonPayment(db *sql.DB, p TonPayment, channelID int64, amountNano *big.Int) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`
+		INSERT INTO ton_payments (hash, utime, value, source, comment, invoice_id)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0)) ON CONFLICT DO NOTHING
+	`, p.Hash, p.Utime, p.Value, p.Source, p.Comment, p.InvoiceID)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO channel_credits (channel_id, amount_nano, kind, ref)
+		VALUES ($1, $2::numeric, $3, $4)
+	`, channelID, amountNano.String(), CreditPayment, p.Hash); err != nil {
+		return false, err
+	}
+	if _, err := insertPayment(tx, Payment{
+		Provider:   ProviderTON,
+		ExternalID: p.Hash,
+		ChannelID:  channelID,
+		PlanID:     p.PlanID,
+		Amount:     amountNano,
+		Currency:   "TON",
+		Payer:      p.Source,
+	}); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// GetChannelBalance — текущий баланс канала в нанотонах
+func GetChannelBalance(db *sql.DB, channelID int64) (*big.Int, error) {
+	var s string
+	if err := db.QueryRow(`
+		SELECT COALESCE(SUM(amount_nano), 0)::text FROM channel_credits WHERE channel_id = $1
+	`, channelID).Scan(&s); err != nil {
+		return nil, err
+	}
+	return parseNanoText(s)
+}
+
+// ChargeChannelCredit списывает amount за период тарифа, если баланса хватает.
+// Строка канала блокируется, чтобы параллельные зачисления не списали дважды.
+func ChargeChannelCredit(db *sql.DB, channelID int64, amountNano *big.Int, planID int) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT 1 FROM channels WHERE id = $1 FOR UPDATE`, channelID); err != nil {
+		return false, err
+	}
+	var s string
+	if err := tx.QueryRow(`
+		SELECT COALESCE(SUM(amount_nano), 0)::text FROM channel_credits WHERE channel_id = $1
+	`, channelID).Scan(&s); err != nil {
+		return false, err
+	}
+	balance, err := parseNanoText(s)
+	if err != nil {
+		return false, err
+	}
+	if balance.Cmp(amountNano) < 0 {
+		return false, nil
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO channel_credits (channel_id, amount_nano, kind, plan_id)
+		VALUES ($1, -($2::numeric), $3, NULLIF($4, 0))
+	`, channelID, amountNano.String(), CreditCharge, planID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// RefundChannelCredit возвращает списание на баланс
+func RefundChannelCredit(db *sql.DB, channelID int64, amountNano *big.Int, planID int) error {
+	_, err := db.Exec(`
+		INSERT INTO channel_credits (channel_id, amount_nano, kind, plan_id)
+		VALUES ($1, $2::numeric, $3, NULLIF($4, 0))
+	`, channelID, amountNano.String(), CreditRefund, planID)
+	return err
+}
+
+// GetChannelCredits — последние записи журнала баланса канала
+func GetChannelCredits(db *sql.DB, channelID int64, limit int) ([]CreditEntry, error) {
+	rows, err := db.Query(`
+		SELECT id, channel_id, amount_nano::text, kind, COALESCE(plan_id, 0), COALESCE(ref, ''), created_at
+		FROM channel_credits
+		WHERE channel_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, channelID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []CreditEntry
+	for rows.Next() {
+		var e CreditEntry
+		var amount string
+		if err := rows.Scan(&e.ID, &e.ChannelID, &amount, &e.Kind, &e.PlanID, &e.Ref, &e.CreatedAt); err != nil {
+			return nil, err
+		}
+		if e.AmountNano, err = parseNanoText(amount); err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func parseNanoText(s string) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok {
+		return nil, fmt.Errorf("некорректная сумма в нанотонах: %q", s)
+	}
+	return n, nil
+}
@@ -0,0 +1,53 @@
+package db
+
+import "database/sql"
+
+// ChannelImage — картинка, использованная в посте канала
+type ChannelImage struct {
+	ChannelID int
+	Provider  string
+	ImageID   string
+	URL       string
+	PageURL   string
+	Author    string
+	AuthorURL string
+	License   string
+}
+
+// RecordChannelImage — запомнить картинку опубликованного поста вместе с атрибуцией; возвращает id записи
+func RecordChannelImage(db *sql.DB, img ChannelImage) (int64, error) {
+	var id int64
+	err := db.QueryRow(`
+		INSERT INTO channel_images (channel_id, provider, image_id, url, page_url, author, author_url, license)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, img.ChannelID, img.Provider, img.ImageID, img.URL, img.PageURL, img.Author, img.AuthorURL, img.License).Scan(&id)
+	return id, err
+}
+
+// RecentChannelImages — картинки последних limit постов канала, новые первыми
+func RecentChannelImages(db *sql.DB, channelID, limit int) ([]ChannelImage, error) {
+	rows, err := db.Query(`
+		SELECT channel_id, provider, image_id, COALESCE(url, ''), COALESCE(page_url, ''), COALESCE(author, ''),
+			COALESCE(author_url, ''), COALESCE(license, '')
+		FROM channel_images
+		WHERE channel_id = $1
+		ORDER BY id DESC
+		LIMIT $2
+	`, channelID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ChannelImage
+	for rows.Next() {
+		var img ChannelImage
+		if err := rows.Scan(&img.ChannelID, &img.Provider, &img.ImageID, &img.URL, &img.PageURL, &img.Author,
+			&img.AuthorURL, &img.License); err != nil {
+			return nil, err
+		}
+		out = append(out, img)
+	}
+	return out, rows.Err()
+}
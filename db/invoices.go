@@ -0,0 +1,86 @@
+package db
+
+import (
+	"database/sql"
+	"math/big"
+	"time"
+)
+
+// Счета на оплату: бот выдаёт короткий код для комментария к переводу,
+// по нему воркер находит канал и тариф.
+
+// Статусы счёта (истёкший счёт остаётся open — срок проверяется при чтении)
+const (
+	InvoiceOpen = "open"
+	InvoicePaid = "paid"
+)
+
+type Invoice struct {
+	ID         int64
+	Code       string
+	ChannelID  int64
+	PlanID     int
+	AmountNano *big.Int
+	Status     string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+}
+
+func (inv Invoice) Expired(now time.Time) bool {
+	return now.After(inv.ExpiresAt)
+}
+
+const invoiceColumns = `id, code, channel_id, plan_id, amount_nano::text, status, created_at, expires_at`
+
+func scanInvoice(row rowScanner) (Invoice, error) {
+	var inv Invoice
+	var amount string
+	if err := row.Scan(&inv.ID, &inv.Code, &inv.ChannelID, &inv.PlanID, &amount, &inv.Status,
+		&inv.CreatedAt, &inv.ExpiresAt); err != nil {
+		return inv, err
+	}
+	var err error
+	inv.AmountNano, err = parseNanoText(amount)
+	return inv, err
+}
+
+// CreateInvoice сохраняет счёт. false — такой код уже занят (нужно сгенерировать другой).
+func CreateInvoice(db *sql.DB, inv *Invoice) (bool, error) {
+	err := db.QueryRow(`
+		INSERT INTO invoices (code, channel_id, plan_id, amount_nano, expires_at)
+		VALUES ($1, $2, $3, $4::numeric, $5)
+		ON CONFLICT (code) DO NOTHING
+		RETURNING id, status, created_at
+	`, inv.Code, inv.ChannelID, inv.PlanID, inv.AmountNano.String(), inv.ExpiresAt).
+		Scan(&inv.ID, &inv.Status, &inv.CreatedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetInvoiceByCode — счёт по коду из комментария (код хранится в верхнем регистре)
+func GetInvoiceByCode(db *sql.DB, code string) (Invoice, error) {
+	return scanInvoice(db.QueryRow(`SELECT `+invoiceColumns+` FROM invoices WHERE code = $1`, code))
+}
+
+// GetOpenInvoice — неоплаченный и не истёкший счёт канала на тариф (чтобы не плодить новые)
+func GetOpenInvoice(db *sql.DB, channelID int64, planID int) (Invoice, error) {
+	return scanInvoice(db.QueryRow(`SELECT `+invoiceColumns+` FROM invoices
+		WHERE channel_id = $1 AND plan_id = $2 AND status = $3 AND expires_at > NOW()
+		ORDER BY id DESC LIMIT 1`, channelID, planID, InvoiceOpen))
+}
+
+// MarkInvoicePaid закрывает счёт, когда по нему оплачен период
+func MarkInvoicePaid(db *sql.DB, id int64) error {
+	_, err := db.Exec(`UPDATE invoices SET status = $2, paid_at = NOW() WHERE id = $1 AND status = $3`,
+		id, InvoicePaid, InvoiceOpen)
+	return err
+}
+
+// SetInvoiceAmount — новая сумма к оплате по открытому счёту (после частичной оплаты)
+func SetInvoiceAmount(db *sql.DB, id int64, amountNano *big.Int) error {
+	_, err := db.Exec(`UPDATE invoices SET amount_nano = $2::numeric WHERE id = $1 AND status = $3`,
+		id, amountNano.String(), InvoiceOpen)
+	return err
+}
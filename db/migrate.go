@@ -30,7 +30,7 @@ func RunMigrations(db *sql.DB) {
 			id SERIAL PRIMARY KEY,
 			channel_id INTEGER REFERENCES channels(id) NOT NULL,
 			content TEXT,
-			post_at TIMESTAMP,
+			post_at TIMESTAMPTZ,
 			theme TEXT,
 			style TEXT,
 			language TEXT,
@@ -39,10 +39,131 @@ func RunMigrations(db *sql.DB) {
 			created_at TIMESTAMP DEFAULT NOW()
 		);`,
 
+		// Время поста — абсолютный момент. Старые значения записывались по часам сервера (UTC)
+		`DO $$
+		BEGIN
+			IF EXISTS (
+				SELECT 1 FROM information_schema.columns
+				WHERE table_name = 'scheduled_posts' AND column_name = 'post_at'
+				  AND data_type = 'timestamp without time zone'
+			) THEN
+				ALTER TABLE scheduled_posts ALTER COLUMN post_at TYPE TIMESTAMPTZ USING post_at AT TIME ZONE 'UTC';
+			END IF;
+		END $$;`,
+
+		// Часовой пояс клиента: в нём вводятся и показываются даты
+		`ALTER TABLE clients ADD COLUMN IF NOT EXISTS timezone TEXT NOT NULL DEFAULT 'Europe/Moscow';`,
+
+		// Режим разметки постов канала: HTML / MarkdownV2 / plain
+		`ALTER TABLE channels ADD COLUMN IF NOT EXISTS parse_mode TEXT NOT NULL DEFAULT 'HTML';`,
+
+		// Статусы публикации: pending → claimed → published / failed
+		`ALTER TABLE scheduled_posts ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'pending';`,
+		`ALTER TABLE scheduled_posts ADD COLUMN IF NOT EXISTS claimed_at TIMESTAMPTZ;`,
+		`ALTER TABLE scheduled_posts ADD COLUMN IF NOT EXISTS published_at TIMESTAMPTZ;`,
+		`CREATE INDEX IF NOT EXISTS scheduled_posts_status_post_at_idx ON scheduled_posts (status, post_at);`,
+
+		// Повторы с экспоненциальной задержкой
+		`ALTER TABLE scheduled_posts ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;`,
+		`ALTER TABLE scheduled_posts ADD COLUMN IF NOT EXISTS last_error TEXT;`,
+		`ALTER TABLE scheduled_posts ADD COLUMN IF NOT EXISTS next_attempt_at TIMESTAMPTZ;`,
+
+		// Текст поста теперь генерируется при планировании; раньше в content лежало описание параметров
+		`UPDATE scheduled_posts SET content = '' WHERE status = 'pending' AND content LIKE '📝 Тема:%';`,
+
+		// Регулярные расписания: дни недели + время в часовом поясе + пул тем
+		`CREATE TABLE IF NOT EXISTS recurring_schedules (
+			id SERIAL PRIMARY KEY,
+			channel_id INTEGER REFERENCES channels(id) ON DELETE CASCADE NOT NULL,
+			weekdays TEXT NOT NULL,
+			times TEXT NOT NULL,
+			timezone TEXT NOT NULL DEFAULT 'UTC',
+			themes TEXT NOT NULL,
+			style TEXT,
+			language TEXT,
+			length TEXT,
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			next_theme_idx INTEGER NOT NULL DEFAULT 0,
+			expanded_until TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			created_at TIMESTAMP DEFAULT NOW()
+		);`,
+		`ALTER TABLE scheduled_posts ADD COLUMN IF NOT EXISTS recurring_id INTEGER REFERENCES recurring_schedules(id) ON DELETE SET NULL;`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS scheduled_posts_recurring_post_at_idx ON scheduled_posts (recurring_id, post_at);`,
+
+		// Поисковый запрос для картинки из структурированного ответа модели
+		`ALTER TABLE scheduled_posts ADD COLUMN IF NOT EXISTS image_query TEXT NOT NULL DEFAULT '';`,
+
+		// Учёт вызовов LLM: токены, задержка и стоимость по каналам и клиентам
+		`CREATE TABLE IF NOT EXISTS llm_usage (
+			id BIGSERIAL PRIMARY KEY,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			provider TEXT NOT NULL,
+			model TEXT NOT NULL,
+			purpose TEXT NOT NULL,
+			prompt_tokens INTEGER NOT NULL DEFAULT 0,
+			completion_tokens INTEGER NOT NULL DEFAULT 0,
+			latency_ms INTEGER NOT NULL DEFAULT 0,
+			cost_usd NUMERIC(12, 6) NOT NULL DEFAULT 0,
+			success BOOLEAN NOT NULL DEFAULT TRUE,
+			error TEXT,
+			channel_id INTEGER REFERENCES channels(id) ON DELETE SET NULL,
+			client_id INTEGER REFERENCES clients(id) ON DELETE SET NULL
+		);`,
+		`CREATE INDEX IF NOT EXISTS llm_usage_channel_created_idx ON llm_usage (channel_id, created_at);`,
+
+		// Лимит генераций на период подписки: сбрасывается при продлении
+		`ALTER TABLE channels ADD COLUMN IF NOT EXISTS generation_quota INTEGER NOT NULL DEFAULT 100;`,
+		`ALTER TABLE channels ADD COLUMN IF NOT EXISTS generations_used INTEGER NOT NULL DEFAULT 0;`,
+
+		// Тарифы: цена, срок, лимиты и доступные функции. Канал помнит оплаченный тариф
+		`CREATE TABLE IF NOT EXISTS subscription_plans (
+			id SERIAL PRIMARY KEY,
+			code TEXT NOT NULL UNIQUE,
+			name TEXT NOT NULL,
+			price_ton NUMERIC(12,3) NOT NULL,
+			duration_days INTEGER NOT NULL DEFAULT 30,
+			generation_quota INTEGER NOT NULL DEFAULT 0,
+			max_scheduled_posts INTEGER NOT NULL DEFAULT 0,
+			allow_recurring BOOLEAN NOT NULL DEFAULT FALSE,
+			allow_custom_model BOOLEAN NOT NULL DEFAULT FALSE,
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			sort_order INTEGER NOT NULL DEFAULT 0
+		);`,
+		`INSERT INTO subscription_plans
+			(code, name, price_ton, duration_days, generation_quota, max_scheduled_posts, allow_recurring, allow_custom_model, sort_order)
+		VALUES
+			('basic', 'Базовый', 12, 30, 100, 30, TRUE, FALSE, 1),
+			('pro', 'Pro', 30, 30, 500, 0, TRUE, TRUE, 2)
+		ON CONFLICT (code) DO NOTHING;`,
+		`ALTER TABLE channels ADD COLUMN IF NOT EXISTS plan_id INTEGER REFERENCES subscription_plans(id) ON DELETE SET NULL;`,
+		// Уже оплаченные каналы — на базовом тарифе (он совпадает со старыми условиями)
+		`UPDATE channels SET plan_id = (SELECT id FROM subscription_plans WHERE code = 'basic')
+		WHERE plan_id IS NULL AND subscription_until IS NOT NULL;`,
+
+		// Баланс канала: журнал зачислений и списаний в нанотонах
+		`CREATE TABLE IF NOT EXISTS channel_credits (
+			id BIGSERIAL PRIMARY KEY,
+			channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+			amount_nano NUMERIC(30,0) NOT NULL,
+			kind TEXT NOT NULL,
+			plan_id INTEGER REFERENCES subscription_plans(id) ON DELETE SET NULL,
+			ref TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);`,
+		`CREATE INDEX IF NOT EXISTS channel_credits_channel_idx ON channel_credits (channel_id, id);`,
+
 		`CREATE TABLE IF NOT EXISTS ton_watcher_state (
 			wallet TEXT PRIMARY KEY,
 			last_utime BIGINT NOT NULL DEFAULT 0
 		);`,
+		// Курсор TON-воркера: логическое время + хэш последней обработанной транзакции
+		`ALTER TABLE ton_watcher_state ADD COLUMN IF NOT EXISTS last_lt BIGINT NOT NULL DEFAULT 0;`,
+		`ALTER TABLE ton_watcher_state ADD COLUMN IF NOT EXISTS last_hash TEXT NOT NULL DEFAULT '';`,
+		// Незакрытый пропуск в истории, если опрос не долистал до курсора
+		`ALTER TABLE ton_watcher_state ADD COLUMN IF NOT EXISTS resume_lt BIGINT NOT NULL DEFAULT 0;`,
+		`ALTER TABLE ton_watcher_state ADD COLUMN IF NOT EXISTS resume_hash TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE ton_watcher_state ADD COLUMN IF NOT EXISTS head_lt BIGINT NOT NULL DEFAULT 0;`,
+		`ALTER TABLE ton_watcher_state ADD COLUMN IF NOT EXISTS head_hash TEXT NOT NULL DEFAULT '';`,
 
 		`CREATE TABLE IF NOT EXISTS ton_payments(
 			hash TEXT PRIMARY KEY,
@@ -52,6 +173,98 @@ func RunMigrations(db *sql.DB) {
 			comment TEXT,
 			processed_at TIMESTAMPTZ DEFAULT NOW()
 		);`,
+
+		// Счета: короткий код для комментария к переводу вместо channel:@username
+		`CREATE TABLE IF NOT EXISTS invoices (
+			id BIGSERIAL PRIMARY KEY,
+			code TEXT NOT NULL UNIQUE,
+			channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+			plan_id INTEGER NOT NULL REFERENCES subscription_plans(id),
+			amount_nano NUMERIC(30,0) NOT NULL,
+			status TEXT NOT NULL DEFAULT 'open',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			expires_at TIMESTAMPTZ NOT NULL,
+			paid_at TIMESTAMPTZ
+		);`,
+		`CREATE INDEX IF NOT EXISTS invoices_channel_idx ON invoices (channel_id, status);`,
+		`ALTER TABLE ton_payments ADD COLUMN IF NOT EXISTS invoice_id BIGINT REFERENCES invoices(id) ON DELETE SET NULL;`,
+
+		// Оплата в Telegram Stars: цена тарифа в звёздах (0 — за звёзды не продаётся)
+		`ALTER TABLE subscription_plans ADD COLUMN IF NOT EXISTS price_stars INTEGER;`,
+		`UPDATE subscription_plans SET price_stars = CASE code WHEN 'basic' THEN 1000 WHEN 'pro' THEN 2500 ELSE 0 END
+		WHERE price_stars IS NULL;`,
+		// Единый журнал платежей всех провайдеров (TON, Stars)
+		`CREATE TABLE IF NOT EXISTS payments (
+			id BIGSERIAL PRIMARY KEY,
+			provider TEXT NOT NULL,
+			external_id TEXT NOT NULL,
+			channel_id INTEGER REFERENCES channels(id) ON DELETE SET NULL,
+			plan_id INTEGER REFERENCES subscription_plans(id) ON DELETE SET NULL,
+			amount NUMERIC(30,0) NOT NULL,
+			currency TEXT NOT NULL,
+			payer TEXT,
+			created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			applied_at TIMESTAMPTZ,
+			UNIQUE (provider, external_id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS payments_channel_idx ON payments (channel_id, created_at);`,
+		// TON-переводы, обработанные до появления журнала
+		`INSERT INTO payments (provider, external_id, channel_id, amount, currency, payer, created_at)
+		SELECT 'ton', p.hash, c.channel_id, p.value::numeric, 'TON', p.source, COALESCE(p.processed_at, NOW())
+		FROM ton_payments p
+		LEFT JOIN channel_credits c ON c.ref = p.hash AND c.kind = 'payment'
+		WHERE p.value ~ '^[0-9]+$'
+		ON CONFLICT (provider, external_id) DO NOTHING;`,
+
+		// Напоминания об окончании подписки: каждое отправляется один раз за период
+		`CREATE TABLE IF NOT EXISTS subscription_reminders (
+			channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+			kind TEXT NOT NULL,
+			period_end TIMESTAMP NOT NULL,
+			sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (channel_id, kind, period_end)
+		);`,
+
+		// Порядок источников картинок канала ("" — порядок по умолчанию)
+		`ALTER TABLE channels ADD COLUMN IF NOT EXISTS image_providers TEXT NOT NULL DEFAULT '';`,
+		// История картинок канала: недавние не повторяем, автор сохраняется для атрибуции
+		`CREATE TABLE IF NOT EXISTS channel_images (
+			id BIGSERIAL PRIMARY KEY,
+			channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+			provider TEXT NOT NULL,
+			image_id TEXT NOT NULL,
+			url TEXT,
+			page_url TEXT,
+			author TEXT,
+			used_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		);`,
+		`CREATE INDEX IF NOT EXISTS channel_images_channel_idx ON channel_images (channel_id, id);`,
+
+		// Атрибуция картинок: профиль автора и лицензия, подпись «📷 Автор / Сток» по желанию канала,
+		// у опубликованного поста — ссылка на его картинку
+		`ALTER TABLE channel_images ADD COLUMN IF NOT EXISTS author_url TEXT;`,
+		`ALTER TABLE channel_images ADD COLUMN IF NOT EXISTS license TEXT;`,
+		`ALTER TABLE channels ADD COLUMN IF NOT EXISTS image_credit BOOLEAN NOT NULL DEFAULT FALSE;`,
+		`ALTER TABLE scheduled_posts ADD COLUMN IF NOT EXISTS channel_image_id BIGINT REFERENCES channel_images(id) ON DELETE SET NULL;`,
+
+		// Токен захвата: завершить или вернуть пост может только тот, кто его взял последним
+		`ALTER TABLE scheduled_posts ADD COLUMN IF NOT EXISTS claim_token TEXT;`,
+
+		// Посты из регулярных расписаний тоже проходят одобрение: ещё не сгенерированные — в черновики
+		`UPDATE scheduled_posts SET status = 'draft' WHERE recurring_id IS NOT NULL AND status = 'pending' AND content = '';`,
+
+		// Уведомления владельцу по конкретному посту (например, «лимит исчерпан») — одно на вид,
+		// сколько бы раз публикатор ни повторял попытку
+		`CREATE TABLE IF NOT EXISTS post_notices (
+			post_id INTEGER NOT NULL REFERENCES scheduled_posts(id) ON DELETE CASCADE,
+			kind TEXT NOT NULL,
+			sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+			PRIMARY KEY (post_id, kind)
+		);`,
+
+		// Своя модель LLM канала (только в тарифах с allow_custom_model): провайдер из цепочки и модель
+		`ALTER TABLE channels ADD COLUMN IF NOT EXISTS llm_provider TEXT NOT NULL DEFAULT '';`,
+		`ALTER TABLE channels ADD COLUMN IF NOT EXISTS llm_model TEXT NOT NULL DEFAULT '';`,
 	}
 
 	for i, q := range queries {
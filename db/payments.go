@@ -0,0 +1,72 @@
+package db
+
+import (
+	"database/sql"
+	"math/big"
+	"time"
+)
+
+// Единый журнал платежей: TON-переводы и Telegram Stars в одной таблице,
+// повтор (provider, external_id) не записывается.
+
+// Провайдеры платежей
+const (
+	ProviderTON   = "ton"
+	ProviderStars = "stars"
+)
+
+// Payment — запись журнала платежей
+type Payment struct {
+	ID         int64
+	Provider   string
+	ExternalID string // хэш транзакции / telegram_payment_charge_id
+	ChannelID  int64
+	PlanID     int
+	Amount     *big.Int // в минимальных единицах валюты: нанотоны, звёзды
+	Currency   string   // TON / XTR
+	Payer      string   // адрес кошелька / Telegram user id
+	CreatedAt  time.Time
+}
+
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+func insertPayment(e execer, p Payment) (bool, error) {
+	res, err := e.Exec(`
+		INSERT INTO payments (provider, external_id, channel_id, plan_id, amount, currency, payer)
+		VALUES ($1, $2, NULLIF($3, 0), NULLIF($4, 0), $5::numeric, $6, $7)
+		ON CONFLICT (provider, external_id) DO NOTHING
+	`, p.Provider, p.ExternalID, p.ChannelID, p.PlanID, p.Amount.String(), p.Currency, p.Payer)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// RecordPayment записывает платёж в журнал. false — такой платёж уже был.
+func RecordPayment(db *sql.DB, p Payment) (bool, error) {
+	return insertPayment(db, p)
+}
+
+// ClaimPaymentApply берёт записанный платёж в применение (продление подписки).
+// false — платёж уже применён или его прямо сейчас применяет повторная доставка.
+func ClaimPaymentApply(db *sql.DB, provider, externalID string) (bool, error) {
+	res, err := db.Exec(`
+		UPDATE payments SET applied_at = NOW()
+		WHERE provider = $1 AND external_id = $2 AND applied_at IS NULL
+	`, provider, externalID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ReleasePaymentApply — применить платёж не удалось: повторная доставка применит его снова
+func ReleasePaymentApply(db *sql.DB, provider, externalID string) error {
+	_, err := db.Exec(`UPDATE payments SET applied_at = NULL WHERE provider = $1 AND external_id = $2`,
+		provider, externalID)
+	return err
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// Plan — тариф подписки. Цена хранится десятичной строкой, как её пишут в кошельке ("12.5")
+type Plan struct {
+	ID                int
+	Code              string // короткий код для комментария к платежу: plan:pro
+	Name              string
+	PriceTON          string
+	DurationDays      int
+	GenerationQuota   int // генераций на период, 0 — без лимита
+	MaxScheduledPosts int // постов в очереди одновременно, 0 — без лимита
+	AllowRecurring    bool
+	AllowCustomModel  bool // канал может выбрать свою модель LLM
+	PriceStars        int  // цена в Telegram Stars, 0 — за звёзды не продаётся
+}
+
+const planColumns = `id, code, name, price_ton::text, duration_days, generation_quota,
+	max_scheduled_posts, allow_recurring, allow_custom_model, COALESCE(price_stars, 0)`
+
+func scanPlan(row rowScanner) (Plan, error) {
+	var p Plan
+	err := row.Scan(&p.ID, &p.Code, &p.Name, &p.PriceTON, &p.DurationDays, &p.GenerationQuota,
+		&p.MaxScheduledPosts, &p.AllowRecurring, &p.AllowCustomModel, &p.PriceStars)
+	if strings.Contains(p.PriceTON, ".") {
+		// NUMERIC(12,3) отдаёт "12.000" — показываем "12"
+		p.PriceTON = strings.TrimSuffix(strings.TrimRight(p.PriceTON, "0"), ".")
+	}
+	return p, err
+}
+
+// GetActivePlans — тарифы, которые предлагаем при оплате, в порядке показа
+func GetActivePlans(db *sql.DB) ([]Plan, error) {
+	rows, err := db.Query(`SELECT ` + planColumns + ` FROM subscription_plans
+		WHERE is_active ORDER BY sort_order, price_ton`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var plans []Plan
+	for rows.Next() {
+		p, err := scanPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, p)
+	}
+	return plans, rows.Err()
+}
+
+// GetPlanByCode — активный тариф по коду (регистр не важен)
+func GetPlanByCode(db *sql.DB, code string) (Plan, error) {
+	return scanPlan(db.QueryRow(`SELECT `+planColumns+` FROM subscription_plans
+		WHERE is_active AND lower(code) = lower($1)`, strings.TrimSpace(code)))
+}
+
+// GetPlanByID — тариф канала (в том числе снятый с продажи)
+func GetPlanByID(db *sql.DB, id int) (Plan, error) {
+	return scanPlan(db.QueryRow(`SELECT `+planColumns+` FROM subscription_plans WHERE id = $1`, id))
+}
+
+// GetDefaultPlan — первый активный тариф: для платежей без plan:… и каналов без тарифа
+func GetDefaultPlan(db *sql.DB) (Plan, error) {
+	return scanPlan(db.QueryRow(`SELECT ` + planColumns + ` FROM subscription_plans
+		WHERE is_active ORDER BY sort_order, price_ton LIMIT 1`))
+}
+
+// CountUpcomingPosts — сколько постов канала ждут публикации (для лимита тарифа).
+// Черновики с прошедшим временем уже не выйдут, их не считаем.
+func CountUpcomingPosts(db *sql.DB, channelID int64) (int, error) {
+	return countUpcomingPosts(db, channelID)
+}
+
+type queryRower interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func countUpcomingPosts(q queryRower, channelID int64) (int, error) {
+	var n int
+	err := q.QueryRow(`
+		SELECT COUNT(*) FROM scheduled_posts
+		WHERE channel_id = $1
+		  AND (status IN ('pending', 'claimed') OR (status = 'draft' AND post_at >= NOW()))
+	`, channelID).Scan(&n)
+	return n, err
+}
@@ -0,0 +1,368 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RecurringRule — регулярное расписание канала: дни недели + время в часовом поясе + пул тем
+type RecurringRule struct {
+	ID            int64
+	ChannelID     int64
+	Weekdays      []int    // 1 = пн … 7 = вс
+	Times         []string // "15:04"
+	Timezone      string
+	Themes        []string // темы чередуются по кругу
+	Style         string
+	Language      string
+	Length        string
+	IsActive      bool
+	NextThemeIdx  int
+	ExpandedUntil time.Time
+	CreatedAt     time.Time
+}
+
+// Location часового пояса правила (UTC, если пояс неизвестен)
+func (r RecurringRule) Location() *time.Location {
+	loc, err := time.LoadLocation(r.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// Occurrences — моменты публикации в интервале (from, to]
+func (r RecurringRule) Occurrences(from, to time.Time) []time.Time {
+	loc := r.Location()
+	start := from.In(loc)
+
+	days := map[int]bool{}
+	for _, d := range r.Weekdays {
+		days[d] = true
+	}
+
+	var out []time.Time
+	for d := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, loc); !d.After(to); d = d.AddDate(0, 0, 1) {
+		wd := int(d.Weekday())
+		if wd == 0 {
+			wd = 7
+		}
+		if !days[wd] {
+			continue
+		}
+		for _, hm := range r.Times {
+			clock, err := time.Parse("15:04", hm)
+			if err != nil {
+				continue
+			}
+			t := time.Date(d.Year(), d.Month(), d.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+			if t.After(from) && !t.After(to) {
+				out = append(out, t)
+			}
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Before(out[j]) })
+	return out
+}
+
+func joinInts(xs []int) string {
+	parts := make([]string, len(xs))
+	for i, x := range xs {
+		parts[i] = strconv.Itoa(x)
+	}
+	return strings.Join(parts, ",")
+}
+
+func splitInts(s string) []int {
+	var out []int
+	for _, p := range strings.Split(s, ",") {
+		if x, err := strconv.Atoi(strings.TrimSpace(p)); err == nil {
+			out = append(out, x)
+		}
+	}
+	return out
+}
+
+func splitNonEmpty(s, sep string) []string {
+	var out []string
+	for _, p := range strings.Split(s, sep) {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+const recurringColumns = `id, channel_id, weekdays, times, timezone, themes,
+	COALESCE(style, ''), COALESCE(language, ''), COALESCE(length, ''),
+	is_active, next_theme_idx, expanded_until, created_at`
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanRecurringRule(row rowScanner) (RecurringRule, error) {
+	var r RecurringRule
+	var weekdays, times, themes string
+	err := row.Scan(&r.ID, &r.ChannelID, &weekdays, &times, &r.Timezone, &themes,
+		&r.Style, &r.Language, &r.Length,
+		&r.IsActive, &r.NextThemeIdx, &r.ExpandedUntil, &r.CreatedAt)
+	if err != nil {
+		return r, err
+	}
+	r.Weekdays = splitInts(weekdays)
+	r.Times = splitNonEmpty(times, ",")
+	r.Themes = splitNonEmpty(themes, "\n")
+	return r, nil
+}
+
+func CreateRecurringRule(db *sql.DB, r *RecurringRule) (int64, error) {
+	if len(r.Weekdays) == 0 || len(r.Times) == 0 || len(r.Themes) == 0 {
+		return 0, fmt.Errorf("пустое правило: нужны дни, время и хотя бы одна тема")
+	}
+	var id int64
+	err := db.QueryRow(`
+		INSERT INTO recurring_schedules (channel_id, weekdays, times, timezone, themes, style, language, length)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		RETURNING id
+	`, r.ChannelID, joinInts(r.Weekdays), strings.Join(r.Times, ","), r.Timezone,
+		strings.Join(r.Themes, "\n"), r.Style, r.Language, r.Length).Scan(&id)
+	return id, err
+}
+
+func GetRecurringRulesByChannelID(db *sql.DB, channelID int64) ([]RecurringRule, error) {
+	rows, err := db.Query(`SELECT `+recurringColumns+` FROM recurring_schedules WHERE channel_id = $1 ORDER BY id`, channelID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []RecurringRule
+	for rows.Next() {
+		r, err := scanRecurringRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+// SetRecurringRuleActive ставит правило на паузу или включает его.
+// На паузе убираем уже развёрнутые, но не опубликованные посты; при включении разворачиваем заново от текущего момента.
+func SetRecurringRuleActive(db *sql.DB, ruleID int64, active bool) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if !active {
+		if _, err := tx.Exec(`DELETE FROM scheduled_posts WHERE recurring_id = $1 AND status IN ('draft', 'pending')`, ruleID); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(`
+		UPDATE recurring_schedules
+		SET is_active = $2, expanded_until = NOW()
+		WHERE id = $1
+	`, ruleID, active); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteRecurringRule удаляет правило и его ещё не опубликованные посты (история остаётся)
+func DeleteRecurringRule(db *sql.DB, ruleID int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM scheduled_posts WHERE recurring_id = $1 AND status IN ('draft', 'pending')`, ruleID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM recurring_schedules WHERE id = $1`, ruleID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ExpandRecurringRules разворачивает активные правила в конкретные scheduled_posts до now+horizon.
+// Тариф канала проверяется при каждом развёртывании: правила, которые он больше не включает,
+// ставятся на паузу, а новых постов создаётся не больше лимита очереди. Возвращает, сколько постов создано.
+func ExpandRecurringRules(db *sql.DB, now time.Time, horizon time.Duration) (int, error) {
+	until := now.Add(horizon)
+	// канал без тарифа живёт по тарифу по умолчанию (как GetDefaultPlan)
+	rows, err := db.Query(`
+		SELECT r.id, COALESCE(p.allow_recurring, TRUE), COALESCE(p.max_scheduled_posts, 0)
+		FROM recurring_schedules r
+		JOIN channels c ON c.id = r.channel_id
+		LEFT JOIN subscription_plans p ON p.id = COALESCE(c.plan_id, (
+			SELECT id FROM subscription_plans WHERE is_active ORDER BY sort_order, price_ton LIMIT 1))
+		WHERE r.is_active
+		  AND r.expanded_until < $1
+		  AND c.subscription_until > $2
+	`, until, now)
+	if err != nil {
+		return 0, err
+	}
+	type ruleLimits struct {
+		id       int64
+		allowed  bool
+		maxPosts int
+	}
+	var rules []ruleLimits
+	for rows.Next() {
+		var r ruleLimits
+		if err := rows.Scan(&r.id, &r.allowed, &r.maxPosts); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		rules = append(rules, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, r := range rules {
+		if !r.allowed {
+			// тариф понизили — регулярные посты больше не входят
+			if err := SetRecurringRuleActive(db, r.id, false); err != nil {
+				return total, fmt.Errorf("правило #%d: %w", r.id, err)
+			}
+			log.Printf("⏸ Правило #%d поставлено на паузу: тариф канала не включает регулярные посты", r.id)
+			continue
+		}
+		n, err := expandRecurringRule(db, r.id, now, until, r.maxPosts)
+		if err != nil {
+			return total, fmt.Errorf("правило #%d: %w", r.id, err)
+		}
+		total += n
+	}
+	return total, nil
+}
+
+// maxPosts — лимит очереди канала по тарифу (0 — без лимита)
+func expandRecurringRule(db *sql.DB, ruleID int64, now, until time.Time, maxPosts int) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// SKIP LOCKED: правило разворачивает кто-то другой — пропускаем
+	r, err := scanRecurringRule(tx.QueryRow(`
+		SELECT `+recurringColumns+`
+		FROM recurring_schedules
+		WHERE id = $1 AND is_active
+		FOR UPDATE SKIP LOCKED
+	`, ruleID))
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if len(r.Themes) == 0 {
+		return 0, nil
+	}
+
+	from := r.ExpandedUntil
+	if from.Before(now) {
+		from = now
+	}
+
+	queued := 0
+	if maxPosts > 0 {
+		if queued, err = countUpcomingPosts(tx, r.ChannelID); err != nil {
+			return 0, err
+		}
+	}
+
+	created := 0
+	idx := r.NextThemeIdx
+	expanded := until
+	for _, at := range r.Occurrences(from, until) {
+		if maxPosts > 0 && queued >= maxPosts {
+			// очередь полна: остальное развернём, когда в ней освободится место
+			expanded = from
+			break
+		}
+		theme := r.Themes[idx%len(r.Themes)]
+		// Черновик без текста: текст сгенерируется незадолго до публикации и уйдёт владельцу на одобрение (RecurringDraftsToPrepare)
+		res, err := tx.Exec(`
+			INSERT INTO scheduled_posts (channel_id, content, post_at, theme, style, language, length, photo, status, recurring_id)
+			VALUES ($1, '', $2, $3, $4, $5, $6, '', 'draft', $7)
+			ON CONFLICT (recurring_id, post_at) DO NOTHING
+		`, r.ChannelID, at, theme, r.Style, r.Language, r.Length, r.ID)
+		if err != nil {
+			return 0, err
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			created++
+			queued++
+			idx++
+		}
+		from = at
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE recurring_schedules
+		SET expanded_until = $2, next_theme_idx = $3
+		WHERE id = $1
+	`, r.ID, expanded, idx%len(r.Themes)); err != nil {
+		return 0, err
+	}
+	return created, tx.Commit()
+}
+
+// RecurringDraftsToPrepare — черновики из регулярных расписаний, для которых ещё нет текста,
+// с публикацией до until (ближайшие первыми). Прошедшие не берём: без одобрения они не выйдут.
+func RecurringDraftsToPrepare(db *sql.DB, now, until time.Time, limit int) ([]ScheduledPost, error) {
+	rows, err := db.Query(`
+		SELECT id, channel_id, post_at, theme, style, language, length
+		FROM scheduled_posts
+		WHERE recurring_id IS NOT NULL AND status = 'draft' AND content = ''
+		  AND post_at > $1 AND post_at <= $2
+		ORDER BY post_at
+		LIMIT $3
+	`, now, until, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []ScheduledPost
+	for rows.Next() {
+		post := ScheduledPost{Status: PostStatusDraft}
+		if err := rows.Scan(&post.ID, &post.ChannelID, &post.PostAt, &post.Theme, &post.Style, &post.Language, &post.Length); err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+// FillRecurringDraft сохраняет сгенерированный текст черновика. false — текст уже есть
+// (его подготовил другой экземпляр) или черновик удалён: предпросмотр второй раз не шлём.
+func FillRecurringDraft(db *sql.DB, postID int64, content, imageQuery string) (bool, error) {
+	res, err := db.Exec(`
+		UPDATE scheduled_posts
+		SET content = $2, image_query = $3
+		WHERE id = $1 AND status = 'draft' AND content = ''
+	`, postID, content, imageQuery)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
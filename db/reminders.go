@@ -0,0 +1,71 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// ExpiringChannel — канал, у которого подписка скоро кончится или недавно кончилась
+type ExpiringChannel struct {
+	ChannelID         int
+	ChannelTitle      string
+	ChatID            int64 // чат владельца
+	SubscriptionUntil time.Time
+}
+
+// GetExpiringChannels — каналы с subscription_until в окне [NOW()-since, NOW()+ahead]
+func GetExpiringChannels(db *sql.DB, since, ahead time.Duration) ([]ExpiringChannel, error) {
+	rows, err := db.Query(`
+		SELECT c.id, c.channel_title, cl.chat_id, c.subscription_until
+		FROM channels c
+		JOIN clients cl ON cl.id = c.client_id
+		WHERE c.subscription_until IS NOT NULL
+		  AND c.subscription_until > NOW() - make_interval(secs => $1)
+		  AND c.subscription_until <= NOW() + make_interval(secs => $2)
+		ORDER BY c.subscription_until
+	`, since.Seconds(), ahead.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ExpiringChannel
+	for rows.Next() {
+		var c ExpiringChannel
+		if err := rows.Scan(&c.ChannelID, &c.ChannelTitle, &c.ChatID, &c.SubscriptionUntil); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// MarkReminderSent фиксирует напоминание kind за текущий период канала.
+// false — такое напоминание уже отправлялось (или подписку успели продлить).
+func MarkReminderSent(db *sql.DB, channelID int, kind string, periodEnd time.Time) (bool, error) {
+	res, err := db.Exec(`
+		INSERT INTO subscription_reminders (channel_id, kind, period_end)
+		SELECT id, $2, subscription_until FROM channels
+		WHERE id = $1 AND subscription_until = $3
+		ON CONFLICT DO NOTHING
+	`, channelID, kind, periodEnd)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
+
+// MarkPostNoticeSent фиксирует уведомление kind по посту. false — такое уже отправлялось.
+func MarkPostNoticeSent(db *sql.DB, postID int64, kind string) (bool, error) {
+	res, err := db.Exec(`
+		INSERT INTO post_notices (post_id, kind)
+		VALUES ($1, $2)
+		ON CONFLICT DO NOTHING
+	`, postID, kind)
+	if err != nil {
+		return false, err
+	}
+	n, _ := res.RowsAffected()
+	return n > 0, nil
+}
@@ -1,24 +1,43 @@
 package db
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"time"
+
+	"github.com/lib/pq"
 )
 
 type ScheduledPost struct {
-	ID        int64
-	ChannelID int64
-	Content   string
-	PostAt    time.Time
-	Theme     string
-	Style     string
-	Language  string
-	Length    string
-	Photo     string // 👈 ДОБАВЬ ЭТО
-	CreatedAt time.Time
+	ID         int64
+	ChannelID  int64
+	Content    string
+	PostAt     time.Time
+	Theme      string
+	Style      string
+	Language   string
+	Length     string
+	Photo      string // 👈 ДОБАВЬ ЭТО
+	CreatedAt  time.Time
+	Status     string
+	Attempts   int
+	LastError  string
+	ImageQuery string // запрос для стоков картинок; пусто — переводим тему
+	ClaimToken string // токен захвата (только у постов из ClaimDueScheduledPosts)
 }
 
+// Статусы запланированного поста
+const (
+	PostStatusDraft     = "draft"     // текст сгенерирован, ждёт одобрения владельца
+	PostStatusPending   = "pending"   // ждёт своего времени
+	PostStatusClaimed   = "claimed"   // взят публикатором (аренда)
+	PostStatusPublished = "published" // опубликован, храним как историю
+	PostStatusFailed    = "failed"    // публикация невозможна
+)
+
 func CreateScheduledPost(db *sql.DB, chatID int64, content string, postAt time.Time) error {
 	query := `INSERT INTO scheduled_posts (chat_id, content, post_at) VALUES ($1, $2, $3)`
 	_, err := db.Exec(query, chatID, content, postAt)
@@ -49,11 +68,14 @@ func DeleteScheduledPostByID(db *sql.DB, postID int64) error {
 	_, err := db.Exec(query, postID)
 	return err
 }
+
+// GetScheduledPostsByChannelID — очередь канала; черновики, время которых прошло без одобрения, не показываем
 func GetScheduledPostsByChannelID(db *sql.DB, channelID int64) ([]ScheduledPost, error) {
 	rows, err := db.Query(`
-		SELECT id, channel_id, content, post_at, theme, style, language, length, photo, created_at
+		SELECT id, channel_id, content, post_at, theme, style, language, length, photo, created_at, status
 		FROM scheduled_posts
 		WHERE channel_id = $1
+		  AND (status IN ('pending', 'claimed') OR (status = 'draft' AND post_at >= NOW()))
 		ORDER BY post_at ASC
 	`, channelID)
 	if err != nil {
@@ -65,7 +87,7 @@ func GetScheduledPostsByChannelID(db *sql.DB, channelID int64) ([]ScheduledPost,
 	for rows.Next() {
 		var post ScheduledPost
 		err := rows.Scan(&post.ID, &post.ChannelID, &post.Content, &post.PostAt,
-			&post.Theme, &post.Style, &post.Language, &post.Length, &post.Photo, &post.CreatedAt)
+			&post.Theme, &post.Style, &post.Language, &post.Length, &post.Photo, &post.CreatedAt, &post.Status)
 		if err != nil {
 			return nil, err
 		}
@@ -96,6 +118,8 @@ func GetScheduledPostsByTime(db *sql.DB, target time.Time) ([]ScheduledPost, err
 	}
 	return posts, nil
 }
+
+// SaveScheduledPostFull сохраняет пост черновиком (draft) — публикуется только после одобрения
 func SaveScheduledPostFull(
 	db *sql.DB,
 	channelID int64,
@@ -106,7 +130,8 @@ func SaveScheduledPostFull(
 	language string,
 	length string,
 	photo string,
-) error {
+	imageQuery string,
+) (int64, error) {
 	query := `
 		INSERT INTO scheduled_posts (
 			channel_id,
@@ -116,12 +141,16 @@ func SaveScheduledPostFull(
 			style,
 			language,
 			length,
-			photo
-		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			photo,
+			image_query,
+			status
+		) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, 'draft')
+		RETURNING id
 	`
 
-	_, err := db.Exec(query, channelID, content, postAt, theme, style, language, length, photo)
-	return err
+	var id int64
+	err := db.QueryRow(query, channelID, content, postAt, theme, style, language, length, photo, imageQuery).Scan(&id)
+	return id, err
 }
 func UpdatePostField(db *sql.DB, postID int64, field string, value any) error {
 	query := fmt.Sprintf("UPDATE scheduled_posts SET %s = $1 WHERE id = $2", field)
@@ -131,7 +160,7 @@ func UpdatePostField(db *sql.DB, postID int64, field string, value any) error {
 func GetScheduledPostByID(db *sql.DB, postID int64) (ScheduledPost, error) {
 	var post ScheduledPost
 	err := db.QueryRow(`
-		SELECT id, channel_id, content, post_at, theme, style, language, length, photo, created_at
+		SELECT id, channel_id, content, post_at, theme, style, language, length, photo, created_at, status
 		FROM scheduled_posts
 		WHERE id = $1
 	`, postID).Scan(
@@ -145,6 +174,7 @@ func GetScheduledPostByID(db *sql.DB, postID int64) (ScheduledPost, error) {
 		&post.Length,
 		&post.Photo,
 		&post.CreatedAt,
+		&post.Status,
 	)
 	return post, err
 }
@@ -177,3 +207,172 @@ func GetScheduledPostsByChannel(db *sql.DB, channelID string) ([]ScheduledPost,
 	}
 	return posts, nil
 }
+
+// ErrPostNotEditable — пост уже взят публикатором, опубликован или удалён: править его поздно
+var ErrPostNotEditable = errors.New("пост уже публикуется или опубликован")
+
+// SetScheduledPostDraft сохраняет новый текст и возвращает пост в черновики (нужно повторное одобрение).
+// Пустой imageQuery оставляет прежний запрос картинки (например, при ручной правке текста).
+// Если пост уже не черновик и не в очереди — ErrPostNotEditable.
+func SetScheduledPostDraft(db *sql.DB, postID int64, content string, imageQuery string) error {
+	res, err := db.Exec(`
+		UPDATE scheduled_posts
+		SET content = $2, status = 'draft', image_query = COALESCE(NULLIF($3, ''), image_query)
+		WHERE id = $1 AND status IN ('draft', 'pending')
+	`, postID, content, imageQuery)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return ErrPostNotEditable
+	}
+	return nil
+}
+
+// ApproveScheduledPost переводит черновик в очередь публикации.
+// false — черновик уже одобрен или его время прошло (такой черновик не публикуем).
+func ApproveScheduledPost(db *sql.DB, postID int64) (bool, error) {
+	res, err := db.Exec(`
+		UPDATE scheduled_posts
+		SET status = 'pending'
+		WHERE id = $1 AND status = 'draft' AND post_at > NOW()
+	`, postID)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// ExpireUnapprovedDrafts закрывает черновики, время которых прошло, а одобрения так и не было:
+// публиковать их поздно, а в очереди они занимали бы место по лимиту тарифа
+func ExpireUnapprovedDrafts(db *sql.DB, now time.Time) (int, error) {
+	res, err := db.Exec(`
+		UPDATE scheduled_posts
+		SET status = 'failed', last_error = 'не одобрен'
+		WHERE status = 'draft' AND post_at < $1
+	`, now)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
+
+// ClaimDueScheduledPosts атомарно забирает наступившие посты в работу.
+// Берём pending-посты и "зависшие" claimed, у которых истекла аренда lease.
+// SKIP LOCKED не даёт двум экземплярам бота взять одну и ту же строку.
+// Каждый захват получает новый ClaimToken: после перехвата поста по истёкшей аренде
+// прежний владелец уже не сможет его завершить или вернуть в очередь.
+// skip — посты, которые не брать (уже возвращённые в очередь на этом проходе).
+func ClaimDueScheduledPosts(db *sql.DB, now time.Time, lease time.Duration, limit int, skip []int64) ([]ScheduledPost, error) {
+	token, err := newClaimToken()
+	if err != nil {
+		return nil, err
+	}
+	if skip == nil {
+		skip = []int64{} // NULL в ANY отфильтровал бы все строки
+	}
+	rows, err := db.Query(`
+		UPDATE scheduled_posts
+		SET status = 'claimed', claimed_at = $1, claim_token = $4
+		WHERE id IN (
+			SELECT id
+			FROM scheduled_posts
+			WHERE post_at <= $1
+			  AND (status = 'pending' OR (status = 'claimed' AND claimed_at < $2))
+			  AND (next_attempt_at IS NULL OR next_attempt_at <= $1)
+			  AND NOT (id = ANY($5))
+			ORDER BY post_at
+			LIMIT $3
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, channel_id, content, post_at, theme, style, language, length, photo, created_at, status,
+			attempts, COALESCE(last_error, ''), image_query, claim_token
+	`, now, now.Add(-lease), limit, token, pq.Array(skip))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var posts []ScheduledPost
+	for rows.Next() {
+		var post ScheduledPost
+		err := rows.Scan(&post.ID, &post.ChannelID, &post.Content, &post.PostAt,
+			&post.Theme, &post.Style, &post.Language, &post.Length, &post.Photo, &post.CreatedAt, &post.Status,
+			&post.Attempts, &post.LastError, &post.ImageQuery, &post.ClaimToken)
+		if err != nil {
+			return nil, err
+		}
+		posts = append(posts, post)
+	}
+	return posts, rows.Err()
+}
+
+func newClaimToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// Функции ниже завершают захват: claim — ClaimToken поста. Если пост уже перехватили
+// (аренда истекла и его взял другой экземпляр), строка не меняется.
+
+// MarkScheduledPostPublished переводит взятый пост в published (строка остаётся как история).
+// imageID — запись channel_images с атрибуцией картинки, 0 — картинки со стока не было.
+func MarkScheduledPostPublished(db *sql.DB, postID int64, claim string, imageID int64) error {
+	_, err := db.Exec(`
+		UPDATE scheduled_posts
+		SET status = 'published', published_at = NOW(), channel_image_id = NULLIF($3, 0), claim_token = NULL
+		WHERE id = $1 AND status = 'claimed' AND claim_token = $2
+	`, postID, claim, imageID)
+	return err
+}
+
+// ReleaseScheduledPost возвращает взятый пост в очередь (попробуем на следующем тике)
+func ReleaseScheduledPost(db *sql.DB, postID int64, claim string) error {
+	_, err := db.Exec(`
+		UPDATE scheduled_posts
+		SET status = 'pending', claimed_at = NULL, claim_token = NULL
+		WHERE id = $1 AND status = 'claimed' AND claim_token = $2
+	`, postID, claim)
+	return err
+}
+
+// RetryScheduledPostLater — неудачная попытка: увеличиваем счётчик и откладываем до nextAttempt
+func RetryScheduledPostLater(db *sql.DB, postID int64, claim string, lastError string, nextAttempt time.Time) error {
+	_, err := db.Exec(`
+		UPDATE scheduled_posts
+		SET status = 'pending',
+		    claimed_at = NULL,
+		    claim_token = NULL,
+		    attempts = attempts + 1,
+		    last_error = $3,
+		    next_attempt_at = $4
+		WHERE id = $1 AND status = 'claimed' AND claim_token = $2
+	`, postID, claim, lastError, nextAttempt)
+	return err
+}
+
+// MarkScheduledPostFailed — публиковать пост больше не пытаемся.
+// Возвращает false, если пост уже не наш (например, его перехватил другой экземпляр).
+func MarkScheduledPostFailed(db *sql.DB, postID int64, claim string, lastError string) (bool, error) {
+	res, err := db.Exec(`
+		UPDATE scheduled_posts
+		SET status = 'failed',
+		    claimed_at = NULL,
+		    claim_token = NULL,
+		    attempts = attempts + 1,
+		    last_error = $3
+		WHERE id = $1 AND status = 'claimed' AND claim_token = $2
+	`, postID, claim, lastError)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
@@ -2,9 +2,33 @@
 id SERIAL PRIMARY KEY,
 chat_id BIGINT UNIQUE NOT NULL,
 username TEXT DEFAULT '',
-created_at TIMESTAMP DEFAULT NOW()
+created_at TIMESTAMP DEFAULT NOW(),
+timezone TEXT NOT NULL DEFAULT 'Europe/Moscow' -- IANA, в нём вводятся и показываются даты
+);
+
+-- тарифы подписки
+CREATE TABLE IF NOT EXISTS subscription_plans (
+id SERIAL PRIMARY KEY,
+code TEXT NOT NULL UNIQUE, -- в комментарии к платежу: plan:pro
+name TEXT NOT NULL,
+price_ton NUMERIC(12,3) NOT NULL,
+duration_days INTEGER NOT NULL DEFAULT 30,
+generation_quota INTEGER NOT NULL DEFAULT 0, -- 0 — без лимита
+max_scheduled_posts INTEGER NOT NULL DEFAULT 0, -- 0 — без лимита
+allow_recurring BOOLEAN NOT NULL DEFAULT FALSE,
+allow_custom_model BOOLEAN NOT NULL DEFAULT FALSE, -- канал может выбрать свою модель LLM
+is_active BOOLEAN NOT NULL DEFAULT TRUE, -- FALSE — снят с продажи, у оплативших остаётся
+sort_order INTEGER NOT NULL DEFAULT 0,
+price_stars INTEGER -- цена в Telegram Stars, 0 — за звёзды не продаётся
 );
 
+INSERT INTO subscription_plans
+    (code, name, price_ton, duration_days, generation_quota, max_scheduled_posts, allow_recurring, allow_custom_model, sort_order, price_stars)
+VALUES
+    ('basic', 'Базовый', 12, 30, 100, 30, TRUE, FALSE, 1, 1000),
+    ('pro', 'Pro', 30, 30, 500, 0, TRUE, TRUE, 2, 2500)
+ON CONFLICT (code) DO NOTHING;
+
 CREATE TABLE IF NOT EXISTS channels (
                                         id SERIAL PRIMARY KEY,
                                         telegram_channel_id BIGINT NOT NULL,
@@ -14,6 +38,14 @@ CREATE TABLE IF NOT EXISTS channels (
     wallet_address TEXT,
     is_active BOOLEAN DEFAULT TRUE,
     created_at TIMESTAMP DEFAULT NOW(),
+    parse_mode TEXT NOT NULL DEFAULT 'HTML', -- HTML / MarkdownV2 / plain
+    image_providers TEXT NOT NULL DEFAULT '', -- источники картинок по порядку: "unsplash,pexels,local"; '' — по умолчанию
+    image_credit BOOLEAN NOT NULL DEFAULT FALSE, -- подпись «📷 Автор / Сток» под постом
+    llm_provider TEXT NOT NULL DEFAULT '', -- своя модель LLM (тарифы с allow_custom_model); '' — общая цепочка
+    llm_model TEXT NOT NULL DEFAULT '', -- '' — модель провайдера по умолчанию
+    generation_quota INTEGER NOT NULL DEFAULT 100, -- генераций на период подписки (0 — без лимита)
+    generations_used INTEGER NOT NULL DEFAULT 0, -- сбрасывается при продлении подписки
+    plan_id INTEGER REFERENCES subscription_plans(id) ON DELETE SET NULL, -- оплаченный тариф
     UNIQUE (client_id, telegram_channel_id)
     );
 
@@ -23,19 +55,111 @@ CREATE TABLE IF NOT EXISTS scheduled_posts (
 id SERIAL PRIMARY KEY,
 channel_id INTEGER REFERENCES channels(id) ON DELETE CASCADE,
 content TEXT,
-post_at TIMESTAMP,
+post_at TIMESTAMPTZ,
 theme TEXT,
 style TEXT,
 language TEXT,
 length TEXT,
 photo TEXT, -- ✅ Новое поле
+created_at TIMESTAMP DEFAULT NOW(),
+status TEXT NOT NULL DEFAULT 'pending', -- draft → pending → claimed → published / failed
+claimed_at TIMESTAMPTZ,
+claim_token TEXT, -- кто взял пост: завершить его может только этот захват
+published_at TIMESTAMPTZ,
+attempts INTEGER NOT NULL DEFAULT 0,
+last_error TEXT,
+next_attempt_at TIMESTAMPTZ,
+recurring_id INTEGER, -- ссылка на recurring_schedules (см. ниже)
+image_query TEXT NOT NULL DEFAULT '', -- запрос для поиска картинки (англ.), из ответа модели
+channel_image_id BIGINT -- картинка опубликованного поста с атрибуцией (channel_images, ниже)
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_posts_status_post_at_idx ON scheduled_posts (status, post_at);
+
+-- регулярные расписания (разворачиваются в scheduled_posts заранее)
+CREATE TABLE IF NOT EXISTS recurring_schedules (
+id SERIAL PRIMARY KEY,
+channel_id INTEGER REFERENCES channels(id) ON DELETE CASCADE NOT NULL,
+weekdays TEXT NOT NULL,          -- "1,4" (1 = пн … 7 = вс)
+times TEXT NOT NULL,             -- "09:00,18:30"
+timezone TEXT NOT NULL DEFAULT 'UTC',
+themes TEXT NOT NULL,            -- пул тем, по одной на строку
+style TEXT,
+language TEXT,
+length TEXT,
+is_active BOOLEAN NOT NULL DEFAULT TRUE,
+next_theme_idx INTEGER NOT NULL DEFAULT 0,
+expanded_until TIMESTAMPTZ NOT NULL DEFAULT NOW(),
 created_at TIMESTAMP DEFAULT NOW()
 );
 
+DO $$
+BEGIN
+    -- повторный запуск схемы (или база после миграций, где ключ называется scheduled_posts_recurring_id_fkey)
+    IF NOT EXISTS (
+        SELECT 1 FROM pg_constraint
+        WHERE conname IN ('scheduled_posts_recurring_fk', 'scheduled_posts_recurring_id_fkey')
+    ) THEN
+        ALTER TABLE scheduled_posts ADD CONSTRAINT scheduled_posts_recurring_fk
+            FOREIGN KEY (recurring_id) REFERENCES recurring_schedules(id) ON DELETE SET NULL;
+    END IF;
+END $$;
+CREATE UNIQUE INDEX IF NOT EXISTS scheduled_posts_recurring_post_at_idx ON scheduled_posts (recurring_id, post_at);
+
+-- учёт вызовов LLM (стоимость считается по ценам на момент вызова, LLM_PRICES)
+CREATE TABLE IF NOT EXISTS llm_usage (
+id BIGSERIAL PRIMARY KEY,
+created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+provider TEXT NOT NULL,
+model TEXT NOT NULL,
+purpose TEXT NOT NULL,           -- generate / translate
+prompt_tokens INTEGER NOT NULL DEFAULT 0,
+completion_tokens INTEGER NOT NULL DEFAULT 0,
+latency_ms INTEGER NOT NULL DEFAULT 0,
+cost_usd NUMERIC(12, 6) NOT NULL DEFAULT 0,
+success BOOLEAN NOT NULL DEFAULT TRUE,
+error TEXT,
+channel_id INTEGER REFERENCES channels(id) ON DELETE SET NULL,
+client_id INTEGER REFERENCES clients(id) ON DELETE SET NULL
+);
+CREATE INDEX IF NOT EXISTS llm_usage_channel_created_idx ON llm_usage (channel_id, created_at);
+
+-- баланс канала: + платежи, − оплата периодов (нанотоны)
+CREATE TABLE IF NOT EXISTS channel_credits (
+id BIGSERIAL PRIMARY KEY,
+channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+amount_nano NUMERIC(30,0) NOT NULL,
+kind TEXT NOT NULL, -- payment / charge / refund
+plan_id INTEGER REFERENCES subscription_plans(id) ON DELETE SET NULL,
+ref TEXT, -- хэш транзакции
+created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS channel_credits_channel_idx ON channel_credits (channel_id, id);
+
+-- счета на оплату: код из комментария к переводу → канал и тариф
+CREATE TABLE IF NOT EXISTS invoices (
+id BIGSERIAL PRIMARY KEY,
+code TEXT NOT NULL UNIQUE,
+channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+plan_id INTEGER NOT NULL REFERENCES subscription_plans(id),
+amount_nano NUMERIC(30,0) NOT NULL, -- к оплате с учётом баланса на момент выставления
+status TEXT NOT NULL DEFAULT 'open', -- open / paid
+created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+expires_at TIMESTAMPTZ NOT NULL,
+paid_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS invoices_channel_idx ON invoices (channel_id, status);
+
 -- служебные таблицы TON-воркера
 CREATE TABLE IF NOT EXISTS ton_watcher_state (
                                                  wallet TEXT PRIMARY KEY,
-                                                 last_utime BIGINT NOT NULL DEFAULT 0
+                                                 last_utime BIGINT NOT NULL DEFAULT 0, -- старый курсор, только для перехода
+                                                 last_lt BIGINT NOT NULL DEFAULT 0, -- курсор: lt + хэш последней обработанной транзакции
+                                                 last_hash TEXT NOT NULL DEFAULT '',
+                                                 resume_lt BIGINT NOT NULL DEFAULT 0, -- незакрытый пропуск: от resume до head уже разобрано,
+                                                 resume_hash TEXT NOT NULL DEFAULT '', -- между курсором и resume — ещё нет
+                                                 head_lt BIGINT NOT NULL DEFAULT 0,
+                                                 head_hash TEXT NOT NULL DEFAULT ''
 );
 
 CREATE TABLE IF NOT EXISTS ton_payments(
@@ -44,5 +168,54 @@ CREATE TABLE IF NOT EXISTS ton_payments(
                                            value TEXT NOT NULL,
                                            source TEXT,
                                            comment TEXT,
-                                           processed_at TIMESTAMPTZ DEFAULT now()
+                                           processed_at TIMESTAMPTZ DEFAULT now(),
+                                           invoice_id BIGINT REFERENCES invoices(id) ON DELETE SET NULL -- счёт из комментария
     );
+
+-- единый журнал платежей: TON-переводы и Telegram Stars
+CREATE TABLE IF NOT EXISTS payments (
+id BIGSERIAL PRIMARY KEY,
+provider TEXT NOT NULL, -- ton / stars
+external_id TEXT NOT NULL, -- хэш транзакции / telegram_payment_charge_id
+channel_id INTEGER REFERENCES channels(id) ON DELETE SET NULL,
+plan_id INTEGER REFERENCES subscription_plans(id) ON DELETE SET NULL,
+amount NUMERIC(30,0) NOT NULL, -- в минимальных единицах: нанотоны / звёзды
+currency TEXT NOT NULL, -- TON / XTR
+payer TEXT, -- адрес кошелька / Telegram user id
+created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+applied_at TIMESTAMPTZ, -- Stars: когда оплата продлила подписку (NULL — ещё нет); TON идёт через баланс
+UNIQUE (provider, external_id)
+);
+CREATE INDEX IF NOT EXISTS payments_channel_idx ON payments (channel_id, created_at);
+
+-- картинки, уже использованные в постах канала
+CREATE TABLE IF NOT EXISTS channel_images (
+id BIGSERIAL PRIMARY KEY,
+channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+provider TEXT NOT NULL, -- pexels / unsplash / pixabay / local
+image_id TEXT NOT NULL, -- id на стоке или имя файла
+url TEXT,
+page_url TEXT, -- страница картинки на стоке
+author TEXT, -- фотограф (атрибуция)
+author_url TEXT, -- профиль фотографа
+license TEXT, -- лицензия стока
+used_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS channel_images_channel_idx ON channel_images (channel_id, id);
+
+-- отправленные напоминания об окончании подписки (одно на вид и период)
+CREATE TABLE IF NOT EXISTS subscription_reminders (
+channel_id INTEGER NOT NULL REFERENCES channels(id) ON DELETE CASCADE,
+kind TEXT NOT NULL, -- 3d / 1d / grace / expired
+period_end TIMESTAMP NOT NULL, -- subscription_until на момент отправки
+sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+PRIMARY KEY (channel_id, kind, period_end)
+);
+
+-- отправленные уведомления по посту (одно на вид, несмотря на повторы публикации)
+CREATE TABLE IF NOT EXISTS post_notices (
+post_id INTEGER NOT NULL REFERENCES scheduled_posts(id) ON DELETE CASCADE,
+kind TEXT NOT NULL, -- quota
+sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+PRIMARY KEY (post_id, kind)
+);
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"database/sql"
+	"time"
+)
+
+// LLMUsage — один вызов LLM (одна попытка у одного провайдера)
+type LLMUsage struct {
+	Provider         string
+	Model            string
+	Purpose          string // generate / translate
+	PromptTokens     int
+	CompletionTokens int
+	Latency          time.Duration
+	CostUSD          float64
+	Error            string // пусто — вызов успешный
+	ChannelID        int64  // 0 — вызов не привязан к каналу
+	ClientID         int64
+}
+
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id > 0}
+}
+
+func RecordLLMUsage(db *sql.DB, u LLMUsage) error {
+	_, err := db.Exec(`
+		INSERT INTO llm_usage (provider, model, purpose, prompt_tokens, completion_tokens, latency_ms,
+			cost_usd, success, error, channel_id, client_id)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10, $11)
+	`, u.Provider, u.Model, u.Purpose, u.PromptTokens, u.CompletionTokens, u.Latency.Milliseconds(),
+		u.CostUSD, u.Error == "", u.Error, nullID(u.ChannelID), nullID(u.ClientID))
+	return err
+}
+
+// ChannelSpend — расходы на LLM по каналу за период
+type ChannelSpend struct {
+	ChannelID        int64 // 0 — вызовы без канала (служебные)
+	ChannelTitle     string
+	Calls            int
+	Failed           int
+	PromptTokens     int64
+	CompletionTokens int64
+	CostUSD          float64
+	PlanPriceTON     float64 // цена тарифа канала, 0 — тарифа нет
+}
+
+// GetLLMSpendByChannel — расходы по каналам за [from, to), самые дорогие сверху
+func GetLLMSpendByChannel(db *sql.DB, from, to time.Time) ([]ChannelSpend, error) {
+	rows, err := db.Query(`
+		SELECT COALESCE(u.channel_id, 0), COALESCE(c.channel_title, ''),
+			COUNT(*), COUNT(*) FILTER (WHERE NOT u.success),
+			COALESCE(SUM(u.prompt_tokens), 0), COALESCE(SUM(u.completion_tokens), 0),
+			COALESCE(SUM(u.cost_usd), 0)::float8,
+			COALESCE(p.price_ton, 0)::float8
+		FROM llm_usage u
+		LEFT JOIN channels c ON c.id = u.channel_id
+		LEFT JOIN subscription_plans p ON p.id = c.plan_id
+		WHERE u.created_at >= $1 AND u.created_at < $2
+		GROUP BY 1, 2, 8
+		ORDER BY 7 DESC
+	`, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []ChannelSpend
+	for rows.Next() {
+		var s ChannelSpend
+		if err := rows.Scan(&s.ChannelID, &s.ChannelTitle, &s.Calls, &s.Failed,
+			&s.PromptTokens, &s.CompletionTokens, &s.CostUSD, &s.PlanPriceTON); err != nil {
+			return nil, err
+		}
+		out = append(out, s)
+	}
+	return out, rows.Err()
+}
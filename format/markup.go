@@ -0,0 +1,248 @@
+package format
+
+import (
+	"html"
+	"strings"
+)
+
+// Режимы разметки Telegram (значения channels.parse_mode)
+const (
+	ModeHTML       = "HTML"
+	ModeMarkdownV2 = "MarkdownV2"
+	ModePlain      = "plain"
+)
+
+// PromptRules — какое подмножество разметки просим у модели.
+// Всё остальное выводится как обычный текст (с экранированием).
+const PromptRules = `Оформление — только такая разметка:
+**жирный**, *курсив*, ` + "`код`" + `, [текст ссылки](https://адрес),
+заголовок — строка, начинающаяся с "## ", пункт списка — строка, начинающаяся с "- ".
+Никакой другой разметки (таблиц, HTML, вложенных списков) не используй.`
+
+// NormalizeMode приводит значение настройки к одному из режимов (по умолчанию HTML)
+func NormalizeMode(mode string) string {
+	switch strings.ToLower(strings.TrimSpace(mode)) {
+	case "markdownv2":
+		return ModeMarkdownV2
+	case "plain":
+		return ModePlain
+	default:
+		return ModeHTML
+	}
+}
+
+// TelegramParseMode — значение поля parse_mode для Bot API ("" для простого текста)
+func TelegramParseMode(mode string) string {
+	if mode == ModePlain {
+		return ""
+	}
+	return mode
+}
+
+type span struct {
+	text   string
+	bold   bool
+	italic bool
+	code   bool
+	url    string
+}
+
+// Render переводит текст модели в выбранный режим Telegram
+func Render(src, mode string) string {
+	lines := strings.Split(src, "\n")
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		out = append(out, renderSpans(parseLine(line), mode))
+	}
+	return strings.Join(out, "\n")
+}
+
+// Plain — тот же текст без разметки
+func Plain(src string) string {
+	return Render(src, ModePlain)
+}
+
+// parseLine разбирает одну строку. Незакрытые маркеры остаются обычным текстом,
+// поэтому сущности на выходе всегда сбалансированы.
+func parseLine(line string) []span {
+	heading := false
+	trimmed := strings.TrimLeft(line, " ")
+	switch {
+	case strings.HasPrefix(trimmed, "#"):
+		h := strings.TrimLeft(trimmed, "#")
+		if strings.HasPrefix(h, " ") && len(trimmed)-len(h) <= 6 {
+			heading = true
+			line = strings.TrimSpace(h)
+		}
+	case strings.HasPrefix(trimmed, "- "), strings.HasPrefix(trimmed, "* "):
+		line = "• " + trimmed[2:]
+	}
+
+	var spans []span
+	var buf strings.Builder
+	bold, italic := heading, false
+
+	flush := func() {
+		if buf.Len() > 0 {
+			spans = append(spans, span{text: buf.String(), bold: bold, italic: italic})
+			buf.Reset()
+		}
+	}
+
+	for i := 0; i < len(line); {
+		rest := line[i:]
+		switch {
+		case strings.HasPrefix(rest, "**") && (heading || bold || strings.Contains(rest[2:], "**")):
+			flush()
+			if !heading {
+				bold = !bold
+			}
+			i += 2
+
+		case rest[0] == '*' && !strings.HasPrefix(rest, "**") && (italic || strings.Contains(rest[1:], "*")):
+			flush()
+			italic = !italic
+			i++
+
+		case rest[0] == '`' && strings.Contains(rest[1:], "`"):
+			flush()
+			end := strings.Index(rest[1:], "`")
+			spans = append(spans, span{text: rest[1 : 1+end], code: true})
+			i += end + 2
+
+		case rest[0] == '[':
+			mid := strings.Index(rest, "](")
+			if mid < 0 {
+				buf.WriteByte(rest[0])
+				i++
+				continue
+			}
+			end := strings.Index(rest[mid:], ")")
+			url := ""
+			if end > 0 {
+				url = rest[mid+2 : mid+end]
+			}
+			if end < 0 || !(strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")) {
+				buf.WriteByte(rest[0])
+				i++
+				continue
+			}
+			flush()
+			spans = append(spans, span{text: rest[1:mid], url: url, bold: bold, italic: italic})
+			i += mid + end + 1
+
+		default:
+			buf.WriteByte(rest[0])
+			i++
+		}
+	}
+	flush()
+	return spans
+}
+
+// markers — открывающий/закрывающий маркер жирного и курсива для режима
+func markers(mode string) (boldOpen, boldClose, italicOpen, italicClose string) {
+	switch mode {
+	case ModeHTML:
+		return "<b>", "</b>", "<i>", "</i>"
+	case ModeMarkdownV2:
+		return "*", "*", "_", "_"
+	}
+	return "", "", "", ""
+}
+
+// renderSpans ставит маркеры только на сменах стиля: теги правильно вложены,
+// а в MarkdownV2 не появляется "__" (это подчёркивание, а не курсив)
+func renderSpans(spans []span, mode string) string {
+	bo, bc, io, ic := markers(mode)
+	var b strings.Builder
+	bold, italic := false, false
+
+	for _, s := range spans {
+		if s.bold != bold {
+			// жирный меняется — закрываем всё и открываем заново в фиксированном порядке (b, затем i)
+			if italic {
+				b.WriteString(ic)
+				italic = false
+			}
+			if bold {
+				b.WriteString(bc)
+			} else {
+				b.WriteString(bo)
+			}
+			bold = s.bold
+		}
+		if s.italic != italic {
+			if italic {
+				b.WriteString(ic)
+			} else {
+				b.WriteString(io)
+			}
+			italic = s.italic
+		}
+
+		switch mode {
+		case ModeHTML:
+			b.WriteString(renderHTML(s))
+		case ModeMarkdownV2:
+			b.WriteString(renderMarkdownV2(s))
+		default:
+			b.WriteString(s.text)
+			if s.url != "" {
+				b.WriteString(" (" + s.url + ")")
+			}
+		}
+	}
+	if italic {
+		b.WriteString(ic)
+	}
+	if bold {
+		b.WriteString(bc)
+	}
+	return b.String()
+}
+
+func renderHTML(s span) string {
+	if s.code {
+		return "<code>" + html.EscapeString(s.text) + "</code>"
+	}
+	t := html.EscapeString(s.text)
+	if s.url != "" {
+		t = `<a href="` + html.EscapeString(s.url) + `">` + t + "</a>"
+	}
+	return t
+}
+
+// Символы, которые MarkdownV2 требует экранировать в обычном тексте
+const mdV2Special = "_*[]()~`>#+-=|{}.!\\"
+
+func escapeMarkdownV2(s, special string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func renderMarkdownV2(s span) string {
+	if s.code {
+		return "`" + escapeMarkdownV2(s.text, "`\\") + "`"
+	}
+	t := escapeMarkdownV2(s.text, mdV2Special)
+	if s.url != "" {
+		t = "[" + t + "](" + escapeMarkdownV2(s.url, ")\\") + ")"
+	}
+	return t
+}
+
+// IsEntityError — Telegram не смог разобрать разметку
+func IsEntityError(err error) bool {
+	if err == nil {
+		return false
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "can't parse entities") || strings.Contains(msg, "can't find end of")
+}
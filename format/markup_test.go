@@ -0,0 +1,112 @@
+package format
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		html  string
+		mdv2  string
+		plain string
+	}{
+		{"стили", "**жирный** и *курсив*",
+			"<b>жирный</b> и <i>курсив</i>", "*жирный* и _курсив_", "жирный и курсив"},
+		{"вложенные", "**жирный *и курсив***",
+			"<b>жирный <i>и курсив</i></b>", "*жирный _и курсив_*", "жирный и курсив"},
+		{"код", "запусти `go test ./...`",
+			"запусти <code>go test ./...</code>", "запусти `go test ./...`", "запусти go test ./..."},
+		{"ссылка", "[сайт.ру](https://ex.com/?a=1&b=2)",
+			`<a href="https://ex.com/?a=1&amp;b=2">сайт.ру</a>`, `[сайт\.ру](https://ex.com/?a=1&b=2)`,
+			"сайт.ру (https://ex.com/?a=1&b=2)"},
+		{"заголовок", "## Итоги недели",
+			"<b>Итоги недели</b>", "*Итоги недели*", "Итоги недели"},
+		{"пункты", "- первый\n* второй",
+			"• первый\n• второй", "• первый\n• второй", "• первый\n• второй"},
+		{"html-символы", `1 < 2 & "3" > 2`,
+			"1 &lt; 2 &amp; &#34;3&#34; &gt; 2", `1 < 2 & "3" \> 2`, `1 < 2 & "3" > 2`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for mode, want := range map[string]string{ModeHTML: tt.html, ModeMarkdownV2: tt.mdv2, ModePlain: tt.plain} {
+				if got := Render(tt.src, mode); got != want {
+					t.Errorf("Render(%q, %s) = %q, want %q", tt.src, mode, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderMarkdownV2Escaping(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{"Цена: 5.99$ (скидка -10%)!", `Цена: 5\.99$ \(скидка \-10%\)\!`},
+		{"a_b [x] {y} ~z~ > #t +1 = |p|", `a\_b \[x\] \{y\} \~z\~ \> \#t \+1 \= \|p\|`},
+		{`C:\dir`, `C:\\dir`},
+		{"`C:\\dir_1.txt`", "`C:\\\\dir_1.txt`"},                      // в коде экранируются только ` и \
+		{"[a_b](https://ex.com/a_(b)", `[a\_b](https://ex.com/a_(b)`}, // в адресе — только ) и \
+	}
+	for _, tt := range tests {
+		if got := Render(tt.src, ModeMarkdownV2); got != tt.want {
+			t.Errorf("Render(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+// Незакрытые маркеры остаются текстом — сущности на выходе всегда сбалансированы
+func TestRenderUnbalanced(t *testing.T) {
+	tests := []struct {
+		src  string
+		html string
+		mdv2 string
+	}{
+		{"**незакрытый жирный", "**незакрытый жирный", `\*\*незакрытый жирный`},
+		{"2 * 3 = 6", "2 * 3 = 6", `2 \* 3 \= 6`},
+		{"`без конца", "`без конца", "\\`без конца"},
+		{"[текст](ftp://x)", "[текст](ftp://x)", `\[текст\]\(ftp://x\)`},
+		{"[нет ссылки] и (скобки)", "[нет ссылки] и (скобки)", `\[нет ссылки\] и \(скобки\)`},
+		{"**жирный** и ещё **", "<b>жирный</b> и ещё **", `*жирный* и ещё \*\*`},
+		{"**жирный\nна двух строках**", "**жирный\nна двух строках**", "\\*\\*жирный\nна двух строках\\*\\*"},
+	}
+	for _, tt := range tests {
+		if got := Render(tt.src, ModeHTML); got != tt.html {
+			t.Errorf("Render(%q, HTML) = %q, want %q", tt.src, got, tt.html)
+		}
+		if got := Render(tt.src, ModeMarkdownV2); got != tt.mdv2 {
+			t.Errorf("Render(%q, MarkdownV2) = %q, want %q", tt.src, got, tt.mdv2)
+		}
+	}
+}
+
+func TestNormalizeMode(t *testing.T) {
+	tests := map[string]string{
+		"":            ModeHTML,
+		"html":        ModeHTML,
+		" MarkdownV2": ModeMarkdownV2,
+		"plain":       ModePlain,
+		"markdown":    ModeHTML,
+	}
+	for in, want := range tests {
+		if got := NormalizeMode(in); got != want {
+			t.Errorf("NormalizeMode(%q) = %q, want %q", in, got, want)
+		}
+	}
+	if TelegramParseMode(ModePlain) != "" || TelegramParseMode(ModeHTML) != "HTML" {
+		t.Error("TelegramParseMode")
+	}
+}
+
+func TestIsEntityError(t *testing.T) {
+	if !IsEntityError(errors.New("Bad Request: can't parse entities: Character '.' is reserved")) ||
+		!IsEntityError(errors.New("Bad Request: can't find end of Bold entity at byte offset 5")) {
+		t.Error("ошибка разметки не распознана")
+	}
+	if IsEntityError(nil) || IsEntityError(errors.New("Forbidden: bot is not a member")) {
+		t.Error("лишнее срабатывание")
+	}
+}
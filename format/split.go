@@ -0,0 +1,215 @@
+package format
+
+import (
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Len — длина текста так, как её считает Telegram (в символах UTF-16)
+func Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// Split разбивает текст модели на части, каждая из которых после рендеринга в mode
+// укладывается в limit видимых символов. Режем по абзацам, затем по строкам, предложениям
+// и словам. Каждая часть рендерится отдельно, поэтому сущности в ней всегда закрыты:
+// жирный, разрезанный посередине, закрывается в конце части и открывается в начале следующей.
+func Split(src, mode string, limit int) []string {
+	p := &packer{mode: mode, limit: limit}
+	for _, para := range paragraphs(src) {
+		if blockLen(para, mode) <= limit {
+			p.add(para, true)
+			continue
+		}
+		// Абзац не влезает целиком — добиваем текущую часть его началом, остальное режем дальше
+		first := true
+		for _, line := range para {
+			for len(line) > 0 {
+				room := p.room(first)
+				if room < limit/4 {
+					p.flush()
+					room = limit
+				}
+				piece := line
+				if lineLen(line, mode) > room {
+					head, tail, clean := cutLine(line, mode, room)
+					if !clean && room < limit {
+						// в остаток части влезает только кусок слова — лучше начать новую часть
+						p.flush()
+						continue
+					}
+					piece, line = head, tail
+				} else {
+					line = nil
+				}
+				if len(piece) > 0 {
+					p.add([][]span{piece}, first)
+					first = false
+				}
+			}
+		}
+	}
+	p.flush()
+
+	out := make([]string, 0, len(p.parts))
+	for _, part := range p.parts {
+		lines := make([]string, len(part))
+		for i, line := range part {
+			lines[i] = renderSpans(line, mode)
+		}
+		out = append(out, strings.Join(lines, "\n"))
+	}
+	return out
+}
+
+// paragraphs — строки, разобранные на спаны и сгруппированные по пустым строкам
+func paragraphs(src string) [][][]span {
+	var out [][][]span
+	var cur [][]span
+	for _, line := range strings.Split(src, "\n") {
+		if strings.TrimSpace(line) == "" {
+			if len(cur) > 0 {
+				out = append(out, cur)
+				cur = nil
+			}
+			continue
+		}
+		cur = append(cur, parseLine(line))
+	}
+	if len(cur) > 0 {
+		out = append(out, cur)
+	}
+	return out
+}
+
+// spanLen — видимая длина спана. В простом тексте ссылка выводится как "текст (url)".
+func spanLen(s span, mode string) int {
+	n := Len(s.text)
+	if s.url != "" && mode == ModePlain {
+		n += Len(" (" + s.url + ")")
+	}
+	return n
+}
+
+func lineLen(line []span, mode string) int {
+	n := 0
+	for _, s := range line {
+		n += spanLen(s, mode)
+	}
+	return n
+}
+
+func blockLen(lines [][]span, mode string) int {
+	n := 0
+	for i, line := range lines {
+		if i > 0 {
+			n++ // перевод строки
+		}
+		n += lineLen(line, mode)
+	}
+	return n
+}
+
+// packer жадно набивает части строками и абзацами
+type packer struct {
+	mode  string
+	limit int
+	parts [][][]span
+	cur   [][]span
+	n     int
+}
+
+// add дописывает блок (не длиннее limit) в текущую часть или начинает новую.
+// paragraph — блок начинает новый абзац (отделяется пустой строкой).
+func (p *packer) add(block [][]span, paragraph bool) {
+	size := blockLen(block, p.mode)
+	if size > p.room(paragraph) {
+		p.flush()
+	}
+	if len(p.cur) > 0 && paragraph {
+		p.cur = append(p.cur, nil)
+	}
+	p.n = p.limit - p.room(paragraph) + size
+	p.cur = append(p.cur, block...)
+}
+
+// room — сколько ещё влезет в текущую часть с учётом разделителя
+func (p *packer) room(paragraph bool) int {
+	if len(p.cur) == 0 {
+		return p.limit
+	}
+	gap := 1
+	if paragraph {
+		gap = 2
+	}
+	return p.limit - p.n - gap
+}
+
+func (p *packer) flush() {
+	if len(p.cur) > 0 {
+		p.parts = append(p.parts, p.cur)
+	}
+	p.cur, p.n = nil, 0
+}
+
+// cutLine отрезает от строки начало не длиннее limit: по концу предложения,
+// если не вышло — по пробелу, в крайнем случае посреди слова (clean == false).
+func cutLine(line []span, mode string, limit int) (head, tail []span, clean bool) {
+	type cut struct{ span, off int }
+	var sentence, word, hard cut
+	found := false
+
+	used := 0
+	for i, s := range line {
+		extra := spanLen(s, mode) - Len(s.text)
+		n := 0
+		for off, r := range s.text {
+			n += utf16.RuneLen(r)
+			end := off + utf8.RuneLen(r)
+			if used+n+extra > limit {
+				break
+			}
+			hard, found = cut{i, end}, true
+			if r == ' ' {
+				word = hard
+				prev := strings.TrimRight(s.text[:off], "»\")\"")
+				if strings.HasSuffix(prev, ".") || strings.HasSuffix(prev, "!") ||
+					strings.HasSuffix(prev, "?") || strings.HasSuffix(prev, "…") {
+					sentence = hard
+				}
+			}
+		}
+		used += spanLen(s, mode)
+		if used > limit {
+			break
+		}
+	}
+
+	c := hard
+	switch {
+	case sentence != (cut{}):
+		c, clean = sentence, true
+	case word != (cut{}):
+		c, clean = word, true
+	case !found:
+		// даже один символ не влезает — всё равно отрезаем его, чтобы не зациклиться
+		_, size := utf8.DecodeRuneInString(line[0].text)
+		c = cut{0, size}
+	}
+
+	s := line[c.span]
+	left, right := s, s
+	left.text = strings.TrimRight(s.text[:c.off], " ")
+	right.text = strings.TrimLeft(s.text[c.off:], " ")
+
+	head = append(head, line[:c.span]...)
+	if left.text != "" {
+		head = append(head, left)
+	}
+	if right.text != "" {
+		tail = append(tail, right)
+	}
+	tail = append(tail, line[c.span+1:]...)
+	return head, tail, clean
+}
@@ -0,0 +1,130 @@
+package format
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// Лимиты Telegram: сообщение и подпись к фото
+const (
+	messageLimit = 4096
+	captionLimit = 1024
+)
+
+// words — n слов по 5 символов через пробел
+func words(n int) string {
+	return strings.TrimSpace(strings.Repeat("слово ", n))
+}
+
+func TestSplitLimits(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		limit int
+		parts int
+	}{
+		{"короткий", "**Привет**, мир", messageLimit, 1},
+		{"ровно лимит", strings.Repeat("я", messageLimit), messageLimit, 1},
+		{"лимит + 1", strings.Repeat("я", messageLimit+1), messageLimit, 2},
+		{"абзацы", words(300) + "\n\n" + words(300) + "\n\n" + words(300), messageLimit, 2},
+		{"подпись", words(400), captionLimit, 3},
+		{"эмодзи", strings.Repeat("😀", 600), captionLimit, 2}, // 1200 UTF-16
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parts := Split(tt.src, ModePlain, tt.limit)
+			if len(parts) != tt.parts {
+				t.Errorf("частей %d, want %d", len(parts), tt.parts)
+			}
+			for i, p := range parts {
+				if Len(p) > tt.limit {
+					t.Errorf("часть %d: %d > %d", i, Len(p), tt.limit)
+				}
+				if !utf8.ValidString(p) {
+					t.Errorf("часть %d: битый UTF-8", i)
+				}
+			}
+			// ничего не потерялось, кроме пробелов на стыках
+			if got, want := strings.Join(strings.Fields(strings.Join(parts, "")), ""), strings.Join(strings.Fields(tt.src), ""); tt.name != "короткий" && got != want {
+				t.Errorf("текст изменился: %d байт, было %d", len(got), len(want))
+			}
+		})
+	}
+}
+
+// Лимит считается в UTF-16: эмодзи — суррогатная пара, её нельзя разрезать пополам
+func TestSplitSurrogatePairs(t *testing.T) {
+	parts := Split(strings.Repeat("😀", 600), ModePlain, captionLimit)
+	if len(parts) != 2 || Len(parts[0]) != captionLimit || utf8.RuneCountInString(parts[0]) != 512 {
+		t.Fatalf("части: %d, первая %d UTF-16", len(parts), Len(parts[0]))
+	}
+
+	// нечётный остаток: в 1023 единицы влезает только 511 эмодзи
+	parts = Split(strings.Repeat("😀", 600), ModePlain, captionLimit-1)
+	if utf8.RuneCountInString(parts[0]) != 511 || Len(parts[0]) != captionLimit-2 {
+		t.Errorf("первая часть: %d эмодзи, %d UTF-16", utf8.RuneCountInString(parts[0]), Len(parts[0]))
+	}
+}
+
+func TestSplitPrefersBoundaries(t *testing.T) {
+	tests := []struct {
+		name  string
+		src   string
+		limit int
+		want  []string
+	}{
+		{"предложения", "Первое предложение. Второе предложение.", 25,
+			[]string{"Первое предложение.", "Второе предложение."}},
+		{"слова", "раз два три четыре", 9, []string{"раз два", "три", "четыре"}},
+		{"посреди слова", "абвгдеёжзи", 4, []string{"абвг", "деёж", "зи"}},
+		{"абзацы", "один\n\nдва", 5, []string{"один", "два"}},
+		{"абзацы вместе", "один\n\nдва", 20, []string{"один\n\nдва"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Split(tt.src, ModePlain, tt.limit)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("Split(%q, %d) = %q, want %q", tt.src, tt.limit, got, tt.want)
+			}
+		})
+	}
+}
+
+// Жирный, разрезанный посередине, закрывается в конце части и открывается в начале следующей
+func TestSplitInsideSpan(t *testing.T) {
+	src := "**" + words(400) + "**"
+	tests := []struct {
+		mode        string
+		open, close string
+	}{
+		{ModeHTML, "<b>", "</b>"},
+		{ModeMarkdownV2, "*", "*"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.mode, func(t *testing.T) {
+			parts := Split(src, tt.mode, captionLimit)
+			if len(parts) != 3 {
+				t.Fatalf("частей %d, want 3", len(parts))
+			}
+			for i, p := range parts {
+				if !strings.HasPrefix(p, tt.open) || !strings.HasSuffix(p, tt.close) {
+					t.Errorf("часть %d не обёрнута в %s…%s: %q…%q", i, tt.open, tt.close, p[:10], p[len(p)-10:])
+				}
+				markers := strings.Count(p, tt.open)
+				if tt.open != tt.close {
+					markers += strings.Count(p, tt.close)
+				}
+				if markers != 2 {
+					t.Errorf("часть %d: лишние маркеры", i)
+				}
+			}
+		})
+	}
+
+	// лимит — по видимому тексту: теги HTML не считаются
+	src = "**" + strings.Repeat("я", captionLimit) + "**"
+	if parts := Split(src, ModeHTML, captionLimit); len(parts) != 1 {
+		t.Errorf("жирный ровно в лимит: частей %d", len(parts))
+	}
+}
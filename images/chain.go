@@ -0,0 +1,42 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Chain — источники по порядку: результат первого, кто что-то нашёл
+type Chain []ImageProvider
+
+func (c Chain) Name() string {
+	names := make([]string, len(c))
+	for i, p := range c {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, ",")
+}
+
+func (c Chain) Search(ctx context.Context, query string, limit int) ([]Image, error) {
+	var errs []error
+	for _, p := range c {
+		found, err := p.Search(ctx, query, limit)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			if !errors.Is(err, ErrNotConfigured) {
+				// 401/429 и прочие сбои видно в логах, но следующий источник всё равно пробуем
+				log.Printf("⚠️ Картинки %s: %v", p.Name(), err)
+			}
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name(), err))
+			continue
+		}
+		if len(found) > 0 {
+			return found, nil
+		}
+	}
+	return nil, errors.Join(append([]error{ErrNoImages}, errs...)...)
+}
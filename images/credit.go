@@ -0,0 +1,39 @@
+package images
+
+import "strings"
+
+// providerTitles — названия стоков для подписи под постом
+var providerTitles = map[string]string{
+	"pexels":   "Pexels",
+	"unsplash": "Unsplash",
+	"pixabay":  "Pixabay",
+}
+
+// Credit — подпись автора в разметке постов: "📷 [Имя](профиль) / [Pexels](страница фото)".
+// Без ссылок — просто текст. Для фирменных картинок и фото без автора — "".
+func Credit(img Image) string {
+	title, ok := providerTitles[img.Provider]
+	if !ok || strings.TrimSpace(img.Author) == "" {
+		return ""
+	}
+	return "📷 " + creditLink(cleanCredit(img.Author), img.AuthorURL) + " / " + creditLink(title, img.PageURL)
+}
+
+func creditLink(text, url string) string {
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") || strings.ContainsAny(url, " ()") {
+		return text
+	}
+	return "[" + text + "](" + url + ")"
+}
+
+// cleanCredit убирает из имени символы разметки, чтобы имя не превратилось в жирный/ссылку
+func cleanCredit(name string) string {
+	name = strings.Map(func(r rune) rune {
+		switch r {
+		case '*', '_', '`', '[', ']', '(', ')', '\n', '\r':
+			return -1
+		}
+		return r
+	}, name)
+	return strings.Join(strings.Fields(name), " ")
+}
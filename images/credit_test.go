@@ -0,0 +1,25 @@
+package images
+
+import "testing"
+
+func TestCredit(t *testing.T) {
+	tests := []struct {
+		name string
+		img  Image
+		want string
+	}{
+		{"pexels", Image{Provider: "pexels", Author: "Joey Farina", AuthorURL: "https://www.pexels.com/@joey", PageURL: "https://www.pexels.com/photo/coffee-2014422/"},
+			"📷 [Joey Farina](https://www.pexels.com/@joey) / [Pexels](https://www.pexels.com/photo/coffee-2014422/)"},
+		{"без ссылок", Image{Provider: "unsplash", Author: "Jeff Sheldon"}, "📷 Jeff Sheldon / Unsplash"},
+		{"разметка в имени", Image{Provider: "pixabay", Author: "*Star_[Photo]*  ", PageURL: "javascript:alert(1)"}, "📷 StarPhoto / Pixabay"},
+		{"без автора", Image{Provider: "pexels", PageURL: "https://www.pexels.com/photo/1/"}, ""},
+		{"фирменная", Image{Provider: "local", ID: "logo.png", Author: "Brand"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Credit(tt.img); got != tt.want {
+				t.Errorf("Credit() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
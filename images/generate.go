@@ -0,0 +1,211 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Картинку больше этого Telegram как фото не примет
+const maxGeneratedSize = 10 << 20
+
+// GeneratedProvider — генерация картинки нейросетью через OpenAI-совместимый /images/generations
+// (OpenAI, локальный Stable Diffusion с таким API и т. п.). Отдаёт одну картинку байтами.
+type GeneratedProvider struct {
+	BaseURL string // по умолчанию https://api.openai.com/v1
+	APIKey  string
+	Model   string // по умолчанию dall-e-3
+	Size    string // по умолчанию 1024x1024
+	Client  *http.Client
+}
+
+type generationRequest struct {
+	Model          string `json:"model,omitempty"`
+	Prompt         string `json:"prompt"`
+	N              int    `json:"n"`
+	Size           string `json:"size,omitempty"`
+	ResponseFormat string `json:"response_format,omitempty"`
+}
+
+type generationResponse struct {
+	Data []struct {
+		B64JSON string `json:"b64_json"`
+		URL     string `json:"url"`
+	} `json:"data"`
+}
+
+func (p *GeneratedProvider) Name() string { return "ai" }
+
+// Search рисует картинку по тексту поста из ctx (WithPostText) или по запросу; limit не важен — генерация платная, всегда одна
+func (p *GeneratedProvider) Search(ctx context.Context, query string, _ int) ([]Image, error) {
+	// без ключа работает только свой сервер, его адрес задают явно
+	if p.APIKey == "" && p.BaseURL == "" {
+		return nil, ErrNotConfigured
+	}
+	model := p.Model
+	if model == "" {
+		model = "dall-e-3"
+	}
+	size := p.Size
+	if size == "" {
+		size = "1024x1024"
+	}
+	body := generationRequest{Model: model, Prompt: GenerationPrompt(postText(ctx), query), N: 1, Size: size}
+	if !strings.HasPrefix(model, "gpt-image") {
+		// gpt-image-* всегда отвечают base64 и не принимают response_format
+		body.ResponseFormat = "b64_json"
+	}
+
+	var data generationResponse
+	if err := p.postJSON(ctx, baseURL(p.BaseURL, "https://api.openai.com/v1")+"/images/generations", body, &data); err != nil {
+		return nil, err
+	}
+	if len(data.Data) == 0 {
+		return nil, nil
+	}
+
+	var img []byte
+	var err error
+	switch d := data.Data[0]; {
+	case d.B64JSON != "":
+		img, err = base64.StdEncoding.DecodeString(d.B64JSON)
+	case d.URL != "":
+		// ссылки на сгенерированные картинки быстро протухают — скачиваем и шлём байтами
+		img, err = p.download(ctx, d.URL)
+	default:
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("картинка из ответа: %w", err)
+	}
+	if len(img) > maxGeneratedSize {
+		return nil, fmt.Errorf("картинка %d байт — больше лимита Telegram", len(img))
+	}
+	sum := sha256.Sum256(img)
+	return []Image{{Provider: p.Name(), ID: hex.EncodeToString(sum[:8]), Data: img}}, nil
+}
+
+// Сколько символов текста поста уходит в промпт: дальше обычно детали, картинке они не нужны
+const promptTextLimit = 500
+
+type postTextKey struct{}
+
+// WithPostText кладёт в ctx текст поста: по нему генерация рисует картинку точнее,
+// чем по короткому запросу для стоков
+func WithPostText(ctx context.Context, text string) context.Context {
+	return context.WithValue(ctx, postTextKey{}, text)
+}
+
+func postText(ctx context.Context) string {
+	text, _ := ctx.Value(postTextKey{}).(string)
+	return text
+}
+
+var (
+	reMarkupTag  = regexp.MustCompile(`<[^>]*>`)
+	reMarkupChar = regexp.MustCompile("[*_~`|#>\\\\]+")
+	reSpaces     = regexp.MustCompile(`\s+`)
+)
+
+// GenerationPrompt — промпт для генерации: суть поста (первый абзац текста без разметки),
+// а запрос для стоков — подсказка к главному объекту; без текста — только запрос
+func GenerationPrompt(text, query string) string {
+	summary := postSummary(text)
+	query = strings.TrimSpace(query)
+	if summary == "" {
+		return query + ". Photorealistic illustration for a social media post, " +
+			"no text, no letters, no watermarks."
+	}
+	prompt := "Photorealistic illustration for a social media post. The post: " + summary
+	if last, _ := utf8.DecodeLastRuneInString(summary); !strings.ContainsRune(".!?…", last) {
+		prompt += "."
+	}
+	if query != "" {
+		prompt += " Main subject: " + query + "."
+	}
+	return prompt + " No text, no letters, no watermarks."
+}
+
+// postSummary — первый непустой абзац поста без HTML/Markdown, не длиннее promptTextLimit
+func postSummary(text string) string {
+	text = reMarkupTag.ReplaceAllString(text, "")
+	text = reMarkupChar.ReplaceAllString(text, "")
+	for _, para := range strings.Split(text, "\n\n") {
+		para = strings.TrimSpace(reSpaces.ReplaceAllString(para, " "))
+		if para == "" {
+			continue
+		}
+		if r := []rune(para); len(r) > promptTextLimit {
+			para = string(r[:promptTextLimit])
+			if i := strings.LastIndex(para, " "); i > 0 {
+				para = para[:i]
+			}
+			para += "…"
+		}
+		return para
+	}
+	return ""
+}
+
+func (p *GeneratedProvider) client() *http.Client {
+	if p.Client != nil {
+		return p.Client
+	}
+	// генерация заметно дольше поиска; общий дедлайн всё равно задаёт context
+	return &http.Client{Timeout: 2 * time.Minute}
+}
+
+func (p *GeneratedProvider) postJSON(ctx context.Context, url string, body, out any) error {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.APIKey)
+	}
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 300))
+		return &HTTPError{Status: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("разбор ответа: %w", err)
+	}
+	return nil
+}
+
+func (p *GeneratedProvider) download(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{Status: resp.StatusCode}
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxGeneratedSize+1))
+}
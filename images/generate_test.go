@@ -0,0 +1,112 @@
+package images
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mybot/internal/testutil"
+)
+
+func TestGeneratedProvider(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\nfake")
+	var files *httptest.Server
+	tests := []struct {
+		name      string
+		model     string
+		respond   func(w http.ResponseWriter)
+		wantFmt   string
+		wantBytes []byte
+	}{
+		{"b64", "", func(w http.ResponseWriter) {
+			json.NewEncoder(w).Encode(map[string]any{"data": []map[string]string{{"b64_json": base64.StdEncoding.EncodeToString(png)}}})
+		}, "b64_json", png},
+		{"url", "sdxl", func(w http.ResponseWriter) {
+			json.NewEncoder(w).Encode(map[string]any{"data": []map[string]string{{"url": files.URL + "/out.png"}}})
+		}, "b64_json", png},
+		{"gpt-image", "gpt-image-1", func(w http.ResponseWriter) {
+			json.NewEncoder(w).Encode(map[string]any{"data": []map[string]string{{"b64_json": base64.StdEncoding.EncodeToString(png)}}})
+		}, "", png},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			files = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.Write(png) }))
+			t.Cleanup(files.Close)
+			var got generationRequest
+			var path, auth string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path, auth = r.URL.Path, r.Header.Get("Authorization")
+				json.NewDecoder(r.Body).Decode(&got)
+				tt.respond(w)
+			}))
+			t.Cleanup(srv.Close)
+
+			p := &GeneratedProvider{BaseURL: srv.URL + "/v1", APIKey: "key", Model: tt.model}
+			imgs, err := p.Search(context.Background(), "morning coffee", 15)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(imgs) != 1 || !bytes.Equal(imgs[0].Data, tt.wantBytes) || imgs[0].Provider != "ai" || imgs[0].ID == "" || imgs[0].URL != "" {
+				t.Errorf("Search() = %+v", imgs)
+			}
+			if path != "/v1/images/generations" || auth != "Bearer key" {
+				t.Errorf("запрос: %s, Authorization %q", path, auth)
+			}
+			if got.N != 1 || got.ResponseFormat != tt.wantFmt || !strings.HasPrefix(got.Prompt, "morning coffee. ") {
+				t.Errorf("тело запроса: %+v", got)
+			}
+		})
+	}
+}
+
+func TestGenerationPrompt(t *testing.T) {
+	long := strings.Repeat("кофейня ", 100) // 500 символов кончаются посреди 63-го слова
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		want  string
+	}{
+		{"без текста — по запросу", "", "morning coffee",
+			"morning coffee. Photorealistic illustration for a social media post, no text, no letters, no watermarks."},
+		{"первый абзац без разметки", "<b>Утро начинается с кофе</b>\nСекрет — в *свежей* обжарке\n\nПодписывайтесь!", "morning coffee",
+			"Photorealistic illustration for a social media post. The post: Утро начинается с кофе Секрет — в свежей обжарке. " +
+				"Main subject: morning coffee. No text, no letters, no watermarks."},
+		{"пустые абзацы пропускаются", "\n\n  \n\nКофе без сахара!", "",
+			"Photorealistic illustration for a social media post. The post: Кофе без сахара! No text, no letters, no watermarks."},
+		{"длинный текст обрезается по слову", long, "coffee",
+			"Photorealistic illustration for a social media post. The post: " + strings.TrimSpace(strings.Repeat("кофейня ", 62)) + "… " +
+				"Main subject: coffee. No text, no letters, no watermarks."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := GenerationPrompt(tt.text, tt.query); got != tt.want {
+				t.Errorf("GenerationPrompt() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGeneratedProviderErrors(t *testing.T) {
+	if _, err := (&GeneratedProvider{}).Search(context.Background(), "coffee", 1); !errors.Is(err, ErrNotConfigured) {
+		t.Errorf("без ключа и адреса: err = %v", err)
+	}
+
+	srv, _ := testutil.FixtureServer(t, "pexels.json", http.StatusUnauthorized, `{"error":{"message":"Incorrect API key"}}`)
+	_, err := (&GeneratedProvider{BaseURL: srv.URL, APIKey: "bad"}).Search(context.Background(), "coffee", 1)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("401: err = %v", err)
+	}
+
+	// генерация не в порядке по умолчанию, но явно включается каналом
+	t.Setenv("IMAGE_PROVIDERS", "")
+	if Generates("") || !Generates("pexels, ai") || ForOrder("pexels,ai").Name() != "pexels,ai" {
+		t.Errorf("ai в порядке: default %v, pexels,ai %v", Generates(""), Generates("pexels,ai"))
+	}
+}
@@ -0,0 +1,68 @@
+package images
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var localExts = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".webp": true}
+
+// LocalProvider — картинки из папки (фирменные изображения канала, офлайн-замена стоков).
+// Сначала ищет файлы, в имени которых есть слова запроса, иначе отдаёт все картинки папки —
+// поэтому в цепочке его ставят последним.
+type LocalProvider struct {
+	Dir string
+}
+
+func (p *LocalProvider) Name() string { return "local" }
+
+func (p *LocalProvider) Search(_ context.Context, query string, limit int) ([]Image, error) {
+	if p.Dir == "" {
+		return nil, ErrNotConfigured
+	}
+	entries, err := os.ReadDir(p.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && localExts[strings.ToLower(filepath.Ext(e.Name()))] {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+
+	words := splitWords(query)
+	var matched []string
+	for _, name := range names {
+		base := strings.Join(splitWords(strings.TrimSuffix(name, filepath.Ext(name))), " ")
+		for _, w := range words {
+			if len([]rune(w)) >= 3 && strings.Contains(base, w) {
+				matched = append(matched, name)
+				break
+			}
+		}
+	}
+	if len(matched) == 0 {
+		matched = names
+	}
+	if len(matched) > limit {
+		matched = matched[:limit]
+	}
+
+	out := make([]Image, 0, len(matched))
+	for _, name := range matched {
+		out = append(out, Image{Provider: p.Name(), ID: name, Path: filepath.Join(p.Dir, name)})
+	}
+	return out, nil
+}
+
+func splitWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
@@ -0,0 +1,56 @@
+package images
+
+import "math/rand/v2"
+
+// Key — идентификатор картинки в истории канала: "pexels:2014422"
+func (img Image) Key() string {
+	return img.Provider + ":" + img.ID
+}
+
+// Pick выбирает картинку из кандидатов случайно с весом по релевантности:
+// первая в выдаче стока в n раз вероятнее последней. used — ключи из истории канала
+// с номером (0 — самая свежая); такие картинки пропускаются, а если свежих нет,
+// берётся та, что использовалась давнее всех. r == nil — общий генератор.
+func Pick(candidates []Image, used map[string]int, r *rand.Rand) (Image, bool) {
+	if len(candidates) == 0 {
+		return Image{}, false
+	}
+	intN := rand.IntN
+	if r != nil {
+		intN = r.IntN
+	}
+
+	type weighted struct {
+		img    Image
+		weight int
+	}
+	var fresh []weighted
+	total := 0
+	for i, img := range candidates {
+		if _, ok := used[img.Key()]; ok {
+			continue
+		}
+		w := len(candidates) - i
+		fresh = append(fresh, weighted{img, w})
+		total += w
+	}
+
+	if len(fresh) == 0 {
+		oldest := candidates[0]
+		for _, img := range candidates[1:] {
+			if used[img.Key()] > used[oldest.Key()] {
+				oldest = img
+			}
+		}
+		return oldest, true
+	}
+
+	n := intN(total)
+	for _, c := range fresh {
+		if n < c.weight {
+			return c.img, true
+		}
+		n -= c.weight
+	}
+	return fresh[len(fresh)-1].img, true
+}
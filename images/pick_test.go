@@ -0,0 +1,58 @@
+package images
+
+import (
+	"math/rand/v2"
+	"testing"
+)
+
+func candidates(ids ...string) []Image {
+	out := make([]Image, len(ids))
+	for i, id := range ids {
+		out[i] = Image{Provider: "pexels", ID: id}
+	}
+	return out
+}
+
+func TestPickSkipsUsed(t *testing.T) {
+	r := rand.New(rand.NewPCG(1, 2))
+	used := map[string]int{"pexels:a": 0, "pexels:c": 3}
+	for range 200 {
+		img, ok := Pick(candidates("a", "b", "c", "d"), used, r)
+		if !ok {
+			t.Fatal("Pick: ничего не выбрано")
+		}
+		if img.ID == "a" || img.ID == "c" {
+			t.Fatalf("выбрана использованная картинка %s", img.ID)
+		}
+	}
+}
+
+func TestPickWeightedByRelevance(t *testing.T) {
+	// веса 3:2:1 — первая в выдаче должна выпадать заметно чаще последней
+	r := rand.New(rand.NewPCG(7, 7))
+	counts := map[string]int{}
+	const n = 6000
+	for range n {
+		img, _ := Pick(candidates("a", "b", "c"), nil, r)
+		counts[img.ID]++
+	}
+	for id, want := range map[string]float64{"a": 0.5, "b": 1.0 / 3, "c": 1.0 / 6} {
+		got := float64(counts[id]) / n
+		if got < want-0.03 || got > want+0.03 {
+			t.Errorf("доля %s = %.3f, want ≈%.3f", id, got, want)
+		}
+	}
+}
+
+func TestPickAllUsed(t *testing.T) {
+	// все уже были — берём ту, что использовалась давнее всех
+	used := map[string]int{"pexels:a": 2, "pexels:b": 7, "pexels:c": 0}
+	img, ok := Pick(candidates("a", "b", "c"), used, nil)
+	if !ok || img.ID != "b" {
+		t.Errorf("Pick = %+v, %v; want b", img, ok)
+	}
+
+	if _, ok := Pick(nil, nil, nil); ok {
+		t.Error("Pick(nil): ожидалось false")
+	}
+}
@@ -0,0 +1,196 @@
+// Package images — картинки для постов: поиск в стоках и в локальной папке, генерация нейросетью.
+//
+// Источники (порядок по умолчанию — IMAGE_PROVIDERS, иначе pexels,unsplash,pixabay,local;
+// у канала может быть свой, channels.image_providers):
+//   - pexels: PEXELS_API_KEY;
+//   - unsplash: UNSPLASH_ACCESS_KEY;
+//   - pixabay: PIXABAY_API_KEY;
+//   - local: IMAGES_LOCAL_DIR — папка с фирменными картинками, работает без сети;
+//   - ai: генерация через OpenAI-совместимый /images/generations — IMAGE_GEN_API_KEY
+//     и/или IMAGE_GEN_BASE_URL (свой сервер, например Stable Diffusion), IMAGE_GEN_MODEL,
+//     IMAGE_GEN_SIZE. Платная, поэтому в порядок по умолчанию не входит: "ai" — только
+//     генерация, "pexels,unsplash,pixabay,ai" — стоки, а если пусто — генерация.
+//
+// Источник без ключа пропускается, цепочка берёт первый, который что-то нашёл.
+package images
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Image — найденная картинка: URL для стоков, Path для локальных файлов, Data для сгенерированных
+type Image struct {
+	Provider  string
+	ID        string
+	URL       string
+	Path      string
+	Data      []byte
+	PageURL   string // страница картинки на стоке
+	Author    string // фотограф
+	AuthorURL string // профиль фотографа
+	License   string // лицензия стока
+}
+
+// Лицензии стоков: все разрешают бесплатное использование, автора указывать желательно
+const (
+	LicensePexels   = "Pexels License"
+	LicenseUnsplash = "Unsplash License"
+	LicensePixabay  = "Pixabay Content License"
+)
+
+// ImageProvider — источник картинок. Пустой результат без ошибки — «ничего не нашлось».
+type ImageProvider interface {
+	Name() string
+	Search(ctx context.Context, query string, limit int) ([]Image, error)
+}
+
+var (
+	ErrNoImages      = errors.New("картинки не найдены")
+	ErrNotConfigured = errors.New("источник не настроен")
+	ErrUnauthorized  = errors.New("неверный ключ API")
+	ErrRateLimited   = errors.New("превышен лимит запросов")
+)
+
+// HTTPError — ответ стока с ошибочным статусом; 401/403 и 429 различаются через errors.Is
+type HTTPError struct {
+	Status int
+	Body   string
+}
+
+func (e *HTTPError) Error() string {
+	msg := fmt.Sprintf("HTTP %d", e.Status)
+	switch {
+	case errors.Is(e, ErrUnauthorized):
+		msg += " (" + ErrUnauthorized.Error() + ")"
+	case errors.Is(e, ErrRateLimited):
+		msg += " (" + ErrRateLimited.Error() + ")"
+	}
+	if e.Body != "" {
+		msg += ": " + e.Body
+	}
+	return msg
+}
+
+func (e *HTTPError) Is(target error) bool {
+	switch target {
+	case ErrUnauthorized:
+		return e.Status == http.StatusUnauthorized || e.Status == http.StatusForbidden
+	case ErrRateLimited:
+		return e.Status == http.StatusTooManyRequests
+	}
+	return false
+}
+
+// Names — все известные источники; без генерации — порядок по умолчанию
+var Names = []string{"pexels", "unsplash", "pixabay", "local", "ai"}
+
+var defaultNames = Names[:4]
+
+// New — источник по имени с настройками из окружения
+func New(name string) (ImageProvider, error) {
+	switch name {
+	case "pexels":
+		return &PexelsProvider{APIKey: os.Getenv("PEXELS_API_KEY")}, nil
+	case "unsplash":
+		return &UnsplashProvider{AccessKey: os.Getenv("UNSPLASH_ACCESS_KEY")}, nil
+	case "pixabay":
+		return &PixabayProvider{APIKey: os.Getenv("PIXABAY_API_KEY")}, nil
+	case "local":
+		return &LocalProvider{Dir: os.Getenv("IMAGES_LOCAL_DIR")}, nil
+	case "ai":
+		return &GeneratedProvider{
+			BaseURL: os.Getenv("IMAGE_GEN_BASE_URL"),
+			APIKey:  os.Getenv("IMAGE_GEN_API_KEY"),
+			Model:   os.Getenv("IMAGE_GEN_MODEL"),
+			Size:    os.Getenv("IMAGE_GEN_SIZE"),
+		}, nil
+	}
+	return nil, fmt.Errorf("неизвестный источник картинок %q", name)
+}
+
+// ParseOrder разбирает порядок источников: "unsplash, pexels local" → [unsplash pexels local]
+func ParseOrder(s string) ([]string, error) {
+	fields := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == ',' || r == ';' || r == ' ' || r == '\n' || r == '\t'
+	})
+	var order []string
+	seen := map[string]bool{}
+	for _, name := range fields {
+		if _, err := New(name); err != nil {
+			return nil, err
+		}
+		if !seen[name] {
+			seen[name] = true
+			order = append(order, name)
+		}
+	}
+	return order, nil
+}
+
+// DefaultOrder — IMAGE_PROVIDERS, иначе все источники, кроме генерации
+func DefaultOrder() []string {
+	if order, err := ParseOrder(os.Getenv("IMAGE_PROVIDERS")); err == nil && len(order) > 0 {
+		return order
+	}
+	return defaultNames
+}
+
+// ForOrder — цепочка источников по сохранённому порядку канала ("" — порядок по умолчанию)
+func ForOrder(s string) Chain {
+	order, err := ParseOrder(s)
+	if err != nil || len(order) == 0 {
+		order = DefaultOrder()
+	}
+	chain := make(Chain, 0, len(order))
+	for _, name := range order {
+		p, _ := New(name)
+		chain = append(chain, p)
+	}
+	return chain
+}
+
+// Generates — есть ли в порядке канала генерация (ей нужен дедлайн подлиннее)
+func Generates(order string) bool {
+	for _, p := range ForOrder(order) {
+		if _, ok := p.(*GeneratedProvider); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// getJSON — GET с заголовками; статус не 200 возвращается как *HTTPError
+func getJSON(ctx context.Context, client *http.Client, url string, header http.Header, out any) error {
+	if client == nil {
+		client = &http.Client{Timeout: 15 * time.Second}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 300))
+		return &HTTPError{Status: resp.StatusCode, Body: strings.TrimSpace(string(body))}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("разбор ответа: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package images
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"mybot/internal/testutil"
+)
+
+func TestStockProviders(t *testing.T) {
+	tests := []struct {
+		name    string
+		fixture string
+		make    func(base string) ImageProvider
+		limit   int
+		want    []Image
+		check   func(t *testing.T, r *http.Request)
+	}{
+		{
+			"pexels", "pexels.json",
+			func(base string) ImageProvider { return &PexelsProvider{BaseURL: base, APIKey: "key"} },
+			5,
+			[]Image{{Provider: "pexels", ID: "2014422", URL: "https://images.pexels.com/photos/2014422/large.jpeg",
+				PageURL: "https://www.pexels.com/photo/coffee-2014422/", Author: "Joey Farina",
+				AuthorURL: "https://www.pexels.com/@joey", License: LicensePexels}},
+			func(t *testing.T, r *http.Request) {
+				if r.URL.Path != "/v1/search" || r.Header.Get("Authorization") != "key" || r.URL.Query().Get("per_page") != "5" {
+					t.Errorf("запрос: %s %v", r.URL, r.Header)
+				}
+			},
+		},
+		{
+			"unsplash", "unsplash.json",
+			func(base string) ImageProvider { return &UnsplashProvider{BaseURL: base, AccessKey: "key"} },
+			1,
+			[]Image{{Provider: "unsplash", ID: "eOLpJytrbsQ", URL: "https://images.unsplash.com/photo-1?w=1080",
+				PageURL: "https://unsplash.com/photos/eOLpJytrbsQ", Author: "Jeff Sheldon",
+				AuthorURL: "https://unsplash.com/@ugmonk", License: LicenseUnsplash}},
+			func(t *testing.T, r *http.Request) {
+				if r.URL.Path != "/search/photos" || r.Header.Get("Authorization") != "Client-ID key" {
+					t.Errorf("запрос: %s %v", r.URL, r.Header)
+				}
+			},
+		},
+		{
+			"pixabay", "pixabay.json",
+			func(base string) ImageProvider { return &PixabayProvider{BaseURL: base, APIKey: "key"} },
+			1, // Pixabay просим минимум 3, лишнее отрезаем
+			[]Image{{Provider: "pixabay", ID: "195893", URL: "https://pixabay.com/get/195893_1280.jpg",
+				PageURL: "https://pixabay.com/photos/coffee-195893/", Author: "Josch13",
+				AuthorURL: "https://pixabay.com/users/Josch13-48777/", License: LicensePixabay}},
+			func(t *testing.T, r *http.Request) {
+				q := r.URL.Query()
+				if r.URL.Path != "/api/" || q.Get("key") != "key" || q.Get("per_page") != "3" || q.Get("q") != "morning coffee" {
+					t.Errorf("запрос: %s", r.URL)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, req := testutil.FixtureServer(t, tt.fixture, http.StatusOK, "")
+			got, err := tt.make(srv.URL).Search(context.Background(), "morning coffee", tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Search() =\n%+v\nwant\n%+v", got, tt.want)
+			}
+			tt.check(t, req)
+		})
+	}
+}
+
+func TestStockProviderErrors(t *testing.T) {
+	tests := []struct {
+		name         string
+		status       int
+		body         string
+		unauthorized bool
+		limited      bool
+	}{
+		{"401", http.StatusUnauthorized, "", true, false},
+		{"403", http.StatusForbidden, "", true, false},
+		{"429", http.StatusTooManyRequests, "", false, true},
+		{"502", http.StatusBadGateway, "", false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := testutil.FixtureServer(t, "pexels.json", tt.status, tt.body)
+			for _, p := range []ImageProvider{
+				&PexelsProvider{BaseURL: srv.URL, APIKey: "key"},
+				&UnsplashProvider{BaseURL: srv.URL, AccessKey: "key"},
+				&PixabayProvider{BaseURL: srv.URL, APIKey: "key"},
+			} {
+				_, err := p.Search(context.Background(), "coffee", 1)
+				if err == nil {
+					t.Fatalf("%s: ожидалась ошибка", p.Name())
+				}
+				if errors.Is(err, ErrUnauthorized) != tt.unauthorized || errors.Is(err, ErrRateLimited) != tt.limited {
+					t.Errorf("%s: err = %v", p.Name(), err)
+				}
+			}
+		})
+	}
+
+	// Pixabay о неверном ключе отвечает 400
+	srv, _ := testutil.FixtureServer(t, "pixabay.json", http.StatusBadRequest, "[ERROR 400] Invalid or missing API key")
+	_, err := (&PixabayProvider{BaseURL: srv.URL, APIKey: "bad"}).Search(context.Background(), "coffee", 1)
+	if !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("pixabay 400: err = %v", err)
+	}
+
+	// без ключа в сеть не ходим
+	for _, p := range []ImageProvider{&PexelsProvider{}, &UnsplashProvider{}, &PixabayProvider{}, &LocalProvider{}} {
+		if _, err := p.Search(context.Background(), "coffee", 1); !errors.Is(err, ErrNotConfigured) {
+			t.Errorf("%s без ключа: err = %v", p.Name(), err)
+		}
+	}
+}
+
+// brandDir — папка с фирменными картинками для LocalProvider
+func brandDir(t *testing.T, names ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, name := range names {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("img"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLocalProvider(t *testing.T) {
+	dir := brandDir(t, "logo.png", "coffee-morning.jpg", "Coffee_Beans.JPEG", "notes.txt", "team.webp")
+	p := &LocalProvider{Dir: dir}
+
+	tests := []struct {
+		query string
+		limit int
+		want  []string
+	}{
+		{"morning coffee", 5, []string{"Coffee_Beans.JPEG", "coffee-morning.jpg"}},
+		{"COFFEE", 1, []string{"Coffee_Beans.JPEG"}},
+		{"team building", 5, []string{"team.webp"}},
+		{"space rockets", 2, []string{"Coffee_Beans.JPEG", "coffee-morning.jpg"}}, // ничего не совпало — любые фирменные
+		{"to be", 5, []string{"Coffee_Beans.JPEG", "coffee-morning.jpg", "logo.png", "team.webp"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got, err := p.Search(context.Background(), tt.query, tt.limit)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, img := range got {
+				if img.Path != filepath.Join(dir, img.ID) || img.Provider != "local" {
+					t.Errorf("картинка %+v", img)
+				}
+				names = append(names, img.ID)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("Search(%q) = %v, want %v", tt.query, names, tt.want)
+			}
+		})
+	}
+
+	if got, err := (&LocalProvider{Dir: brandDir(t)}).Search(context.Background(), "coffee", 1); err != nil || len(got) != 0 {
+		t.Errorf("пустая папка: %v, %v", got, err)
+	}
+}
+
+func TestChainFallback(t *testing.T) {
+	limited, _ := testutil.FixtureServer(t, "pexels.json", http.StatusTooManyRequests, "")
+	empty := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"results":[]}`))
+	}))
+	t.Cleanup(empty.Close)
+	local := &LocalProvider{Dir: brandDir(t, "brand.png")}
+
+	chain := Chain{
+		&PexelsProvider{BaseURL: limited.URL, APIKey: "key"},  // 429
+		&UnsplashProvider{BaseURL: empty.URL, AccessKey: "k"}, // ничего не нашёл
+		&PixabayProvider{}, // не настроен
+		local,
+	}
+	got, err := chain.Search(context.Background(), "coffee", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Provider != "local" {
+		t.Errorf("Search() = %+v, want картинку из local", got)
+	}
+	if chain.Name() != "pexels,unsplash,pixabay,local" {
+		t.Errorf("Name() = %q", chain.Name())
+	}
+
+	// никто не нашёл — ErrNoImages, причины сохраняются
+	_, err = chain[:3].Search(context.Background(), "coffee", 1)
+	if !errors.Is(err, ErrNoImages) || !errors.Is(err, ErrRateLimited) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestParseOrder(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{"unsplash, pexels, local", []string{"unsplash", "pexels", "local"}, false},
+		{"Pixabay;PEXELS pexels", []string{"pixabay", "pexels"}, false},
+		{"", nil, false},
+		{"pexels, flickr", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseOrder(tt.in)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseOrder(%q) = %v, %v; want %v, err %v", tt.in, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	t.Setenv("IMAGE_PROVIDERS", "local,pexels")
+	if got := ForOrder("").Name(); got != "local,pexels" {
+		t.Errorf("ForOrder(\"\") = %q", got)
+	}
+	if got := ForOrder("unsplash").Name(); got != "unsplash" {
+		t.Errorf("ForOrder(unsplash) = %q", got)
+	}
+	if got := ForOrder("flickr").Name(); got != "local,pexels" {
+		t.Errorf("ForOrder(flickr) = %q", got)
+	}
+}
@@ -0,0 +1,204 @@
+package images
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ---- Pexels ----
+
+type pexelsResponse struct {
+	Photos []struct {
+		ID              int64  `json:"id"`
+		URL             string `json:"url"`
+		Photographer    string `json:"photographer"`
+		PhotographerURL string `json:"photographer_url"`
+		Src             struct {
+			Large string `json:"large"`
+		} `json:"src"`
+	} `json:"photos"`
+}
+
+// PexelsProvider — https://www.pexels.com/api/
+type PexelsProvider struct {
+	BaseURL string // по умолчанию https://api.pexels.com
+	APIKey  string
+	Client  *http.Client
+}
+
+func (p *PexelsProvider) Name() string { return "pexels" }
+
+func (p *PexelsProvider) Search(ctx context.Context, query string, limit int) ([]Image, error) {
+	if p.APIKey == "" {
+		return nil, ErrNotConfigured
+	}
+	q := url.Values{}
+	q.Set("query", query)
+	q.Set("per_page", strconv.Itoa(limit))
+	u := baseURL(p.BaseURL, "https://api.pexels.com") + "/v1/search?" + q.Encode()
+
+	var data pexelsResponse
+	if err := getJSON(ctx, p.Client, u, http.Header{"Authorization": {p.APIKey}}, &data); err != nil {
+		return nil, err
+	}
+	var out []Image
+	for _, ph := range data.Photos {
+		if ph.Src.Large == "" {
+			continue
+		}
+		out = append(out, Image{
+			Provider:  p.Name(),
+			ID:        strconv.FormatInt(ph.ID, 10),
+			URL:       ph.Src.Large,
+			PageURL:   ph.URL,
+			Author:    ph.Photographer,
+			AuthorURL: ph.PhotographerURL,
+			License:   LicensePexels,
+		})
+	}
+	return out, nil
+}
+
+// ---- Unsplash ----
+
+type unsplashResponse struct {
+	Results []struct {
+		ID   string `json:"id"`
+		URLs struct {
+			Regular string `json:"regular"`
+		} `json:"urls"`
+		Links struct {
+			HTML string `json:"html"`
+		} `json:"links"`
+		User struct {
+			Name  string `json:"name"`
+			Links struct {
+				HTML string `json:"html"`
+			} `json:"links"`
+		} `json:"user"`
+	} `json:"results"`
+}
+
+// UnsplashProvider — https://unsplash.com/documentation#search-photos
+type UnsplashProvider struct {
+	BaseURL   string // по умолчанию https://api.unsplash.com
+	AccessKey string
+	Client    *http.Client
+}
+
+func (p *UnsplashProvider) Name() string { return "unsplash" }
+
+func (p *UnsplashProvider) Search(ctx context.Context, query string, limit int) ([]Image, error) {
+	if p.AccessKey == "" {
+		return nil, ErrNotConfigured
+	}
+	q := url.Values{}
+	q.Set("query", query)
+	q.Set("per_page", strconv.Itoa(limit))
+	q.Set("orientation", "landscape")
+	u := baseURL(p.BaseURL, "https://api.unsplash.com") + "/search/photos?" + q.Encode()
+
+	header := http.Header{"Authorization": {"Client-ID " + p.AccessKey}, "Accept-Version": {"v1"}}
+	var data unsplashResponse
+	if err := getJSON(ctx, p.Client, u, header, &data); err != nil {
+		return nil, err
+	}
+	var out []Image
+	for _, r := range data.Results {
+		if r.URLs.Regular == "" {
+			continue
+		}
+		out = append(out, Image{
+			Provider:  p.Name(),
+			ID:        r.ID,
+			URL:       r.URLs.Regular,
+			PageURL:   r.Links.HTML,
+			Author:    r.User.Name,
+			AuthorURL: r.User.Links.HTML,
+			License:   LicenseUnsplash,
+		})
+	}
+	return out, nil
+}
+
+// ---- Pixabay ----
+
+type pixabayResponse struct {
+	Hits []struct {
+		ID            int64  `json:"id"`
+		PageURL       string `json:"pageURL"`
+		LargeImageURL string `json:"largeImageURL"`
+		User          string `json:"user"`
+		UserID        int64  `json:"user_id"`
+	} `json:"hits"`
+}
+
+// PixabayProvider — https://pixabay.com/api/docs/
+type PixabayProvider struct {
+	BaseURL string // по умолчанию https://pixabay.com
+	APIKey  string
+	Client  *http.Client
+}
+
+func (p *PixabayProvider) Name() string { return "pixabay" }
+
+func (p *PixabayProvider) Search(ctx context.Context, query string, limit int) ([]Image, error) {
+	if p.APIKey == "" {
+		return nil, ErrNotConfigured
+	}
+	// Pixabay принимает per_page от 3 и запрос до 100 символов
+	perPage := max(limit, 3)
+	if r := []rune(query); len(r) > 100 {
+		query = string(r[:100])
+	}
+	q := url.Values{}
+	q.Set("key", p.APIKey)
+	q.Set("q", query)
+	q.Set("image_type", "photo")
+	q.Set("safesearch", "true")
+	q.Set("per_page", strconv.Itoa(perPage))
+	u := baseURL(p.BaseURL, "https://pixabay.com") + "/api/?" + q.Encode()
+
+	var data pixabayResponse
+	err := getJSON(ctx, p.Client, u, nil, &data)
+	if he, ok := err.(*HTTPError); ok && he.Status == http.StatusBadRequest && strings.Contains(he.Body, "API key") {
+		// о неверном ключе Pixabay сообщает статусом 400
+		he.Status = http.StatusUnauthorized
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []Image
+	for _, h := range data.Hits {
+		if h.LargeImageURL == "" {
+			continue
+		}
+		img := Image{
+			Provider: p.Name(),
+			ID:       strconv.FormatInt(h.ID, 10),
+			URL:      h.LargeImageURL,
+			PageURL:  h.PageURL,
+			Author:   h.User,
+			License:  LicensePixabay,
+		}
+		if h.User != "" && h.UserID != 0 {
+			img.AuthorURL = fmt.Sprintf("https://pixabay.com/users/%s-%d/", url.PathEscape(h.User), h.UserID)
+		}
+		out = append(out, img)
+		if len(out) == limit {
+			break
+		}
+	}
+	return out, nil
+}
+
+func baseURL(base, def string) string {
+	if base = strings.TrimRight(base, "/"); base != "" {
+		return base
+	}
+	return def
+}
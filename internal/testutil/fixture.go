@@ -0,0 +1,33 @@
+// Package testutil — общие помощники тестов для клиентов внешних HTTP API (TON, стоки картинок)
+package testutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// FixtureServer отдаёт testdata/<name> пакета теста по любому пути; если status не 200 —
+// отвечает этим статусом с телом body. Второе значение — последний пришедший запрос.
+func FixtureServer(t testing.TB, name string, status int, body string) (*httptest.Server, *http.Request) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var last http.Request
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		last = *r
+		if status != http.StatusOK {
+			w.WriteHeader(status)
+			w.Write([]byte(body))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &last
+}
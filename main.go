@@ -1,10 +1,15 @@
 package main
 
 import (
+	"context"
 	"github.com/joho/godotenv"
 	"log"
+	"mybot/api"
 	"mybot/sub"
 	"os"
+	"os/signal"
+	"syscall"
+	_ "time/tzdata" // часовые пояса для расписаний, даже если на сервере нет tzdata
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"mybot/autopost"
@@ -26,9 +31,8 @@ func main() {
 		log.Fatal("❌ TELEGRAM_TOKEN не найден в окружении")
 	}
 
-	groqKey := os.Getenv("GROQ_API_KEY")
-	if groqKey == "" {
-		log.Fatal("❌ GROQ_API_KEY не найден в окружении")
+	if !api.HasConfiguredProvider() {
+		log.Fatal("❌ Не настроен ни один LLM-провайдер: задайте <ИМЯ>_API_KEY или <ИМЯ>_BASE_URL для LLM_PROVIDER_CHAIN")
 	}
 
 	botAPI, err := tgbotapi.NewBotAPI(telegramToken)
@@ -48,8 +52,21 @@ func main() {
 	}
 	log.Println("✅ Миграции выполнены")
 
+	// Отменяется по SIGINT/SIGTERM: генерации и публикации прерываются, цикл обновлений завершается
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Учёт токенов и стоимости каждого вызова LLM
+	api.SetUsageRecorder(func(u api.Usage) {
+		if err := db.RecordLLMUsage(sqlDB, db.LLMUsage(u)); err != nil {
+			log.Printf("⚠️ Не удалось записать учёт LLM: %v", err)
+		}
+	})
+
 	sub.SetDB(sqlDB)
 	sub.StartTonWatcher(botAPI, sqlDB)
-	autopost.Start(botAPI, sqlDB)
-	bot.SetupHandlers(botAPI, sqlDB)
+	sub.StartExpiryReminders(botAPI, sqlDB)
+	autopost.Start(ctx, botAPI, sqlDB)
+	bot.SetupHandlers(ctx, botAPI, sqlDB)
+	log.Println("👋 Бот остановлен")
 }
@@ -0,0 +1,97 @@
+package sub
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"mybot/db"
+)
+
+// Сколько периодов можно оплатить одним переводом (защита от опечатки в сумме)
+const maxPeriodsPerPayment = 12
+
+// FormatTON — нанотоны в TON для сообщений: "12.5", "0.001"
+func FormatTON(nano *big.Int) string {
+	r := new(big.Rat).SetFrac(nano, big.NewInt(1_000_000_000))
+	s := r.FloatString(3)
+	return strings.TrimSuffix(strings.TrimRight(s, "0"), ".")
+}
+
+// PlanPriceNano — цена тарифа в нанотонах
+func PlanPriceNano(plan db.Plan) (*big.Int, error) {
+	n, ok := new(big.Int).SetString(toNano(plan.PriceTON), 10)
+	if !ok || n.Sign() <= 0 {
+		return nil, fmt.Errorf("некорректная цена тарифа %s: %q", plan.Code, plan.PriceTON)
+	}
+	return n, nil
+}
+
+// SettleBalance оплачивает периоды тарифа с баланса канала, пока хватает денег,
+// и продлевает подписку. Возвращает число оплаченных периодов.
+func SettleBalance(dbConn *sql.DB, channelID int, walletAddress string, plan db.Plan) (int, error) {
+	price, err := PlanPriceNano(plan)
+	if err != nil {
+		return 0, err
+	}
+
+	periods := 0
+	for periods < maxPeriodsPerPayment {
+		charged, err := db.ChargeChannelCredit(dbConn, int64(channelID), price, plan.ID)
+		if err != nil {
+			return periods, err
+		}
+		if !charged {
+			break
+		}
+		if err := ActivateSubscription(dbConn, channelID, walletAddress, plan); err != nil {
+			// продлить не удалось — деньги возвращаем на баланс
+			if rerr := db.RefundChannelCredit(dbConn, int64(channelID), price, plan.ID); rerr != nil {
+				log.Printf("❌ Не удалось вернуть списание каналу id=%d: %v", channelID, rerr)
+			}
+			return periods, err
+		}
+		periods++
+	}
+	return periods, nil
+}
+
+// notifyPayment пишет владельцу канала итог зачисления: продление или сколько не хватает
+func notifyPayment(bot *tgbotapi.BotAPI, dbConn *sql.DB, channel db.Channel, withAt string, plan db.Plan, paid *big.Int, periods int) {
+	var chatID int64
+	if err := dbConn.QueryRow(`SELECT chat_id FROM clients WHERE id=$1`, channel.ClientID).Scan(&chatID); err != nil || chatID == 0 {
+		return
+	}
+	balance, err := db.GetChannelBalance(dbConn, int64(channel.ID))
+	if err != nil {
+		log.Printf("⚠️ Не удалось получить баланс канала id=%d: %v", channel.ID, err)
+		balance = new(big.Int)
+	}
+
+	var text string
+	if periods > 0 {
+		until := ""
+		if ch, err := db.GetChannelByID(dbConn, channel.ID); err == nil {
+			until = " до " + ch.SubscriptionUntil.Format("02.01.06")
+		}
+		text = fmt.Sprintf("✅ Получено %s TON. Подписка «%s» для канала %s оплачена на %d дн.%s!",
+			FormatTON(paid), plan.Name, withAt, periods*plan.DurationDays, until)
+		if balance.Sign() > 0 {
+			text += fmt.Sprintf("\n💰 На балансе осталось %s TON — пойдёт в счёт следующего продления.", FormatTON(balance))
+		}
+		text += fmt.Sprintf("\n\n▶️ Для начала работы отправьте мне юзернейм канала ещё раз (например, %s).", withAt)
+	} else {
+		price, _ := PlanPriceNano(plan)
+		missing := new(big.Int)
+		if price != nil {
+			missing.Sub(price, balance)
+		}
+		text = fmt.Sprintf("💰 Получено %s TON для канала %s. На балансе %s TON, для тарифа «%s» не хватает %s TON.\n"+
+			"Доплатите с тем же комментарием — сумма сложится.",
+			FormatTON(paid), withAt, FormatTON(balance), plan.Name, FormatTON(missing))
+	}
+	_, _ = bot.Send(tgbotapi.NewMessage(chatID, text))
+}
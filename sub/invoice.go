@@ -0,0 +1,127 @@
+package sub
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mybot/db"
+)
+
+// Счета на оплату. Бот выдаёт короткий код (PAY-XXXXXXXX), который уходит в комментарий
+// к переводу — по нему воркер находит канал, тариф и сумму, набирать channel:@username не нужно.
+// Срок жизни счёта — INVOICE_TTL_HOURS (по умолчанию 24 ч).
+
+const (
+	invoicePrefix   = "PAY-"
+	invoiceCodeLen  = 8
+	invoiceAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ" // без 0/O и 1/I — 32 символа
+)
+
+var reInvoice = regexp.MustCompile(`(?i)\bpay\s*[-:]?\s*([2-9a-hj-np-z]{8})\b`)
+
+// parseInvoiceCode — код счёта из комментария (регистр и пробелы не важны)
+func parseInvoiceCode(comment string) (string, bool) {
+	m := reInvoice.FindStringSubmatch(comment)
+	if m == nil {
+		return "", false
+	}
+	return invoicePrefix + strings.ToUpper(m[1]), true
+}
+
+func newInvoiceCode() (string, error) {
+	b := make([]byte, invoiceCodeLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	for i := range b {
+		b[i] = invoiceAlphabet[int(b[i])%len(invoiceAlphabet)]
+	}
+	return invoicePrefix + string(b), nil
+}
+
+func invoiceTTL() time.Duration {
+	if h, err := strconv.Atoi(os.Getenv("INVOICE_TTL_HOURS")); err == nil && h > 0 {
+		return time.Duration(h) * time.Hour
+	}
+	return 24 * time.Hour
+}
+
+// invoiceAmount — цена тарифа за вычетом того, что уже лежит на балансе канала
+func invoiceAmount(price, balance *big.Int) *big.Int {
+	if balance.Sign() > 0 && balance.Cmp(price) < 0 {
+		return new(big.Int).Sub(price, balance)
+	}
+	return new(big.Int).Set(price)
+}
+
+// InvoiceFor — открытый счёт канала на тариф; если его нет или он истёк, выставляется новый.
+// Сумма открытого счёта пересчитывается от текущего баланса: после частичной оплаты
+// остаётся доплатить только разницу.
+func InvoiceFor(dbConn *sql.DB, channelID int, plan db.Plan) (db.Invoice, error) {
+	price, err := PlanPriceNano(plan)
+	if err != nil {
+		return db.Invoice{}, err
+	}
+	balance, err := db.GetChannelBalance(dbConn, int64(channelID))
+	if err != nil {
+		return db.Invoice{}, err
+	}
+	amount := invoiceAmount(price, balance)
+
+	inv, err := db.GetOpenInvoice(dbConn, int64(channelID), plan.ID)
+	if err == nil {
+		if inv.AmountNano.Cmp(amount) != 0 {
+			if err := db.SetInvoiceAmount(dbConn, inv.ID, amount); err != nil {
+				return inv, err
+			}
+			inv.AmountNano = amount
+		}
+		return inv, nil
+	}
+	if err != sql.ErrNoRows {
+		return inv, err
+	}
+
+	inv = db.Invoice{
+		ChannelID:  int64(channelID),
+		PlanID:     plan.ID,
+		AmountNano: amount,
+		ExpiresAt:  time.Now().Add(invoiceTTL()),
+	}
+	// коды случайные, но на совпадение всё равно проверяем
+	for range 5 {
+		if inv.Code, err = newInvoiceCode(); err != nil {
+			return inv, err
+		}
+		created, err := db.CreateInvoice(dbConn, &inv)
+		if err != nil || created {
+			return inv, err
+		}
+	}
+	return inv, fmt.Errorf("не удалось подобрать свободный код счёта")
+}
+
+// TonTransferLink — ton://transfer с суммой и комментарием (открывает любой TON-кошелёк)
+func TonTransferLink(wallet string, amountNano *big.Int, comment string) string {
+	return "ton://transfer/" + wallet + "?" + transferQuery(amountNano, comment)
+}
+
+// TonkeeperLink — то же через https, годится для inline-кнопки Telegram
+func TonkeeperLink(wallet string, amountNano *big.Int, comment string) string {
+	return "https://app.tonkeeper.com/transfer/" + wallet + "?" + transferQuery(amountNano, comment)
+}
+
+func transferQuery(amountNano *big.Int, comment string) string {
+	q := url.Values{}
+	q.Set("amount", amountNano.String())
+	q.Set("text", comment)
+	return q.Encode()
+}
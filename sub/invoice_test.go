@@ -0,0 +1,87 @@
+package sub
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestParseInvoiceCode(t *testing.T) {
+	tests := []struct {
+		comment string
+		code    string
+		ok      bool
+	}{
+		{"PAY-K7M2Q9XA", "PAY-K7M2Q9XA", true},
+		{"pay-k7m2q9xa", "PAY-K7M2Q9XA", true},
+		{"  Pay: K7M2Q9XA ", "PAY-K7M2Q9XA", true},
+		{"PAYK7M2Q9XA", "PAY-K7M2Q9XA", true},
+		{"оплата PAY-K7M2Q9XA спасибо", "PAY-K7M2Q9XA", true},
+		{"PAY-K7M2Q9XA channel:@other", "PAY-K7M2Q9XA", true}, // счёт важнее channel:@
+		{"PAY-K7M2Q9", "", false},                             // короткий код
+		{"PAY-K7M2Q9XAB", "", false},                          // длинный код
+		{"PAY-K7M2Q9X0", "", false},                           // 0 нет в алфавите
+		{"REPAY-K7M2Q9XA", "", false},                         // не отдельное слово
+		{"channel:@mychannel", "", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.comment, func(t *testing.T) {
+			code, ok := parseInvoiceCode(tt.comment)
+			if code != tt.code || ok != tt.ok {
+				t.Errorf("parseInvoiceCode(%q) = (%q, %v), want (%q, %v)", tt.comment, code, ok, tt.code, tt.ok)
+			}
+		})
+	}
+}
+
+func TestNewInvoiceCode(t *testing.T) {
+	seen := map[string]bool{}
+	for range 100 {
+		code, err := newInvoiceCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, ok := parseInvoiceCode(code); !ok || got != code {
+			t.Fatalf("код %q не распознаётся: (%q, %v)", code, got, ok)
+		}
+		if seen[code] {
+			t.Fatalf("повтор кода %q", code)
+		}
+		seen[code] = true
+	}
+}
+
+func TestInvoiceAmount(t *testing.T) {
+	price := nano("12000000000")
+	tests := []struct {
+		balance string
+		want    string
+	}{
+		{"0", "12000000000"},
+		{"5000000000", "7000000000"}, // доплата остатка
+		{"12000000000", "12000000000"},
+		{"20000000000", "12000000000"},
+		{"-1000000000", "12000000000"},
+	}
+	for _, tt := range tests {
+		if got := invoiceAmount(price, nano(tt.balance)); got.String() != tt.want {
+			t.Errorf("invoiceAmount(баланс %s) = %s, want %s", tt.balance, got, tt.want)
+		}
+	}
+	if price.String() != "12000000000" {
+		t.Errorf("цена изменилась: %s", price)
+	}
+}
+
+func TestTransferLinks(t *testing.T) {
+	const wallet = "UQA4ShIPiEIR9mTHFSNUGNCSOHQFheIC2OXyjVh22GvrgIKG"
+	amount := big.NewInt(12_500_000_000)
+	if got, want := TonTransferLink(wallet, amount, "PAY-K7M2Q9XA"),
+		"ton://transfer/"+wallet+"?amount=12500000000&text=PAY-K7M2Q9XA"; got != want {
+		t.Errorf("TonTransferLink = %q, want %q", got, want)
+	}
+	if got, want := TonkeeperLink(wallet, amount, "PAY K7M2Q9XA"),
+		"https://app.tonkeeper.com/transfer/"+wallet+"?amount=12500000000&text=PAY+K7M2Q9XA"; got != want {
+		t.Errorf("TonkeeperLink = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,123 @@
+package sub
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"mybot/db"
+)
+
+// Напоминания об окончании подписки: за 3 дня и за 1 день, затем — о начале
+// льготного периода и об окончательном отключении. Льготный период
+// SUBSCRIPTION_GRACE_HOURS (по умолчанию 0 — нет): посты ещё публикуются,
+// но продлить подписку нужно до его конца.
+
+// Виды напоминаний (subscription_reminders.kind)
+const (
+	reminder3Days  = "3d"
+	reminder1Day   = "1d"
+	reminderGrace  = "grace"
+	reminderExpire = "expired"
+)
+
+const reminderInterval = 10 * time.Minute
+
+// GracePeriod — сколько после окончания подписки посты ещё публикуются
+func GracePeriod() time.Duration {
+	if h, err := strconv.Atoi(os.Getenv("SUBSCRIPTION_GRACE_HOURS")); err == nil && h > 0 {
+		return time.Duration(h) * time.Hour
+	}
+	return 0
+}
+
+// CanPublish — подписка активна или канал ещё в льготном периоде
+func CanPublish(ch *db.Channel) bool {
+	if ch.SubscriptionUntil.IsZero() {
+		return false
+	}
+	return ch.SubscriptionUntil.Add(GracePeriod()).After(time.Now())
+}
+
+// dueReminder — какое напоминание положено сейчас ("" — никакого).
+// Пропущенные этапы не догоняем: за 12 часов до конца шлём только «1 день».
+func dueReminder(until, now time.Time, grace time.Duration) string {
+	left := until.Sub(now)
+	switch {
+	case left > 72*time.Hour:
+		return ""
+	case left > 24*time.Hour:
+		return reminder3Days
+	case left > 0:
+		return reminder1Day
+	case -left < grace:
+		return reminderGrace
+	default:
+		return reminderExpire
+	}
+}
+
+func reminderText(kind, channel string, until time.Time, grace time.Duration) string {
+	switch kind {
+	case reminder3Days:
+		return fmt.Sprintf("⏳ Подписка канала %s заканчивается %s — осталось меньше 3 дней. Продлите её, чтобы запланированные посты вышли вовремя.",
+			channel, until.Format("02.01.06 15:04"))
+	case reminder1Day:
+		return fmt.Sprintf("⏰ Подписка канала %s заканчивается %s — остался последний день. Продлите её, чтобы не пропустить публикации.",
+			channel, until.Format("02.01.06 15:04"))
+	case reminderGrace:
+		return fmt.Sprintf("⚠️ Подписка канала %s закончилась. Посты ещё публикуются до %s — продлите подписку, иначе публикации остановятся.",
+			channel, until.Add(grace).Format("02.01.06 15:04"))
+	default:
+		return fmt.Sprintf("⛔ Подписка канала %s закончилась — публикации остановлены. Продлите подписку, чтобы продолжить.", channel)
+	}
+}
+
+// StartExpiryReminders — фоновая рассылка напоминаний об окончании подписки
+func StartExpiryReminders(bot *tgbotapi.BotAPI, dbConn *sql.DB) {
+	go func() {
+		ticker := time.NewTicker(reminderInterval)
+		defer ticker.Stop()
+		for {
+			if err := sendExpiryReminders(bot, dbConn); err != nil {
+				log.Println("⚠️ Напоминания о подписке:", err)
+			}
+			<-ticker.C
+		}
+	}()
+}
+
+func sendExpiryReminders(bot *tgbotapi.BotAPI, dbConn *sql.DB) error {
+	grace := GracePeriod()
+	// давно истёкшие не трогаем — иначе после деплоя напишем всем старым клиентам
+	channels, err := db.GetExpiringChannels(dbConn, grace+24*time.Hour, 72*time.Hour)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, c := range channels {
+		kind := dueReminder(c.SubscriptionUntil, now, grace)
+		if kind == "" || c.ChatID == 0 {
+			continue
+		}
+		// сначала фиксируем, потом отправляем: лучше не дослать, чем прислать дважды
+		fresh, err := db.MarkReminderSent(dbConn, c.ChannelID, kind, c.SubscriptionUntil)
+		if err != nil {
+			log.Printf("⚠️ Не удалось записать напоминание %s для канала id=%d: %v", kind, c.ChannelID, err)
+			continue
+		}
+		if !fresh {
+			continue
+		}
+		withAt := "@" + strings.TrimPrefix(c.ChannelTitle, "@")
+		log.Printf("🔔 Напоминание %s: канал %s, подписка до %s", kind, withAt, c.SubscriptionUntil.Format(time.RFC3339))
+		sendRenewalPrompt(bot, dbConn, c.ChatID, c.ChannelTitle, reminderText(kind, withAt, c.SubscriptionUntil, grace))
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+package sub
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDueReminder(t *testing.T) {
+	now := time.Date(2025, 3, 10, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name  string
+		left  time.Duration
+		grace time.Duration
+		want  string
+	}{
+		{"больше 3 дней", 80 * time.Hour, 0, ""},
+		{"ровно 3 дня", 72 * time.Hour, 0, reminder3Days},
+		{"2 дня", 48 * time.Hour, 0, reminder3Days},
+		{"ровно сутки", 24 * time.Hour, 0, reminder1Day},
+		{"час", time.Hour, 0, reminder1Day},
+		{"истекла, льготы нет", 0, 0, reminderExpire},
+		{"истекла, идёт льгота", -time.Hour, 48 * time.Hour, reminderGrace},
+		{"льгота кончилась", -48 * time.Hour, 48 * time.Hour, reminderExpire},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := dueReminder(now.Add(tt.left), now, tt.grace); got != tt.want {
+				t.Errorf("dueReminder(%v, grace %v) = %q, want %q", tt.left, tt.grace, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package sub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+/*
+Источник входящих переводов на кошелёк бота. TON_PAYMENT_SOURCE:
+  tonapi (по умолчанию) — TonAPI v2, ключ TONAPI_KEY, адрес можно сменить через TONAPI_BASE_URL;
+  toncenter — toncenter API v2, ключ TONCENTER_API_KEY, адрес — TONCENTER_BASE_URL;
+  fake — переводы из JSON-файла TON_FAKE_TRANSFERS (для локальной проверки без сети).
+*/
+
+// TxID — место транзакции в истории кошелька: логическое время + хэш (hex).
+// lt у транзакций одного аккаунта уникально и растёт, поэтому это надёжный курсор.
+type TxID struct {
+	Lt   int64
+	Hash string
+}
+
+// Transfer — входящий перевод в нормализованном виде
+type Transfer struct {
+	Hash       string   `json:"hash"`
+	Lt         int64    `json:"lt"`
+	Utime      int64    `json:"utime"`
+	AmountNano *big.Int `json:"amount_nano"`
+	Source     string   `json:"source"`  // адрес отправителя, может быть пустым
+	Comment    string   `json:"comment"` // текстовый комментарий, может быть пустым
+}
+
+func (t Transfer) ID() TxID { return TxID{Lt: t.Lt, Hash: t.Hash} }
+
+// Page — страница истории кошелька
+type Page struct {
+	IDs       []TxID     // все транзакции страницы (и не переводы тоже), от новых к старым
+	Transfers []Transfer // входящие переводы среди них
+}
+
+// PaymentSource листает историю кошелька от новых транзакций к старым:
+// до limit транзакций строго старше before (нулевой before — с самой новой).
+// Страница короче limit — история кончилась.
+type PaymentSource interface {
+	Name() string
+	Transactions(ctx context.Context, wallet string, before TxID, limit int) (Page, error)
+}
+
+// ErrRateLimited — источник попросил подождать (HTTP 429)
+var ErrRateLimited = errors.New("payment source: rate limited")
+
+// Размер страницы при запросе истории
+const pageSize = 50
+
+// NewPaymentSource — источник по TON_PAYMENT_SOURCE
+func NewPaymentSource() (PaymentSource, error) {
+	switch name := strings.ToLower(strings.TrimSpace(os.Getenv("TON_PAYMENT_SOURCE"))); name {
+	case "", "tonapi":
+		return &TonAPISource{BaseURL: os.Getenv("TONAPI_BASE_URL"), APIKey: os.Getenv("TONAPI_KEY")}, nil
+	case "toncenter":
+		return &ToncenterSource{BaseURL: os.Getenv("TONCENTER_BASE_URL"), APIKey: os.Getenv("TONCENTER_API_KEY")}, nil
+	case "fake":
+		return LoadFakeSource(os.Getenv("TON_FAKE_TRANSFERS"))
+	default:
+		return nil, fmt.Errorf("неизвестный TON_PAYMENT_SOURCE=%q", name)
+	}
+}
+
+// FakeSource — переводы в памяти: для тестов и локального запуска
+type FakeSource struct {
+	mu        sync.Mutex
+	transfers []Transfer
+}
+
+func NewFakeSource(transfers ...Transfer) *FakeSource {
+	return &FakeSource{transfers: transfers}
+}
+
+// LoadFakeSource читает переводы из JSON-массива (amount_nano — целое число нанотонов)
+func LoadFakeSource(path string) (*FakeSource, error) {
+	if path == "" {
+		return nil, fmt.Errorf("для TON_PAYMENT_SOURCE=fake нужен TON_FAKE_TRANSFERS")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var transfers []Transfer
+	if err := json.Unmarshal(data, &transfers); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewFakeSource(transfers...), nil
+}
+
+func (f *FakeSource) Name() string { return "fake" }
+
+// Add добавляет перевод, как будто он только что пришёл
+func (f *FakeSource) Add(t Transfer) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.transfers = append(f.transfers, t)
+}
+
+func (f *FakeSource) Transactions(ctx context.Context, wallet string, before TxID, limit int) (Page, error) {
+	f.mu.Lock()
+	all := append([]Transfer(nil), f.transfers...)
+	f.mu.Unlock()
+
+	sort.Slice(all, func(i, j int) bool { return all[i].Lt > all[j].Lt })
+	var page Page
+	for _, t := range all {
+		if before.Lt != 0 && t.Lt >= before.Lt {
+			continue
+		}
+		if len(page.IDs) == limit {
+			break
+		}
+		page.IDs = append(page.IDs, t.ID())
+		page.Transfers = append(page.Transfers, t)
+	}
+	return page, nil
+}
@@ -0,0 +1,186 @@
+package sub
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"mybot/internal/testutil"
+)
+
+func nano(s string) *big.Int {
+	n, _ := new(big.Int).SetString(s, 10)
+	return n
+}
+
+func TestTonAPISourceTransfers(t *testing.T) {
+	srv, req := testutil.FixtureServer(t, "tonapi_transactions.json", http.StatusOK, "")
+	src := &TonAPISource{BaseURL: srv.URL, APIKey: "secret"}
+
+	page, err := src.Transactions(context.Background(), "UQwallet", TxID{}, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Page{
+		IDs: []TxID{{47000000000003, "a1b2c3d4e5f6"}, {47000000000002, "b2c3d4e5f6a1"}, {47000000000001, "c3d4e5f6a1b2"}},
+		Transfers: []Transfer{
+			{Hash: "a1b2c3d4e5f6", Lt: 47000000000003, Utime: 1700000300, AmountNano: nano("12000000000"),
+				Source:  "0:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+				Comment: "channel:@MyChannel plan:pro"},
+			{Hash: "b2c3d4e5f6a1", Lt: 47000000000002, Utime: 1700000200, AmountNano: nano("5500000000"),
+				Source: "UQA4ShIPiEIR9mTHFSNUGNCSOHQFheIC2OXyjVh22GvrgIKG", Comment: "channel: @other"},
+			// третья транзакция без value — не перевод, но в IDs остаётся
+		},
+	}
+	if !reflect.DeepEqual(page, want) {
+		t.Errorf("Transactions() =\n%+v\nwant\n%+v", page, want)
+	}
+	if req.URL.Path != "/v2/blockchain/accounts/UQwallet/transactions" {
+		t.Errorf("path = %q", req.URL.Path)
+	}
+	if req.URL.Query().Get("before_lt") != "" {
+		t.Errorf("before_lt без курсора = %q", req.URL.Query().Get("before_lt"))
+	}
+
+	// следующая страница: before_lt в запросе, сам курсор и всё новее отсекаются
+	page, err = src.Transactions(context.Background(), "UQwallet", TxID{47000000000002, "b2c3d4e5f6a1"}, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := req.URL.Query().Get("before_lt"); got != "47000000000002" {
+		t.Errorf("before_lt = %q", got)
+	}
+	if len(page.IDs) != 1 || page.IDs[0].Lt != 47000000000001 || len(page.Transfers) != 0 {
+		t.Errorf("страница после курсора = %+v", page)
+	}
+	if auth := req.Header.Get("Authorization"); auth != "Bearer secret" {
+		t.Errorf("Authorization = %q", auth)
+	}
+}
+
+func TestToncenterSourceTransfers(t *testing.T) {
+	srv, req := testutil.FixtureServer(t, "toncenter_transactions.json", http.StatusOK, "")
+	src := &ToncenterSource{BaseURL: srv.URL, APIKey: "secret"}
+
+	const hash1 = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+	page, err := src.Transactions(context.Background(), "UQwallet", TxID{}, 50)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Page{
+		IDs: []TxID{{47000000000003, hash1}, {47000000000002, "notbase64"}, {47000000000001, "zzz"}},
+		Transfers: []Transfer{
+			{Hash: hash1, Lt: 47000000000003, Utime: 1700000300,
+				AmountNano: nano("12500000000"), Source: "EQBvW8Z5huBkMJYdnfAEM5JqTNkuWX3diqYENkWsIL0XggGG",
+				Comment: "channel:@mychannel"},
+			// исходящий перевод и перевод с битой суммой — не платежи
+		},
+	}
+	if !reflect.DeepEqual(page, want) {
+		t.Errorf("Transactions() =\n%+v\nwant\n%+v", page, want)
+	}
+	if addr := req.URL.Query().Get("address"); addr != "UQwallet" {
+		t.Errorf("address = %q", addr)
+	}
+	if key := req.Header.Get("X-API-Key"); key != "secret" {
+		t.Errorf("X-API-Key = %q", key)
+	}
+}
+
+func TestToncenterSourcePaging(t *testing.T) {
+	// toncenter отдаёт страницу начиная с курсора включительно: он должен отсечься,
+	// а в запрос уйти lt, hash в base64 и limit на один больше
+	srv, req := testutil.FixtureServer(t, "toncenter_transactions.json", http.StatusOK, "")
+	src := &ToncenterSource{BaseURL: srv.URL}
+
+	before := TxID{Lt: 47000000000002, Hash: "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"}
+	page, err := src.Transactions(context.Background(), "UQwallet", before, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := req.URL.Query()
+	if q.Get("lt") != "47000000000002" || q.Get("hash") != "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=" || q.Get("limit") != "2" {
+		t.Errorf("query = %v", q)
+	}
+	if len(page.IDs) != 1 || page.IDs[0].Lt != 47000000000001 {
+		t.Errorf("IDs = %+v", page.IDs)
+	}
+}
+
+func TestSourceStatusErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		limited bool
+	}{
+		{"rate limited", http.StatusTooManyRequests, true},
+		{"server error", http.StatusBadGateway, false},
+		{"unauthorized", http.StatusUnauthorized, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := testutil.FixtureServer(t, "tonapi_transactions.json", tt.status, "")
+			for _, src := range []PaymentSource{
+				&TonAPISource{BaseURL: srv.URL},
+				&ToncenterSource{BaseURL: srv.URL},
+			} {
+				_, err := src.Transactions(context.Background(), "UQwallet", TxID{}, 50)
+				if err == nil {
+					t.Fatalf("%s: ожидалась ошибка", src.Name())
+				}
+				if errors.Is(err, ErrRateLimited) != tt.limited {
+					t.Errorf("%s: err = %v, rate limited = %v", src.Name(), err, tt.limited)
+				}
+			}
+		})
+	}
+}
+
+func TestFakeSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "transfers.json")
+	data := `[{"hash":"h1","lt":100,"utime":10,"amount_nano":12000000000,"comment":"channel:@x"}]`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	src, err := LoadFakeSource(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	src.Add(Transfer{Hash: "h2", Lt: 200, Utime: 20, AmountNano: big.NewInt(1)})
+
+	page, err := src.Transactions(context.Background(), "any", TxID{}, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Transfer{
+		{Hash: "h2", Lt: 200, Utime: 20, AmountNano: big.NewInt(1)},
+		{Hash: "h1", Lt: 100, Utime: 10, AmountNano: nano("12000000000"), Comment: "channel:@x"},
+	}
+	if !reflect.DeepEqual(page.Transfers, want) {
+		t.Errorf("Transfers = %+v, want %+v", page.Transfers, want)
+	}
+
+	if _, err := LoadFakeSource(""); err == nil {
+		t.Error("LoadFakeSource(\"\"): ожидалась ошибка")
+	}
+}
+
+func TestHexHash(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8=", "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"},
+		{"AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8", "AAECAwQFBgcICQoLDA0ODxAREhMUFRYXGBkaGxwdHh8"}, // без паддинга — как есть
+		{"deadbeef", "deadbeef"}, // уже hex (не 32 байта после base64)
+	}
+	for _, tt := range tests {
+		if got := hexHash(tt.in); got != tt.want {
+			t.Errorf("hexHash(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package sub
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strconv"
+	"strings"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"mybot/db"
+)
+
+// Оплата подписки в Telegram Stars: счёт через sendInvoice в валюте XTR,
+// подтверждение pre_checkout_query и продление по successful_payment.
+// Цена тарифа в звёздах — subscription_plans.price_stars.
+
+const StarsCurrency = "XTR"
+
+// starsPayload — payload счёта: канал и тариф ("sub:<channel_id>:<plan_id>")
+func starsPayload(channelID, planID int) string {
+	return fmt.Sprintf("sub:%d:%d", channelID, planID)
+}
+
+func parseStarsPayload(payload string) (channelID, planID int, ok bool) {
+	rest, ok := strings.CutPrefix(payload, "sub:")
+	if !ok {
+		return 0, 0, false
+	}
+	ch, pl, ok := strings.Cut(rest, ":")
+	if !ok {
+		return 0, 0, false
+	}
+	channelID, err1 := strconv.Atoi(ch)
+	planID, err2 := strconv.Atoi(pl)
+	if err1 != nil || err2 != nil || channelID <= 0 || planID <= 0 {
+		return 0, 0, false
+	}
+	return channelID, planID, true
+}
+
+// SendStarsInvoice — счёт в звёздах на тариф для канала клиента
+func SendStarsInvoice(bot *tgbotapi.BotAPI, dbConn *sql.DB, chatID int64, channelUsername string, plan db.Plan) {
+	user := strings.TrimPrefix(strings.TrimSpace(channelUsername), "@")
+	if plan.PriceStars <= 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ Этот тариф нельзя оплатить звёздами."))
+		return
+	}
+	channelID, err := db.GetChannelIDByUsernameAndChat(dbConn, chatID, user)
+	if err != nil {
+		log.Printf("⚠️ Канал @%s клиента %d не найден: %v", user, chatID, err)
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не найден. Отправьте юзернейм канала ещё раз."))
+		return
+	}
+
+	inv := tgbotapi.NewInvoice(chatID,
+		fmt.Sprintf("Подписка «%s»", plan.Name),
+		fmt.Sprintf("Канал @%s, %d дн.", user, plan.DurationDays),
+		starsPayload(channelID, plan.ID),
+		"", // для Stars токен провайдера не нужен
+		"",
+		StarsCurrency,
+		[]tgbotapi.LabeledPrice{{Label: plan.Name, Amount: plan.PriceStars}},
+	)
+	inv.SuggestedTipAmounts = []int{} // иначе библиотека отправит null
+	if _, err := bot.Send(inv); err != nil {
+		log.Println("⚠️ Не удалось отправить счёт в Stars:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось выставить счёт, попробуйте позже."))
+	}
+}
+
+// CheckStarsPayment — проверка перед списанием звёзд (pre_checkout_query).
+// Ошибка показывается пользователю, оплата отменяется.
+func CheckStarsPayment(dbConn *sql.DB, q *tgbotapi.PreCheckoutQuery) error {
+	channelID, planID, ok := parseStarsPayload(q.InvoicePayload)
+	if !ok || q.Currency != StarsCurrency {
+		return errors.New("Счёт устарел. Запросите оплату заново.")
+	}
+	if _, err := db.GetChannelByID(dbConn, channelID); err != nil {
+		return errors.New("Канал не найден. Отправьте юзернейм канала боту ещё раз.")
+	}
+	plan, err := db.GetPlanByID(dbConn, planID)
+	if err != nil || plan.PriceStars <= 0 {
+		return errors.New("Этот тариф больше недоступен.")
+	}
+	if q.TotalAmount != plan.PriceStars {
+		return errors.New("Цена тарифа изменилась. Запросите оплату заново.")
+	}
+	return nil
+}
+
+// ProcessStarsPayment — успешная оплата звёздами: запись в журнал платежей и продление подписки
+func ProcessStarsPayment(bot *tgbotapi.BotAPI, dbConn *sql.DB, msg *tgbotapi.Message) {
+	sp := msg.SuccessfulPayment
+	chatID := msg.Chat.ID
+	channelID, planID, ok := parseStarsPayload(sp.InvoicePayload)
+	if !ok {
+		log.Printf("❌ Оплата Stars с неизвестным payload %q (charge=%s)", sp.InvoicePayload, sp.TelegramPaymentChargeID)
+		return
+	}
+	channel, err := db.GetChannelByID(dbConn, channelID)
+	if err != nil {
+		log.Printf("❌ Оплата Stars: канал id=%d не найден (charge=%s): %v", channelID, sp.TelegramPaymentChargeID, err)
+		bot.Send(tgbotapi.NewMessage(chatID, "⚠️ Оплата получена, но канал не найден. Напишите в поддержку."))
+		return
+	}
+	plan, err := db.GetPlanByID(dbConn, planID)
+	if err != nil {
+		log.Printf("❌ Оплата Stars: тариф id=%d не найден (charge=%s): %v", planID, sp.TelegramPaymentChargeID, err)
+		bot.Send(tgbotapi.NewMessage(chatID, "⚠️ Оплата получена, но тариф не найден. Напишите в поддержку."))
+		return
+	}
+	withAt := "@" + strings.TrimPrefix(channel.ChannelTitle, "@")
+
+	var payer string
+	if msg.From != nil {
+		payer = strconv.FormatInt(msg.From.ID, 10)
+	}
+	recorded, err := db.RecordPayment(dbConn, db.Payment{
+		Provider:   db.ProviderStars,
+		ExternalID: sp.TelegramPaymentChargeID,
+		ChannelID:  int64(channelID),
+		PlanID:     plan.ID,
+		Amount:     big.NewInt(int64(sp.TotalAmount)),
+		Currency:   sp.Currency,
+		Payer:      payer,
+	})
+	if err != nil {
+		log.Println("❌ Не удалось записать оплату Stars:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "⚠️ Оплата получена, но не записалась. Напишите в поддержку."))
+		return
+	}
+	// повторная доставка того же платежа продлевает подписку, только если в прошлый раз это не удалось
+	applying, err := db.ClaimPaymentApply(dbConn, db.ProviderStars, sp.TelegramPaymentChargeID)
+	if err != nil {
+		log.Println("❌ Не удалось отметить оплату Stars:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "⚠️ Оплата получена, но не записалась. Напишите в поддержку."))
+		return
+	}
+	if !applying {
+		log.Printf("• Stars %s: skip — already processed", sp.TelegramPaymentChargeID)
+		return
+	}
+	if recorded {
+		log.Printf("⭐ Получено %d Stars за тариф %s для канала %s (charge=%s)",
+			sp.TotalAmount, plan.Code, withAt, sp.TelegramPaymentChargeID)
+	} else {
+		log.Printf("⭐ Повтор оплаты Stars %s: подписка ещё не продлена — продлеваем", sp.TelegramPaymentChargeID)
+	}
+
+	if err := ActivateSubscription(dbConn, channelID, channel.WalletAddress, plan); err != nil {
+		log.Println("❌ Не удалось активировать подписку:", err)
+		if rerr := db.ReleasePaymentApply(dbConn, db.ProviderStars, sp.TelegramPaymentChargeID); rerr != nil {
+			log.Printf("❌ Не удалось вернуть оплату Stars %s к применению: %v", sp.TelegramPaymentChargeID, rerr)
+		}
+		bot.Send(tgbotapi.NewMessage(chatID, "⚠️ Оплата получена, но подписка не продлилась. Напишите в поддержку."))
+		return
+	}
+	until := ""
+	if ch, err := db.GetChannelByID(dbConn, channelID); err == nil {
+		until = " до " + ch.SubscriptionUntil.Format("02.01.06")
+	}
+	log.Printf("✅ Подписка %s оплачена звёздами для канала %s", plan.Code, withAt)
+	bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(
+		"✅ Получено %d ⭐. Подписка «%s» для канала %s оплачена на %d дн.%s!\n\n"+
+			"▶️ Для начала работы отправьте мне юзернейм канала ещё раз (например, %s).",
+		sp.TotalAmount, plan.Name, withAt, plan.DurationDays, until, withAt)))
+}
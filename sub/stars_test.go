@@ -0,0 +1,135 @@
+package sub
+
+import (
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+
+	"mybot/db"
+)
+
+func TestParseStarsPayload(t *testing.T) {
+	tests := []struct {
+		payload string
+		channel int
+		plan    int
+		ok      bool
+	}{
+		{starsPayload(42, 2), 42, 2, true},
+		{"sub:7:1", 7, 1, true},
+		{"sub:7", 0, 0, false},
+		{"sub:0:1", 0, 0, false},
+		{"sub:7:-1", 0, 0, false},
+		{"sub:x:1", 0, 0, false},
+		{"plan:7:1", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.payload, func(t *testing.T) {
+			channel, plan, ok := parseStarsPayload(tt.payload)
+			if channel != tt.channel || plan != tt.plan || ok != tt.ok {
+				t.Errorf("parseStarsPayload(%q) = (%d, %d, %v), want (%d, %d, %v)",
+					tt.payload, channel, plan, ok, tt.channel, tt.plan, tt.ok)
+			}
+		})
+	}
+}
+
+// testBot — BotAPI, который шлёт запросы в локальный сервер; возвращает тексты отправленных сообщений
+func testBot(t *testing.T) (*tgbotapi.BotAPI, *[]string) {
+	t.Helper()
+	var (
+		mu   sync.Mutex
+		sent []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/sendMessage") {
+			mu.Lock()
+			sent = append(sent, r.FormValue("text"))
+			mu.Unlock()
+		}
+		fmt.Fprint(w, `{"ok":true,"result":{"message_id":1,"chat":{"id":1}}}`)
+	}))
+	t.Cleanup(srv.Close)
+	bot := &tgbotapi.BotAPI{Token: "test", Client: srv.Client()}
+	bot.SetAPIEndpoint(srv.URL + "/bot%s/%s")
+	return bot, &sent
+}
+
+func TestStarsPayment(t *testing.T) {
+	conn := testDB(t)
+	bot, sent := testBot(t)
+
+	plan, err := db.GetPlanByCode(conn, "basic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	channelID, suffix := testChannel(t, conn)
+	charge := fmt.Sprintf("test-charge-%d", suffix)
+	t.Cleanup(func() {
+		conn.Exec(`DELETE FROM payments WHERE provider = $1 AND external_id = $2`, db.ProviderStars, charge)
+	})
+
+	// pre_checkout: сумма должна совпасть с ценой тарифа
+	q := &tgbotapi.PreCheckoutQuery{Currency: StarsCurrency, TotalAmount: plan.PriceStars,
+		InvoicePayload: starsPayload(int(channelID), plan.ID)}
+	if err := CheckStarsPayment(conn, q); err != nil {
+		t.Errorf("CheckStarsPayment: %v", err)
+	}
+	q.TotalAmount++
+	if err := CheckStarsPayment(conn, q); err == nil {
+		t.Error("CheckStarsPayment с другой суммой: ожидалась ошибка")
+	}
+
+	// платёж уже записан, но продлить подписку в прошлый раз не удалось
+	if _, err := db.RecordPayment(conn, db.Payment{Provider: db.ProviderStars, ExternalID: charge,
+		ChannelID: channelID, PlanID: plan.ID, Amount: big.NewInt(int64(plan.PriceStars)),
+		Currency: StarsCurrency}); err != nil {
+		t.Fatal(err)
+	}
+
+	// successful_payment приходит дважды — подписка продлевается один раз
+	msg := &tgbotapi.Message{
+		Chat: &tgbotapi.Chat{ID: suffix},
+		From: &tgbotapi.User{ID: suffix},
+		SuccessfulPayment: &tgbotapi.SuccessfulPayment{
+			Currency: StarsCurrency, TotalAmount: plan.PriceStars,
+			InvoicePayload: starsPayload(int(channelID), plan.ID), TelegramPaymentChargeID: charge,
+		},
+	}
+	var until time.Time
+	for i := range 2 {
+		ProcessStarsPayment(bot, conn, msg)
+
+		var payments int
+		if err := conn.QueryRow(`SELECT COUNT(*) FROM payments WHERE provider = $1 AND external_id = $2`,
+			db.ProviderStars, charge).Scan(&payments); err != nil {
+			t.Fatal(err)
+		}
+		if payments != 1 {
+			t.Errorf("оплата %d: записей в payments %d, want 1", i, payments)
+		}
+		ch, err := db.GetChannelByID(conn, int(channelID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ch.PlanID != plan.ID || !IsSubscriptionActive(&ch) {
+			t.Fatalf("оплата %d: подписка не активна (plan=%d, until=%v)", i, ch.PlanID, ch.SubscriptionUntil)
+		}
+		if i == 0 {
+			until = ch.SubscriptionUntil
+		} else if !ch.SubscriptionUntil.Equal(until) {
+			t.Errorf("повтор оплаты продлил подписку: %v, было %v", ch.SubscriptionUntil, until)
+		}
+	}
+	if len(*sent) != 1 || !strings.HasPrefix((*sent)[0], "✅") {
+		t.Errorf("сообщения владельцу: %q, want одно подтверждение", *sent)
+	}
+}
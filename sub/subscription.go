@@ -15,9 +15,16 @@ import (
 )
 
 // ====== НАСТРОЙКИ ======
-// Меняешь цену тут одной строкой:
-const TonPaymentAmount = "12"                                              // цена в TON (можно "12.5")
-const TonWalletAddress = "UQA4ShIPiEIR9mTHFSNUGNCSOHQFheIC2OXyjVh22GvrgIKG" // адрес получателя
+// Цены, сроки и лимиты — в таблице subscription_plans. Кошелёк можно сменить через TON_WALLET_ADDRESS.
+const TonWalletAddress = "UQA4ShIPiEIR9mTHFSNUGNCSOHQFheIC2OXyjVh22GvrgIKG" // адрес получателя по умолчанию
+
+// Адрес получателя платежей
+func WalletAddress() string {
+	if w := strings.TrimSpace(os.Getenv("TON_WALLET_ADDRESS")); w != "" {
+		return w
+	}
+	return TonWalletAddress
+}
 
 // 1 TON = 1e9 нанотонов
 func toNano(dec string) string {
@@ -41,30 +48,132 @@ func toNano(dec string) string {
 	return i.String()
 }
 
-// Сообщение с оплатой: адрес, комментарий, цена + кнопка открыть @wallet
-func SendPaymentPrompt(bot *tgbotapi.BotAPI, chatID int64, channelUsername string) {
+// Сообщение с оплатой: выбор тарифа кнопками (callback "plan:<код>:<канал>")
+func SendPaymentPrompt(bot *tgbotapi.BotAPI, dbConn *sql.DB, chatID int64, channelUsername string) {
+	sendRenewalPrompt(bot, dbConn, chatID, channelUsername, "❌ У вас нет активной подписки.")
+}
+
+// sendRenewalPrompt — выбор тарифа под текстом header (отказ в доступе, напоминание о продлении)
+func sendRenewalPrompt(bot *tgbotapi.BotAPI, dbConn *sql.DB, chatID int64, channelUsername, header string) {
+	user := strings.TrimPrefix(strings.TrimSpace(channelUsername), "@")
+
+	plans, err := db.GetActivePlans(dbConn)
+	if err != nil || len(plans) == 0 {
+		log.Println("⚠️ Не удалось получить тарифы:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, header+" Оплата сейчас недоступна, попробуйте позже."))
+		return
+	}
+	if len(plans) == 1 {
+		bot.Send(tgbotapi.NewMessage(chatID, header))
+		SendPlanPayment(bot, dbConn, chatID, user, plans[0])
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(header + "\n\nВыберите тариф:\n")
+	var rows [][]tgbotapi.InlineKeyboardButton
+	for _, p := range plans {
+		b.WriteString("\n" + PlanDescription(p) + "\n")
+		row := tgbotapi.NewInlineKeyboardRow(tgbotapi.NewInlineKeyboardButtonData(
+			fmt.Sprintf("%s — %s TON", p.Name, p.PriceTON), "plan:"+p.Code+":"+user))
+		if p.PriceStars > 0 {
+			row = append(row, starsButton(p, user))
+		}
+		rows = append(rows, row)
+	}
+
+	msg := tgbotapi.NewMessage(chatID, b.String())
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
+	if _, err := bot.Send(msg); err != nil {
+		log.Println("⚠️ Не удалось отправить выбор тарифа:", err)
+	}
+}
+
+// starsButton — оплата тарифа звёздами (callback "stars:<код>:<канал>")
+func starsButton(p db.Plan, user string) tgbotapi.InlineKeyboardButton {
+	return tgbotapi.NewInlineKeyboardButtonData(fmt.Sprintf("⭐ %d Stars", p.PriceStars), "stars:"+p.Code+":"+user)
+}
+
+// PlanDescription — тариф одним абзацем: цена, срок, лимиты, функции
+func PlanDescription(p db.Plan) string {
+	gens := "без лимита"
+	if p.GenerationQuota > 0 {
+		gens = strconv.Itoa(p.GenerationQuota)
+	}
+	posts := "без лимита"
+	if p.MaxScheduledPosts > 0 {
+		posts = strconv.Itoa(p.MaxScheduledPosts)
+	}
+	price := p.PriceTON + " TON"
+	if p.PriceStars > 0 {
+		price += fmt.Sprintf(" или %d ⭐", p.PriceStars)
+	}
+	text := fmt.Sprintf("💎 %s — %s / %d дн.\n• генераций: %s\n• постов в очереди: %s",
+		p.Name, price, p.DurationDays, gens, posts)
+	if p.AllowRecurring {
+		text += "\n• регулярные посты"
+	}
+	if p.AllowCustomModel {
+		text += "\n• своя модель LLM"
+	}
+	return text
+}
+
+// SendPlanPayment — счёт на выбранный тариф: код для комментария, ссылки на перевод и кнопка @wallet
+func SendPlanPayment(bot *tgbotapi.BotAPI, dbConn *sql.DB, chatID int64, channelUsername string, plan db.Plan) {
 	user := strings.TrimPrefix(strings.TrimSpace(channelUsername), "@")
 
+	// счёт выставляем только на канал этого клиента
+	channelID, err := db.GetChannelIDByUsernameAndChat(dbConn, chatID, user)
+	if err != nil {
+		log.Printf("⚠️ Канал @%s клиента %d не найден: %v", user, chatID, err)
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ Канал не найден. Отправьте юзернейм канала ещё раз."))
+		return
+	}
+	inv, err := InvoiceFor(dbConn, channelID, plan)
+	if err != nil {
+		log.Println("⚠️ Не удалось выставить счёт:", err)
+		bot.Send(tgbotapi.NewMessage(chatID, "❌ Не удалось выставить счёт, попробуйте позже."))
+		return
+	}
+	wallet := WalletAddress()
+	tonLink := TonTransferLink(wallet, inv.AmountNano, inv.Code)
+
 	text := fmt.Sprintf(
-		"❌ У вас нет активной подписки.\n\n"+
-			"Стоимость: *%s TON*.\n"+
-			"Откройте кошелёк по кнопке ниже и отправьте перевод вручную.\n\n"+
-			"*Адрес получателя:*\n`%s`\n"+
-			"*Комментарий (обязательно):*\n`channel:@%s`\n\n"+
-			"👉 Обязательно укажите *юзернейм вашего канала* (с `@`) в поле «Комментарий получателю» "+
-			"ровно в таком виде:\n`channel:@yourchannel`\n\n"+
-			"Важно: перевод должен быть **не меньше %s TON**. "+
-			"Если сумма меньше (например, 11.9 TON), подписка *не* активируется.",
-		TonPaymentAmount,
-		TonWalletAddress,
+		"Тариф *%s*: *%s TON* на %d дн. для канала `@%s`\n"+
+			"Счёт `%s` на *%s TON* действует до %s.\n\n"+
+			"Нажмите кнопку Tonkeeper ниже — сумма и комментарий подставятся сами. "+
+			"Или откройте в любом TON-кошельке ссылку:\n`%s`\n\n"+
+			"Для перевода вручную:\n*Адрес получателя:*\n`%s`\n"+
+			"*Комментарий (обязательно):*\n`%s`\n\n"+
+			"Подписка активируется, когда на балансе канала наберётся *%s TON*: "+
+			"неполные переводы складываются, переплата идёт в счёт следующего продления (/balance).",
+		plan.Name,
+		plan.PriceTON,
+		plan.DurationDays,
 		user,
-		TonPaymentAmount,
+		inv.Code,
+		FormatTON(inv.AmountNano),
+		inv.ExpiresAt.Format("02.01.06 15:04"),
+		tonLink,
+		wallet,
+		inv.Code,
+		plan.PriceTON,
 	)
 
 	msg := tgbotapi.NewMessage(chatID, text)
 	msg.ParseMode = "Markdown"
+	// ton:// Telegram в кнопках не принимает — для кнопки https-ссылка Tonkeeper
+	btnTonkeeper := tgbotapi.NewInlineKeyboardButtonURL("💎 Оплатить в Tonkeeper", TonkeeperLink(wallet, inv.AmountNano, inv.Code))
 	btnOpen := tgbotapi.NewInlineKeyboardButtonURL("🧩 Открыть кошелёк @wallet", "https://t.me/wallet/start")
-	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(tgbotapi.NewInlineKeyboardRow(btnOpen))
+	rows := [][]tgbotapi.InlineKeyboardButton{
+		tgbotapi.NewInlineKeyboardRow(btnTonkeeper),
+		tgbotapi.NewInlineKeyboardRow(btnOpen),
+	}
+	if plan.PriceStars > 0 {
+		rows = append(rows, tgbotapi.NewInlineKeyboardRow(starsButton(plan, user)))
+	}
+	msg.ReplyMarkup = tgbotapi.NewInlineKeyboardMarkup(rows...)
 	if _, err := bot.Send(msg); err != nil {
 		log.Println("⚠️ Не удалось отправить платёжное сообщение:", err)
 	}
@@ -78,27 +187,54 @@ func IsSubscriptionActive(ch *db.Channel) bool {
 	return ch.SubscriptionUntil.After(time.Now())
 }
 
-// Активируем/продлеваем подписку (по умолчанию 30 дней; можно через SUB_DAYS)
-func ActivateSubscription(dbConn *sql.DB, channelID int, walletAddress string) error {
+// Активируем/продлеваем подписку по тарифу: срок и лимит генераций берутся из плана
+func ActivateSubscription(dbConn *sql.DB, channelID int, walletAddress string, plan db.Plan) error {
 	ch, err := db.GetChannelByID(dbConn, channelID)
 	if err != nil {
 		return err
 	}
 
-	days := 30
-	if d, err := strconv.Atoi(os.Getenv("SUB_DAYS")); err == nil && d > 0 {
-		days = d
-	}
 	now := time.Now()
 	if ch.SubscriptionUntil.After(now) {
-		ch.SubscriptionUntil = ch.SubscriptionUntil.AddDate(0, 0, days)
+		ch.SubscriptionUntil = ch.SubscriptionUntil.AddDate(0, 0, plan.DurationDays)
 	} else {
-		ch.SubscriptionUntil = now.AddDate(0, 0, days)
+		ch.SubscriptionUntil = now.AddDate(0, 0, plan.DurationDays)
 	}
 	ch.WalletAddress = walletAddress
+	ch.PlanID = plan.ID
+	// новый период — новый лимит генераций
+	ch.GenerationQuota = plan.GenerationQuota
+	ch.GenerationsUsed = 0
 	return db.UpdateChannel(dbConn, &ch)
 }
 
+// ChannelPlan — тариф канала; для каналов без тарифа — тариф по умолчанию
+func ChannelPlan(dbConn *sql.DB, ch *db.Channel) (db.Plan, error) {
+	if ch.PlanID != 0 {
+		if p, err := db.GetPlanByID(dbConn, ch.PlanID); err == nil {
+			return p, nil
+		}
+	}
+	return db.GetDefaultPlan(dbConn)
+}
+
+// Остались ли генерации в текущем периоде
+func HasGenerationsLeft(ch *db.Channel) bool {
+	return ch.GenerationQuota <= 0 || ch.GenerationsUsed < ch.GenerationQuota
+}
+
+// Строка для меню: «Генерации: 12 из 100 (до 01.10.25)»
+func QuotaStatus(ch *db.Channel) string {
+	until := ""
+	if !ch.SubscriptionUntil.IsZero() {
+		until = " (до " + ch.SubscriptionUntil.Format("02.01.06") + ")"
+	}
+	if ch.GenerationQuota <= 0 {
+		return fmt.Sprintf("⚡ Генерации: %d, без лимита%s", ch.GenerationsUsed, until)
+	}
+	return fmt.Sprintf("⚡ Генерации: %d из %d%s", ch.GenerationsUsed, ch.GenerationQuota, until)
+}
+
 // Для совместимости с вызовами в main.go
 func SetDB(_ *sql.DB) {}
 
@@ -108,7 +244,7 @@ func GuardActiveSubscription(bot *tgbotapi.BotAPI, dbConn *sql.DB, channelID int
 	if err != nil {
 		return false
 	}
-	if IsSubscriptionActive(&ch) {
+	if CanPublish(&ch) {
 		return true
 	}
 
@@ -120,3 +256,32 @@ func GuardActiveSubscription(bot *tgbotapi.BotAPI, dbConn *sql.DB, channelID int
 	}
 	return false
 }
+
+// GuardGenerationQuota списывает генерацию для фонового поста postID.
+// Лимит исчерпан — (false, nil); владельцу пишем один раз на пост, а не на каждый повтор.
+// Ошибка базы возвращается отдельно: это не исчерпанный лимит, пост стоит просто вернуть в очередь.
+func GuardGenerationQuota(bot *tgbotapi.BotAPI, dbConn *sql.DB, postID int64, channelID int, channelTitle string, clientID int) (bool, error) {
+	ok, err := db.UseGeneration(dbConn, int64(channelID))
+	if err != nil {
+		return false, fmt.Errorf("списание генерации channel_id=%d: %w", channelID, err)
+	}
+	if ok {
+		return true, nil
+	}
+
+	first, err := db.MarkPostNoticeSent(dbConn, postID, "quota")
+	if err != nil {
+		log.Printf("⚠️ Не удалось записать уведомление о лимите для поста #%d: %v", postID, err)
+	}
+	if !first {
+		return false, nil
+	}
+	var chatID int64
+	_ = dbConn.QueryRow(`SELECT chat_id FROM clients WHERE id=$1`, clientID).Scan(&chatID)
+	if chatID != 0 {
+		bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(
+			"⛔ Лимит генераций для @%s исчерпан — текст запланированного поста не сгенерирован. Лимит обновится при продлении подписки.",
+			channelTitle)))
+	}
+	return false, nil
+}
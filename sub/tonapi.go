@@ -0,0 +1,230 @@
+package sub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ---- Модели TonAPI v2 ----
+
+type TonTransaction struct {
+	Hash  string          `json:"hash"`
+	Lt    int64           `json:"lt"`
+	Utime int64           `json:"utime"`
+	InMsg json.RawMessage `json:"in_msg"` // разбираем вручную, т.к. структура «плавает»
+}
+
+type TonAPIResponse struct {
+	Transactions []TonTransaction `json:"transactions"`
+}
+
+// TonAPISource — переводы из TonAPI v2 (in_msg разбираем вручную, структура «плавает»)
+type TonAPISource struct {
+	BaseURL string // по умолчанию https://tonapi.io
+	APIKey  string
+	Client  *http.Client
+}
+
+func (s *TonAPISource) Name() string { return "tonapi" }
+
+func (s *TonAPISource) Transactions(ctx context.Context, wallet string, before TxID, limit int) (Page, error) {
+	base := strings.TrimRight(s.BaseURL, "/")
+	if base == "" {
+		base = "https://tonapi.io"
+	}
+	url := fmt.Sprintf("%s/v2/blockchain/accounts/%s/transactions?limit=%d&sort_order=desc", base, wallet, limit)
+	if before.Lt != 0 {
+		url += fmt.Sprintf("&before_lt=%d", before.Lt)
+	}
+
+	var data TonAPIResponse
+	if err := getJSON(ctx, s.Client, url, "Authorization", bearer(s.APIKey), &data); err != nil {
+		return Page{}, fmt.Errorf("tonapi: %w", err)
+	}
+	txs := data.Transactions
+	sort.SliceStable(txs, func(i, j int) bool { return txs[i].Lt > txs[j].Lt })
+
+	debug := os.Getenv("DEBUG_WATCHER") == "1"
+	var page Page
+	for _, tx := range txs {
+		if before.Lt != 0 && tx.Lt >= before.Lt {
+			continue
+		}
+		page.IDs = append(page.IDs, TxID{Lt: tx.Lt, Hash: tx.Hash})
+		t, err := tonAPITransfer(tx)
+		if err != nil {
+			if debug {
+				log.Printf("• %s: skip — %v", short(tx.Hash), err)
+			}
+			continue
+		}
+		page.Transfers = append(page.Transfers, t)
+	}
+	return page, nil
+}
+
+// tonAPITransfer достаёт comment/value/source из любых глубин in_msg
+func tonAPITransfer(tx TonTransaction) (Transfer, error) {
+	t := Transfer{Hash: tx.Hash, Lt: tx.Lt, Utime: tx.Utime}
+
+	if cRaw, ok := findFieldRaw(tx.InMsg, "comment"); ok {
+		_ = json.Unmarshal(cRaw, &t.Comment)
+	}
+	if t.Comment == "" {
+		if tRaw, ok := findFieldRaw(tx.InMsg, "text"); ok {
+			_ = json.Unmarshal(tRaw, &t.Comment)
+		}
+	}
+
+	valueRaw, ok := findFieldRaw(tx.InMsg, "value")
+	if !ok {
+		return t, fmt.Errorf("no 'value' field in in_msg")
+	}
+	val, err := parseNano(valueRaw)
+	if err != nil {
+		return t, err
+	}
+	t.AmountNano = val
+
+	if sourceRaw, ok := findFieldRaw(tx.InMsg, "source"); ok {
+		t.Source = parseAddress(sourceRaw)
+		if t.Source == "" {
+			_ = json.Unmarshal(sourceRaw, &t.Source)
+		}
+	}
+	return t, nil
+}
+
+func bearer(key string) string {
+	if key == "" {
+		return ""
+	}
+	return "Bearer " + key
+}
+
+// getJSON — GET с разбором ответа; 429 превращается в ErrRateLimited
+func getJSON(ctx context.Context, client *http.Client, url, authHeader, authValue string, out any) error {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	ctx, cancel := context.WithTimeout(ctx, 15*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("new request: %w", err)
+	}
+	if authValue != "" {
+		req.Header.Set(authHeader, authValue)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("do request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return ErrRateLimited
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("status: %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decode json: %w", err)
+	}
+	return nil
+}
+
+// ---- Утилиты для «капризного» JSON ----
+
+// рекурсивно ищем поле (без учёта регистра) и возвращаем его сырой JSON
+func findFieldRaw(raw json.RawMessage, want string) (json.RawMessage, bool) {
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err == nil {
+		for k, v := range obj {
+			if strings.EqualFold(k, want) {
+				return v, true
+			}
+			// заглянем внутрь вложенных объектов/массивов
+			if sub, ok := findFieldRaw(v, want); ok {
+				return sub, true
+			}
+			var arr []json.RawMessage
+			if err := json.Unmarshal(v, &arr); err == nil {
+				for _, it := range arr {
+					if sub, ok := findFieldRaw(it, want); ok {
+						return sub, true
+					}
+				}
+			}
+		}
+	}
+	return nil, false
+}
+
+func parseNano(raw json.RawMessage) (*big.Int, error) {
+	// строка
+	var s string
+	if err := json.Unmarshal(raw, &s); err == nil {
+		i := new(big.Int)
+		if _, ok := i.SetString(strings.TrimSpace(s), 10); ok {
+			return i, nil
+		}
+	}
+	// число (json.Number)
+	var n json.Number
+	if err := json.Unmarshal(raw, &n); err == nil {
+		i := new(big.Int)
+		if _, ok := i.SetString(n.String(), 10); ok {
+			return i, nil
+		}
+	}
+	// int64
+	var i64 int64
+	if err := json.Unmarshal(raw, &i64); err == nil {
+		return big.NewInt(i64), nil
+	}
+	return nil, fmt.Errorf("unsupported value format: %s", string(raw))
+}
+
+func parseAddress(raw json.RawMessage) string {
+	// строка
+	var s string
+	if json.Unmarshal(raw, &s) == nil && strings.TrimSpace(s) != "" {
+		return strings.TrimSpace(s)
+	}
+	// объект — попробуем типичные ключи
+	var m map[string]any
+	if json.Unmarshal(raw, &m) == nil {
+		for _, k := range []string{"address", "account", "base64", "raw", "hex"} {
+			if v, ok := m[k]; ok {
+				if str, ok := v.(string); ok && str != "" {
+					return str
+				}
+			}
+		}
+		for _, v := range m {
+			if str, ok := v.(string); ok && looksLikeTonAddress(str) {
+				return str
+			}
+		}
+	}
+	return ""
+}
+
+func looksLikeTonAddress(s string) bool {
+	s = strings.TrimSpace(s)
+	if len(s) < 20 || strings.Contains(s, " ") {
+		return false
+	}
+	return strings.HasPrefix(s, "EQ") || strings.HasPrefix(s, "UQ") || strings.Contains(s, ":")
+}
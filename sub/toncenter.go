@@ -0,0 +1,128 @@
+package sub
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ---- Модели toncenter API v2 (getTransactions) ----
+
+type toncenterResponse struct {
+	OK     bool                   `json:"ok"`
+	Error  string                 `json:"error"`
+	Result []toncenterTransaction `json:"result"`
+}
+
+type toncenterTransaction struct {
+	Utime         int64 `json:"utime"`
+	TransactionID struct {
+		Lt   string `json:"lt"`
+		Hash string `json:"hash"`
+	} `json:"transaction_id"`
+	InMsg struct {
+		Source  string `json:"source"`
+		Value   string `json:"value"`
+		Message string `json:"message"` // комментарий, уже декодированный
+	} `json:"in_msg"`
+}
+
+// ToncenterSource — переводы из toncenter API v2
+type ToncenterSource struct {
+	BaseURL string // по умолчанию https://toncenter.com
+	APIKey  string
+	Client  *http.Client
+}
+
+func (s *ToncenterSource) Name() string { return "toncenter" }
+
+func (s *ToncenterSource) Transactions(ctx context.Context, wallet string, before TxID, limit int) (Page, error) {
+	base := strings.TrimRight(s.BaseURL, "/")
+	if base == "" {
+		base = "https://toncenter.com"
+	}
+	q := url.Values{}
+	q.Set("address", wallet)
+	q.Set("archival", "true")
+	q.Set("limit", strconv.Itoa(limit))
+	if before.Lt != 0 {
+		// toncenter отдаёт историю начиная с (lt, hash) включительно — берём на одну больше
+		q.Set("limit", strconv.Itoa(limit+1))
+		q.Set("lt", strconv.FormatInt(before.Lt, 10))
+		q.Set("hash", base64Hash(before.Hash))
+	}
+	u := base + "/api/v2/getTransactions?" + q.Encode()
+
+	var data toncenterResponse
+	if err := getJSON(ctx, s.Client, u, "X-API-Key", s.APIKey, &data); err != nil {
+		return Page{}, fmt.Errorf("toncenter: %w", err)
+	}
+	if !data.OK {
+		return Page{}, fmt.Errorf("toncenter: %s", data.Error)
+	}
+
+	debug := os.Getenv("DEBUG_WATCHER") == "1"
+	var page Page
+	for _, tx := range data.Result {
+		lt, err := strconv.ParseInt(tx.TransactionID.Lt, 10, 64)
+		if err != nil {
+			return Page{}, fmt.Errorf("toncenter: bad lt=%q", tx.TransactionID.Lt)
+		}
+		if before.Lt != 0 && lt >= before.Lt {
+			continue
+		}
+		if len(page.IDs) == limit {
+			break
+		}
+		hash := hexHash(tx.TransactionID.Hash)
+		page.IDs = append(page.IDs, TxID{Lt: lt, Hash: hash})
+
+		// внешние сообщения (исходящие переводы кошелька) без отправителя — не платежи
+		if tx.InMsg.Source == "" {
+			continue
+		}
+		val, ok := new(big.Int).SetString(strings.TrimSpace(tx.InMsg.Value), 10)
+		if !ok {
+			if debug {
+				log.Printf("• %s: skip — bad value=%q", short(hash), tx.InMsg.Value)
+			}
+			continue
+		}
+		page.Transfers = append(page.Transfers, Transfer{
+			Hash:       hash,
+			Lt:         lt,
+			Utime:      tx.Utime,
+			AmountNano: val,
+			Source:     tx.InMsg.Source,
+			Comment:    tx.InMsg.Message,
+		})
+	}
+	return page, nil
+}
+
+// hexHash — toncenter отдаёт хэш в base64, TonAPI — в hex; храним в hex,
+// чтобы смена источника не обработала тот же перевод дважды
+func hexHash(h string) string {
+	for _, enc := range []*base64.Encoding{base64.StdEncoding, base64.URLEncoding} {
+		if b, err := enc.DecodeString(h); err == nil && len(b) == 32 {
+			return hex.EncodeToString(b)
+		}
+	}
+	return h
+}
+
+// base64Hash — обратно в base64 для параметра hash у toncenter
+func base64Hash(h string) string {
+	if b, err := hex.DecodeString(h); err == nil && len(b) == 32 {
+		return base64.StdEncoding.EncodeToString(b)
+	}
+	return h
+}
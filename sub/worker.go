@@ -3,13 +3,12 @@ package sub
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"math/big"
-	"net/http"
 	"os"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -18,24 +17,18 @@ import (
 	"mybot/db"
 )
 
-// ---- Модели TonAPI v2 ----
-
-type TonTransaction struct {
-	Hash  string          `json:"hash"`
-	Utime int64           `json:"utime"`
-	InMsg json.RawMessage `json:"in_msg"` // разбираем вручную, т.к. структура «плавает»
-}
-
-type TonAPIResponse struct {
-	Transactions []TonTransaction `json:"transactions"`
-}
-
 // ---- Таблицы состояния ----
 
 const watcherStateDDL = `
 CREATE TABLE IF NOT EXISTS ton_watcher_state (
   wallet TEXT PRIMARY KEY,
-  last_utime BIGINT NOT NULL DEFAULT 0
+  last_utime BIGINT NOT NULL DEFAULT 0,
+  last_lt BIGINT NOT NULL DEFAULT 0,
+  last_hash TEXT NOT NULL DEFAULT '',
+  resume_lt BIGINT NOT NULL DEFAULT 0,
+  resume_hash TEXT NOT NULL DEFAULT '',
+  head_lt BIGINT NOT NULL DEFAULT 0,
+  head_hash TEXT NOT NULL DEFAULT ''
 );`
 
 const paymentsDDL = `
@@ -48,340 +41,437 @@ CREATE TABLE IF NOT EXISTS ton_payments(
   processed_at TIMESTAMPTZ DEFAULT now()
 );`
 
-func getLastUtime(dbConn *sql.DB, wallet string) (int64, error) {
+// watcherCursor — последняя обработанная транзакция кошелька.
+// Utime остался от старого курсора: нужен только при первом запуске после обновления.
+// Resume/Head — незакрытый пропуск, если опрос не долистал до курсора: переводы
+// от Resume до Head уже разобраны, а между курсором и Resume — ещё нет.
+type watcherCursor struct {
+	TxID
+	Utime  int64
+	Resume TxID
+	Head   TxID
+}
+
+func getCursor(dbConn *sql.DB, wallet string) (watcherCursor, error) {
 	if _, err := dbConn.Exec(watcherStateDDL); err != nil {
-		return 0, err
+		return watcherCursor{}, err
 	}
 	if _, err := dbConn.Exec(paymentsDDL); err != nil {
-		return 0, err
+		return watcherCursor{}, err
 	}
-	var ut int64
-	err := dbConn.QueryRow(`SELECT last_utime FROM ton_watcher_state WHERE wallet=$1`, wallet).Scan(&ut)
+	var c watcherCursor
+	err := dbConn.QueryRow(`
+		SELECT last_lt, last_hash, last_utime, resume_lt, resume_hash, head_lt, head_hash
+		FROM ton_watcher_state WHERE wallet=$1`, wallet).
+		Scan(&c.Lt, &c.Hash, &c.Utime, &c.Resume.Lt, &c.Resume.Hash, &c.Head.Lt, &c.Head.Hash)
 	if err == sql.ErrNoRows {
-		if _, e := dbConn.Exec(`INSERT INTO ton_watcher_state(wallet,last_utime) VALUES ($1,$2)`, wallet, 0); e != nil {
-			return 0, e
+		if _, e := dbConn.Exec(`INSERT INTO ton_watcher_state(wallet) VALUES ($1)`, wallet); e != nil {
+			return c, e
 		}
-		return 0, nil
+		return c, nil
 	}
-	return ut, err
+	return c, err
 }
 
-func setLastUtime(dbConn *sql.DB, wallet string, ut int64) error {
-	_, err := dbConn.Exec(`UPDATE ton_watcher_state SET last_utime=$2 WHERE wallet=$1`, wallet, ut)
+func setCursor(dbConn *sql.DB, wallet string, c watcherCursor) error {
+	_, err := dbConn.Exec(`
+		UPDATE ton_watcher_state
+		SET last_lt=$2, last_hash=$3, last_utime=$4, resume_lt=$5, resume_hash=$6, head_lt=$7, head_hash=$8
+		WHERE wallet=$1`,
+		wallet, c.Lt, c.Hash, c.Utime, c.Resume.Lt, c.Resume.Hash, c.Head.Lt, c.Head.Hash)
 	return err
 }
 
 // ---- Старт воркера ----
 
 func StartTonWatcher(bot *tgbotapi.BotAPI, dbConn *sql.DB) {
+	source, err := NewPaymentSource()
+	if err != nil {
+		log.Println("❌ TON watcher не запущен:", err)
+		return
+	}
+	log.Printf("👛 TON watcher: источник платежей %s", source.Name())
+
 	go func() {
 		ticker := time.NewTicker(20 * time.Second)
 		defer ticker.Stop()
 		for range ticker.C {
-			if err := checkTonTransactions(bot, dbConn); err != nil {
+			if err := checkTonTransactions(bot, dbConn, source); err != nil {
 				log.Println("⚠️ TON watcher:", err)
 			}
 		}
 	}()
 }
 
-// ---- Утилиты для «капризного» JSON ----
+var reChannel = regexp.MustCompile(`(?i)channel\s*:\s*@([a-z0-9_]{3,})`)
+var rePlan = regexp.MustCompile(`(?i)plan\s*:\s*([a-z0-9_-]+)`)
+
+// parseComment — канал (в нижнем регистре, без @) и код тарифа из комментария
+// вида "channel:@username plan:pro"; регистр/пробелы не важны, plan необязателен
+func parseComment(comment string) (username, planCode string, ok bool) {
+	m := reChannel.FindStringSubmatch(comment)
+	if m == nil {
+		return "", "", false
+	}
+	if p := rePlan.FindStringSubmatch(comment); p != nil {
+		planCode = strings.ToLower(p[1])
+	}
+	return strings.ToLower(m[1]), planCode, true
+}
 
-// рекурсивно ищем поле (без учёта регистра) и возвращаем его сырой JSON
-func findFieldRaw(raw json.RawMessage, want string) (json.RawMessage, bool) {
-	var obj map[string]json.RawMessage
-	if err := json.Unmarshal(raw, &obj); err == nil {
-		for k, v := range obj {
-			if strings.EqualFold(k, want) {
-				return v, true
-			}
-			// заглянем внутрь вложенных объектов/массивов
-			if sub, ok := findFieldRaw(v, want); ok {
-				return sub, true
-			}
-			var arr []json.RawMessage
-			if err := json.Unmarshal(v, &arr); err == nil {
-				for _, it := range arr {
-					if sub, ok := findFieldRaw(it, want); ok {
-						return sub, true
-					}
-				}
-			}
-		}
+// paymentPlan — тариф из комментария (plan:pro); без кода — текущий тариф канала
+func paymentPlan(dbConn *sql.DB, planCode string, ch *db.Channel) (db.Plan, error) {
+	if planCode != "" {
+		return db.GetPlanByCode(dbConn, planCode)
 	}
-	return nil, false
+	return ChannelPlan(dbConn, ch)
 }
 
-func parseNano(raw json.RawMessage) (*big.Int, error) {
-	// строка
-	var s string
-	if err := json.Unmarshal(raw, &s); err == nil {
-		i := new(big.Int)
-		if _, ok := i.SetString(strings.TrimSpace(s), 10); ok {
-			return i, nil
-		}
+// ---- Основная логика ----
+
+func checkTonTransactions(bot *tgbotapi.BotAPI, dbConn *sql.DB, source PaymentSource) error {
+	wallet := WalletAddress()
+	debug := os.Getenv("DEBUG_WATCHER") == "1"
+
+	cur, err := getCursor(dbConn, wallet)
+	if err != nil {
+		return fmt.Errorf("getCursor: %w", err)
 	}
-	// число (json.Number)
-	var n json.Number
-	if err := json.Unmarshal(raw, &n); err == nil {
-		i := new(big.Int)
-		if _, ok := i.SetString(n.String(), 10); ok {
-			return i, nil
+
+	process := func(t Transfer) error { return processTransfer(bot, dbConn, t, debug) }
+	next, moved, err := pollTransfers(context.Background(), source, wallet, cur, watcherMaxPages(), process, debug)
+	// курсор сохраняем и при ошибке: часть переводов могла уже обработаться
+	if moved {
+		if e := setCursor(dbConn, wallet, next); e != nil {
+			return fmt.Errorf("setCursor: %w", e)
 		}
 	}
-	// int64
-	var i64 int64
-	if err := json.Unmarshal(raw, &i64); err == nil {
-		return big.NewInt(i64), nil
+	if errors.Is(err, ErrRateLimited) {
+		time.Sleep(30 * time.Second)
+		return nil
 	}
-	return nil, fmt.Errorf("unsupported value format: %s", string(raw))
+	return err
 }
 
-func parseAddress(raw json.RawMessage) string {
-	// строка
-	var s string
-	if json.Unmarshal(raw, &s) == nil && strings.TrimSpace(s) != "" {
-		return strings.TrimSpace(s)
-	}
-	// объект — попробуем типичные ключи
-	var m map[string]any
-	if json.Unmarshal(raw, &m) == nil {
-		for _, k := range []string{"address", "account", "base64", "raw", "hex"} {
-			if v, ok := m[k]; ok {
-				if str, ok := v.(string); ok && str != "" {
-					return str
-				}
-			}
+// pollTransfers — один опрос: сначала догоняем незакрытый пропуск от прошлых опросов,
+// затем разбираем новые переводы. Возвращает курсор, который надо сохранить (moved).
+func pollTransfers(ctx context.Context, source PaymentSource, wallet string, cur watcherCursor, maxPages int,
+	process func(Transfer) error, debug bool) (next watcherCursor, moved bool, err error) {
+	next = cur
+	if cur.Resume.Lt != 0 {
+		n, ok, err := pollPass(ctx, source, wallet, cur, maxPages, process, debug)
+		if ok {
+			next, moved = n, true
 		}
-		for _, v := range m {
-			if str, ok := v.(string); ok && looksLikeTonAddress(str) {
-				return str
-			}
+		if err != nil || next.Resume.Lt != 0 {
+			return next, moved, err
 		}
+		cur = next
 	}
-	return ""
-}
-
-func looksLikeTonAddress(s string) bool {
-	s = strings.TrimSpace(s)
-	if len(s) < 20 || strings.Contains(s, " ") {
-		return false
+	n, ok, err := pollPass(ctx, source, wallet, cur, maxPages, process, debug)
+	if ok {
+		next, moved = n, true
 	}
-	return strings.HasPrefix(s, "EQ") || strings.HasPrefix(s, "UQ") || strings.Contains(s, ":")
+	return next, moved, err
 }
 
-var reChannel = regexp.MustCompile(`(?i)channel\s*:\s*@([a-z0-9_]{3,})`)
-
-// ---- Основная логика ----
-
-func checkTonTransactions(bot *tgbotapi.BotAPI, dbConn *sql.DB) error {
-	apiKey := os.Getenv("TONAPI_KEY")
-	wallet := TonWalletAddress
-	debug := os.Getenv("DEBUG_WATCHER") == "1"
-
-	lastU, err := getLastUtime(dbConn, wallet)
+// pollPass — один проход по истории: от Resume вниз до курсора, если есть пропуск,
+// иначе от новейшей транзакции
+func pollPass(ctx context.Context, source PaymentSource, wallet string, cur watcherCursor, maxPages int,
+	process func(Transfer) error, debug bool) (watcherCursor, bool, error) {
+	res, err := collectNewTransfers(ctx, source, wallet, cur.Resume, cur, maxPages)
 	if err != nil {
-		return fmt.Errorf("getLastUtime: %w", err)
+		return cur, false, err
 	}
-
-	url := fmt.Sprintf("https://tonapi.io/v2/blockchain/accounts/%s/transactions?limit=50", wallet)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-	if err != nil {
-		return fmt.Errorf("new request: %w", err)
+	if debug {
+		log.Printf("🔎 watcher: %s — %d стр., новых переводов %d, курсор lt=%d, пропуск с lt=%d",
+			source.Name(), res.Pages, len(res.Transfers), cur.Lt, cur.Resume.Lt)
 	}
-	if apiKey != "" {
-		req.Header.Set("Authorization", "Bearer "+apiKey)
+	if res.Mismatch {
+		log.Printf("⚠️ TON watcher: транзакция курсора lt=%d hash=%s не найдена как была — история кошелька изменилась, перепроверяем", cur.Lt, short(cur.Hash))
 	}
-
-	resp, err := http.DefaultClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("do request: %w", err)
+	if !res.Complete {
+		log.Printf("⚠️ TON watcher: за опрос не дошли до курсора lt=%d (лимит %d стр.) — переводы старше lt=%d разберём на следующем опросе",
+			cur.Lt, res.Pages, res.Oldest.Lt)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode == 429 {
-		time.Sleep(30 * time.Second)
-		return nil
+	// на первом сбое (база недоступна) останавливаемся: перевод и всё после него
+	// разберём на следующем опросе, уже зачисленные отсечёт ton_payments
+	processed := 0
+	var failed error
+	for _, t := range res.Transfers {
+		if err := process(t); err != nil {
+			failed = fmt.Errorf("перевод %s: %w", short(t.Hash), err)
+			break
+		}
+		processed++
 	}
-	if resp.StatusCode >= 500 {
-		time.Sleep(10 * time.Second)
-		return fmt.Errorf("tonapi status: %d", resp.StatusCode)
+
+	next, moved := nextCursor(cur, res, processed, failed != nil)
+	return next, moved, failed
+}
+
+// nextCursor — куда сдвинуть курсор после обработки первых processed переводов из res.
+// Если на переводе случился сбой, курсор встаёт на последний успешно обработанный перевод
+// (или остаётся на месте), чтобы неудачный перевод попал в следующий опрос. Если до курсора
+// не долистали, сам курсор не двигается: запоминаем пропуск, и следующий опрос продолжит с Resume.
+func nextCursor(cur watcherCursor, res newTransfers, processed int, failed bool) (watcherCursor, bool) {
+	next := cur
+	var last TxID
+	if processed > 0 {
+		last = res.Transfers[processed-1].ID()
 	}
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("tonapi status: %d", resp.StatusCode)
+	for _, t := range res.Transfers[:processed] {
+		if t.Utime > next.Utime {
+			next.Utime = t.Utime
+		}
 	}
 
-	var data TonAPIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return fmt.Errorf("decode json: %w", err)
+	gap := cur.Resume.Lt != 0
+	switch {
+	case gap && res.Complete && !failed:
+		// пропуск закрыт — всё до Head разобрано
+		next.TxID, next.Resume, next.Head = cur.Head, TxID{}, TxID{}
+	case gap && !res.Complete && !failed:
+		next.Resume = res.Oldest
+	case gap && res.Complete && processed > 0:
+		next.TxID = last
+	case gap:
+		// сбой посреди незаконченного пропуска: пролистаем его ещё раз, повторы отсечёт ton_payments
+	case res.Complete && !failed:
+		if res.Newest.Lt != 0 {
+			next.TxID = res.Newest
+		}
+	case res.Complete && processed > 0:
+		next.TxID = last
+	case !res.Complete && !failed:
+		next.Resume, next.Head = res.Oldest, res.Newest
+	case !res.Complete && processed > 0:
+		next.Resume, next.Head = res.Oldest, last
 	}
-	if debug {
-		log.Printf("🔎 watcher: fetched %d tx, last_utime=%d", len(data.Transactions), lastU)
-	}
-	if len(data.Transactions) == 0 {
-		return nil
+	if next == cur {
+		return cur, false
 	}
+	return next, true
+}
 
-	requiredNano := new(big.Int)
-	if _, ok := requiredNano.SetString(toNano(TonPaymentAmount), 10); !ok {
-		return fmt.Errorf("incorrect TonPaymentAmount=%s", TonPaymentAmount)
+// Сколько страниц истории можно пролистать за один опрос (TON_WATCHER_MAX_PAGES)
+func watcherMaxPages() int {
+	n := 20
+	if v, err := strconv.Atoi(os.Getenv("TON_WATCHER_MAX_PAGES")); err == nil && v > 0 {
+		n = v
 	}
+	return n
+}
 
-	var maxU int64 = lastU
-	for _, tx := range data.Transactions {
-		if tx.Utime <= lastU {
-			continue
-		}
-		if tx.Utime > maxU {
-			maxU = tx.Utime
-		}
+// newTransfers — итог листания истории до курсора
+type newTransfers struct {
+	Transfers []Transfer // новые переводы, от старых к новым
+	Newest    TxID       // самая новая транзакция — следующий курсор
+	Oldest    TxID       // самая старая просмотренная транзакция
+	Pages     int
+	Complete  bool // дошли до курсора (или до начала истории)
+	Mismatch  bool // на месте курсора другая транзакция или её нет
+}
 
-		// --- Достаём comment/value/source из любых глубин in_msg ---
-		comment := ""
-		if cRaw, ok := findFieldRaw(tx.InMsg, "comment"); ok {
-			_ = json.Unmarshal(cRaw, &comment)
+// collectNewTransfers листает историю назад страницами, начиная со следующей после from
+// (пустой from — с самой новой), пока не дойдёт до курсора. Без курсора (первый запуск) берём только первую страницу, как раньше; если есть
+// только старый курсор по utime, им и отсекаем уже обработанное.
+func collectNewTransfers(ctx context.Context, source PaymentSource, wallet string, from TxID, cur watcherCursor, maxPages int) (newTransfers, error) {
+	var res newTransfers
+	before := from
+	for res.Pages < maxPages {
+		page, err := source.Transactions(ctx, wallet, before, pageSize)
+		if err != nil {
+			return res, err
 		}
-		if comment == "" {
-			if tRaw, ok := findFieldRaw(tx.InMsg, "text"); ok {
-				_ = json.Unmarshal(tRaw, &comment)
-			}
+		res.Pages++
+		if len(page.IDs) == 0 {
+			res.Complete = true
+			break
+		}
+		if res.Newest.Lt == 0 {
+			res.Newest = page.IDs[0]
 		}
-		valueRaw, vOK := findFieldRaw(tx.InMsg, "value")
-		sourceRaw, sOK := findFieldRaw(tx.InMsg, "source")
+		res.Oldest = page.IDs[len(page.IDs)-1]
 
-		if comment == "" {
-			if debug {
-				log.Printf("• %s: skip — empty comment (in_msg doesn’t expose text)", short(tx.Hash))
+		reached := false
+		for _, id := range page.IDs {
+			if cur.Lt == 0 || id.Lt > cur.Lt {
+				continue
+			}
+			reached = true
+			if id.Lt < cur.Lt || id.Hash != cur.Hash {
+				res.Mismatch = true
 			}
-			continue
+			break
 		}
 
-		// channel:@username (регистр/пробелы не важны)
-		m := reChannel.FindStringSubmatch(comment)
-		if m == nil {
-			if debug {
-				log.Printf("• %s: skip — no channel tag in comment=%q", short(tx.Hash), comment)
+		for _, t := range page.Transfers {
+			switch {
+			case cur.Lt != 0 && t.Lt < cur.Lt:
+				continue
+			case cur.Lt != 0 && t.Lt == cur.Lt && t.Hash == cur.Hash:
+				continue // уже обработан; при несовпадении хэша проверим ещё раз (повтор отсечёт ton_payments)
+			case cur.Lt == 0 && cur.Utime != 0 && t.Utime <= cur.Utime:
+				continue
 			}
-			continue
+			res.Transfers = append(res.Transfers, t)
 		}
-		username := strings.ToLower(m[1])
-		withAt := "@" + username
 
-		// находим канал в БД
-		channelID, err := db.GetChannelIDByUsername(dbConn, username)
-		if err != nil {
-			channelID, err = db.GetChannelIDByUsername(dbConn, withAt)
+		if reached || cur.Lt == 0 || len(page.IDs) < pageSize {
+			res.Complete = true
+			break
 		}
-		if err != nil {
-			if debug {
-				log.Printf("• %s: skip — channel %q not found", short(tx.Hash), withAt)
-			}
-			continue
-		}
-		channel, err := db.GetChannelByID(dbConn, channelID)
-		if err != nil {
-			log.Println("❌ Ошибка получения канала:", err)
-			continue
+		before = res.Oldest
+	}
+
+	// обрабатываем в хронологическом порядке: неполные платежи складываются по очереди
+	sort.SliceStable(res.Transfers, func(i, j int) bool { return res.Transfers[i].Lt < res.Transfers[j].Lt })
+	return res, nil
+}
+
+// processTransfer зачисляет один перевод на баланс канала из комментария и продлевает подписку.
+// Ошибка — только временный сбой (база), после которого перевод надо разобрать ещё раз;
+// переводы, которые зачислить нельзя (нет комментария, канала, тарифа), пропускаются без ошибки.
+func processTransfer(bot *tgbotapi.BotAPI, dbConn *sql.DB, t Transfer, debug bool) error {
+	if t.Comment == "" {
+		if debug {
+			log.Printf("• %s: skip — empty comment (in_msg doesn’t expose text)", short(t.Hash))
 		}
+		return nil
+	}
 
-		// сумма
-		if !vOK {
-			if debug {
-				log.Printf("• %s: skip — no 'value' field in in_msg", short(tx.Hash))
-			}
-			continue
+	// счёт PAY-XXXXXXXX из комментария; старые переводы — по channel:@username [plan:<код>]
+	var (
+		invoice   db.Invoice
+		channelID int
+		planCode  string
+		err       error
+	)
+	if code, ok := parseInvoiceCode(t.Comment); ok {
+		invoice, err = db.GetInvoiceByCode(dbConn, code)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("счёт %s: %w", code, err)
 		}
-		val, err := parseNano(valueRaw)
 		if err != nil {
+			// похожий на код фрагмент мог попасть в обычный комментарий — пробуем channel:@
 			if debug {
-				log.Printf("• %s: skip — bad value=%s", short(tx.Hash), string(valueRaw))
+				log.Printf("• %s: invoice %s not found, trying channel tag", short(t.Hash), code)
 			}
-			continue
+			invoice = db.Invoice{}
+		} else if invoice.Expired(time.Unix(t.Utime, 0)) {
+			// деньги всё равно зачисляем каналу из счёта — по коду он однозначен
+			log.Printf("⚠️ Перевод %s по истёкшему счёту %s", short(t.Hash), invoice.Code)
 		}
-		if val.Cmp(requiredNano) < 0 {
-			// уведомим владельца
-			var chatID int64
-			_ = dbConn.QueryRow(`SELECT chat_id FROM clients WHERE id=$1`, channel.ClientID).Scan(&chatID)
-			if chatID != 0 {
-				need, _ := new(big.Rat).SetString(TonPaymentAmount)
-				got := new(big.Rat).SetInt(val)
-				got.Quo(got, new(big.Rat).SetInt64(1_000_000_000))
-				msg := tgbotapi.NewMessage(chatID, fmt.Sprintf(
-					"⚠️ Недостаточная сумма для канала %s: пришло ~%s TON, нужно ≥ %s TON.\nКомментарий: %q",
-					withAt, got.FloatString(3), need.FloatString(3), comment))
-				_, _ = bot.Send(msg)
-			}
+		channelID = int(invoice.ChannelID)
+	}
+	if invoice.ID == 0 {
+		username, pc, ok := parseComment(t.Comment)
+		if !ok {
 			if debug {
-				log.Printf("• %s: skip — amount too small", short(tx.Hash))
+				log.Printf("• %s: skip — no invoice code or channel tag in comment=%q", short(t.Hash), t.Comment)
 			}
-			continue
+			return nil
 		}
-
-		// идемпотентная фиксация
-		sourceStr := ""
-		if sOK {
-			sourceStr = parseAddress(sourceRaw)
-			if sourceStr == "" {
-				_ = json.Unmarshal(sourceRaw, &sourceStr)
-			}
+		channelID, err = db.GetChannelIDByUsername(dbConn, username)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("канал @%s: %w", username, err)
 		}
-		res, err := dbConn.Exec(
-			`INSERT INTO ton_payments(hash, utime, value, source, comment)
-			 VALUES ($1,$2,$3,$4,$5) ON CONFLICT DO NOTHING`,
-			tx.Hash, tx.Utime, string(valueRaw), sourceStr, comment,
-		)
 		if err != nil {
-			log.Println("pay insert err:", err)
-			continue
-		}
-		if rows, _ := res.RowsAffected(); rows == 0 {
 			if debug {
-				log.Printf("• %s: skip — already processed", short(tx.Hash))
+				log.Printf("• %s: skip — channel @%s not found", short(t.Hash), username)
 			}
-			continue
+			return nil
 		}
-
-		// уже активна?
-		if IsSubscriptionActive(&channel) {
-			if debug {
-				log.Printf("• %s: skip — already active for %s", short(tx.Hash), withAt)
-			}
-			continue
+		planCode = pc
+	}
+	channel, err := db.GetChannelByID(dbConn, channelID)
+	if err == sql.ErrNoRows {
+		log.Printf("⚠️ Перевод %s: канал id=%d удалён", short(t.Hash), channelID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("канал id=%d: %w", channelID, err)
+	}
+	withAt := "@" + strings.TrimPrefix(channel.ChannelTitle, "@")
+
+	// тариф: из счёта, иначе plan:<код> в комментарии, иначе текущий тариф канала
+	var plan db.Plan
+	if invoice.ID != 0 {
+		plan, err = db.GetPlanByID(dbConn, invoice.PlanID)
+	} else {
+		plan, err = paymentPlan(dbConn, planCode, &channel)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		return fmt.Errorf("тариф: %w", err)
+	}
+	if err != nil {
+		var chatID int64
+		_ = dbConn.QueryRow(`SELECT chat_id FROM clients WHERE id=$1`, channel.ClientID).Scan(&chatID)
+		if chatID != 0 {
+			_, _ = bot.Send(tgbotapi.NewMessage(chatID, fmt.Sprintf(
+				"⚠️ Платёж для канала %s: не удалось определить тариф по комментарию %q. Напишите в поддержку.",
+				withAt, t.Comment)))
 		}
-
-		if sourceStr == "" {
-			sourceStr = "(unknown)"
+		if debug {
+			log.Printf("• %s: skip — unknown plan in comment=%q: %v", short(t.Hash), t.Comment, err)
 		}
-		if err := ActivateSubscription(dbConn, channelID, sourceStr); err != nil {
-			log.Println("❌ Не удалось активировать подписку:", err)
-			continue
+		return nil
+	}
+
+	if t.AmountNano == nil || t.AmountNano.Sign() <= 0 {
+		if debug {
+			log.Printf("• %s: skip — bad value=%v", short(t.Hash), t.AmountNano)
 		}
-		log.Printf("✅ Подписка активирована для канала %s (tx=%s, кошелёк: %s)", withAt, short(tx.Hash), sourceStr)
+		return nil
+	}
 
-		// уведомим владельца
-		var chatID int64
-		if err := dbConn.QueryRow(`SELECT chat_id FROM clients WHERE id=$1`, channel.ClientID).Scan(&chatID); err == nil && chatID != 0 {
-			days := 30
-			if d, err := strconv.Atoi(os.Getenv("SUB_DAYS")); err == nil && d > 0 {
-				days = d
-			}
-			_, _ = bot.Send(tgbotapi.NewMessage(chatID,
-				fmt.Sprintf("✅ Подписка для канала %s активирована на %d дней!\n\n"+
-					"▶️ Для начала работы отправьте мне юзернейм канала ещё раз (например, %s).",
-					withAt, days, withAt)))
+	// идемпотентная фиксация + зачисление на баланс канала
+	credited, err := db.CreditTonPayment(dbConn, db.TonPayment{
+		Hash:      t.Hash,
+		Utime:     t.Utime,
+		Value:     t.AmountNano.String(),
+		Source:    t.Source,
+		Comment:   t.Comment,
+		InvoiceID: invoice.ID,
+		PlanID:    plan.ID,
+	}, int64(channelID), t.AmountNano)
+	if err != nil {
+		return fmt.Errorf("зачисление: %w", err)
+	}
+	if credited {
+		log.Printf("💰 Зачислено %s TON на баланс канала %s (tx=%s)", FormatTON(t.AmountNano), withAt, short(t.Hash))
+	}
 
+	// оплачиваем периоды с баланса: неполные платежи копятся, переплата переходит дальше,
+	// а при активной подписке срок продлевается. Для уже зачисленного перевода это повтор
+	// после сбоя: если баланса на период не хватает, ничего не спишется
+	sourceStr := t.Source
+	if sourceStr == "" {
+		sourceStr = "(unknown)"
+	}
+	periods, err := SettleBalance(dbConn, channelID, sourceStr, plan)
+	if err != nil {
+		// деньги уже на балансе — курсор останется перед переводом, повтор зачисления отсечёт ton_payments
+		return fmt.Errorf("оплата периодов: %w", err)
+	}
+	if !credited && periods == 0 {
+		if debug {
+			log.Printf("• %s: skip — already processed", short(t.Hash))
 		}
+		return nil
 	}
-
-	if maxU > lastU {
-		if err := setLastUtime(dbConn, wallet, maxU); err != nil {
-			return fmt.Errorf("setLastUtime: %w", err)
+	if periods > 0 && invoice.ID != 0 {
+		if err := db.MarkInvoicePaid(dbConn, invoice.ID); err != nil {
+			log.Printf("⚠️ Не удалось закрыть счёт %s: %v", invoice.Code, err)
 		}
 	}
+	if periods > 0 {
+		log.Printf("✅ Подписка %s оплачена для канала %s на %d период(а) (tx=%s, кошелёк: %s)",
+			plan.Code, withAt, periods, short(t.Hash), sourceStr)
+	}
+	notifyPayment(bot, dbConn, channel, withAt, plan, t.AmountNano, periods)
 	return nil
 }
 
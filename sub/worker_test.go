@@ -0,0 +1,373 @@
+package sub
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+
+	"mybot/db"
+)
+
+func TestParseNano(t *testing.T) {
+	tests := []struct {
+		raw     string
+		want    string
+		wantErr bool
+	}{
+		{`"12000000000"`, "12000000000", false},
+		{`" 5 "`, "5", false},
+		{`12000000000`, "12000000000", false},
+		{`123456789012345678901234567890`, "123456789012345678901234567890", false},
+		{`0`, "0", false},
+		{`"12.5"`, "", true},
+		{`"abc"`, "", true},
+		{`null`, "0", false}, // null превращается в 0 и отсекается дальше как «нет суммы»
+		{`{"value":1}`, "", true},
+		{`true`, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.raw, func(t *testing.T) {
+			got, err := parseNano(json.RawMessage(tt.raw))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseNano(%s) = %v, ожидалась ошибка", tt.raw, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseNano(%s): %v", tt.raw, err)
+			}
+			if got.String() != tt.want {
+				t.Errorf("parseNano(%s) = %s, want %s", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+	const friendly = "UQA4ShIPiEIR9mTHFSNUGNCSOHQFheIC2OXyjVh22GvrgIKG"
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{"строка", `"` + friendly + `"`, friendly},
+		{"строка с пробелами", `"  ` + friendly + ` "`, friendly},
+		{"объект address", `{"address":"0:abc","is_scam":false}`, "0:abc"},
+		{"приоритет address над raw", `{"raw":"0:raw","address":"0:addr"}`, "0:addr"},
+		{"объект raw", `{"raw":"0:abc"}`, "0:abc"},
+		{"похожее на адрес поле", `{"name":"wallet","friendly":"` + friendly + `"}`, friendly},
+		{"пустая строка", `""`, ""},
+		{"нет адреса", `{"name":"wallet"}`, ""},
+		{"число", `42`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseAddress(json.RawMessage(tt.raw)); got != tt.want {
+				t.Errorf("parseAddress(%s) = %q, want %q", tt.raw, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseComment(t *testing.T) {
+	tests := []struct {
+		comment  string
+		username string
+		plan     string
+		ok       bool
+	}{
+		{"channel:@mychannel", "mychannel", "", true},
+		{"channel:@MyChannel plan:Pro", "mychannel", "pro", true},
+		{"  Channel : @my_channel   plan : basic ", "my_channel", "basic", true},
+		{"оплата channel:@abc123 спасибо", "abc123", "", true},
+		{"plan:pro channel:@abc", "abc", "pro", true},
+		{"channel:@ab", "", "", false}, // слишком короткий username
+		{"channel:mychannel", "", "", false},
+		{"@mychannel", "", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.comment, func(t *testing.T) {
+			username, plan, ok := parseComment(tt.comment)
+			if username != tt.username || plan != tt.plan || ok != tt.ok {
+				t.Errorf("parseComment(%q) = (%q, %q, %v), want (%q, %q, %v)",
+					tt.comment, username, plan, ok, tt.username, tt.plan, tt.ok)
+			}
+		})
+	}
+}
+
+func TestFormatTON(t *testing.T) {
+	tests := []struct {
+		nano string
+		want string
+	}{
+		{"12000000000", "12"},
+		{"12500000000", "12.5"},
+		{"1000000", "0.001"},
+		{"0", "0"},
+		{"-12000000000", "-12"},
+	}
+	for _, tt := range tests {
+		if got := FormatTON(nano(tt.nano)); got != tt.want {
+			t.Errorf("FormatTON(%s) = %q, want %q", tt.nano, got, tt.want)
+		}
+	}
+}
+
+// history — fake-история из n переводов с lt 1..n (хэш "h<lt>", utime = lt*10)
+func history(n int) *FakeSource {
+	src := NewFakeSource()
+	for lt := 1; lt <= n; lt++ {
+		src.Add(Transfer{Hash: fmt.Sprintf("h%d", lt), Lt: int64(lt), Utime: int64(lt) * 10, AmountNano: big.NewInt(1)})
+	}
+	return src
+}
+
+func TestCollectNewTransfers(t *testing.T) {
+	tests := []struct {
+		name     string
+		total    int
+		from     TxID
+		cur      watcherCursor
+		maxPages int
+		wantLts  [2]int64 // первый и последний lt среди новых (0 — новых нет)
+		wantN    int
+		pages    int
+		complete bool
+		mismatch bool
+	}{
+		{"первый запуск — только первая страница", 120, TxID{}, watcherCursor{}, 20, [2]int64{71, 120}, 50, 1, true, false},
+		{"курсор на первой странице", 120, TxID{}, watcherCursor{TxID: TxID{110, "h110"}}, 20, [2]int64{111, 120}, 10, 1, true, false},
+		{"больше страницы с прошлого опроса", 120, TxID{}, watcherCursor{TxID: TxID{5, "h5"}}, 20, [2]int64{6, 120}, 115, 3, true, false},
+		{"курсор на границе страницы", 100, TxID{}, watcherCursor{TxID: TxID{50, "h50"}}, 20, [2]int64{51, 100}, 50, 2, true, false},
+		{"ничего нового", 30, TxID{}, watcherCursor{TxID: TxID{30, "h30"}}, 20, [2]int64{0, 0}, 0, 1, true, false},
+		{"хэш курсора не совпал — перепроверяем его", 60, TxID{}, watcherCursor{TxID: TxID{40, "old"}}, 20, [2]int64{40, 60}, 21, 1, true, true},
+		{"транзакции курсора нет", 60, TxID{}, watcherCursor{TxID: TxID{1000, "h1000"}}, 20, [2]int64{0, 0}, 0, 1, true, true},
+		{"старый курсор по utime", 80, TxID{}, watcherCursor{Utime: 700}, 20, [2]int64{71, 80}, 10, 1, true, false},
+		{"лимит страниц", 300, TxID{}, watcherCursor{TxID: TxID{10, "h10"}}, 2, [2]int64{201, 300}, 100, 2, false, false},
+		{"продолжение пропуска с resume", 300, TxID{201, "h201"}, watcherCursor{TxID: TxID{10, "h10"}}, 2, [2]int64{101, 200}, 100, 2, false, false},
+		{"пропуск закрывается", 300, TxID{101, "h101"}, watcherCursor{TxID: TxID{10, "h10"}}, 2, [2]int64{11, 100}, 90, 2, true, false},
+		{"пустой кошелёк", 0, TxID{}, watcherCursor{}, 20, [2]int64{0, 0}, 0, 1, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := collectNewTransfers(context.Background(), history(tt.total), "w", tt.from, tt.cur, tt.maxPages)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(res.Transfers) != tt.wantN {
+				t.Fatalf("новых переводов %d, want %d", len(res.Transfers), tt.wantN)
+			}
+			if tt.wantN > 0 {
+				first, last := res.Transfers[0].Lt, res.Transfers[len(res.Transfers)-1].Lt
+				if first != tt.wantLts[0] || last != tt.wantLts[1] {
+					t.Errorf("lt новых: %d..%d, want %d..%d", first, last, tt.wantLts[0], tt.wantLts[1])
+				}
+				for i := 1; i < len(res.Transfers); i++ {
+					if res.Transfers[i].Lt <= res.Transfers[i-1].Lt {
+						t.Fatalf("переводы не по возрастанию lt: %d после %d", res.Transfers[i].Lt, res.Transfers[i-1].Lt)
+					}
+				}
+			}
+			if res.Pages != tt.pages || res.Complete != tt.complete || res.Mismatch != tt.mismatch {
+				t.Errorf("pages=%d complete=%v mismatch=%v, want %d %v %v",
+					res.Pages, res.Complete, res.Mismatch, tt.pages, tt.complete, tt.mismatch)
+			}
+			if tt.total > 0 && tt.from.Lt == 0 && res.Newest != (TxID{int64(tt.total), fmt.Sprintf("h%d", tt.total)}) {
+				t.Errorf("Newest = %+v", res.Newest)
+			}
+		})
+	}
+}
+
+func TestNextCursor(t *testing.T) {
+	res := newTransfers{
+		Transfers: []Transfer{{Hash: "h11", Lt: 11, Utime: 110}, {Hash: "h15", Lt: 15, Utime: 150}, {Hash: "h18", Lt: 18, Utime: 180}},
+		Newest:    TxID{20, "h20"},
+		Oldest:    TxID{11, "h11"},
+		Complete:  true,
+	}
+	partial := res
+	partial.Complete = false
+	cur := watcherCursor{TxID: TxID{10, "h10"}, Utime: 100}
+	gap := watcherCursor{TxID: TxID{5, "h5"}, Utime: 100, Resume: TxID{30, "h30"}, Head: TxID{40, "h40"}}
+	tests := []struct {
+		name      string
+		cur       watcherCursor
+		res       newTransfers
+		processed int
+		failed    bool
+		want      watcherCursor
+		move      bool
+	}{
+		{"все обработаны", cur, res, 3, false, watcherCursor{TxID: TxID{20, "h20"}, Utime: 180}, true},
+		{"сбой на втором — курсор на первом", cur, res, 1, true, watcherCursor{TxID: TxID{11, "h11"}, Utime: 110}, true},
+		{"сбой на первом — курсор на месте", cur, res, 0, true, cur, false},
+		{"ничего нового", cur, newTransfers{Newest: TxID{10, "h10"}, Complete: true}, 0, false, cur, false},
+		{"пустая история", cur, newTransfers{Complete: true}, 0, false, cur, false},
+		{"не долистали — запоминаем пропуск", cur, partial, 3, false,
+			watcherCursor{TxID: TxID{10, "h10"}, Utime: 180, Resume: TxID{11, "h11"}, Head: TxID{20, "h20"}}, true},
+		{"не долистали и сбой — пропуск до последнего обработанного", cur, partial, 2, true,
+			watcherCursor{TxID: TxID{10, "h10"}, Utime: 150, Resume: TxID{11, "h11"}, Head: TxID{15, "h15"}}, true},
+		{"не долистали и сбой на первом", cur, partial, 0, true, cur, false},
+		{"пропуск закрыт — курсор на Head", gap, res, 3, false, watcherCursor{TxID: TxID{40, "h40"}, Utime: 180}, true},
+		{"пропуск ещё не закрыт — Resume ниже", gap, partial, 3, false,
+			watcherCursor{TxID: TxID{5, "h5"}, Utime: 180, Resume: TxID{11, "h11"}, Head: TxID{40, "h40"}}, true},
+		{"сбой при закрытии пропуска — курсор вверх до обработанного", gap, res, 1, true,
+			watcherCursor{TxID: TxID{11, "h11"}, Utime: 110, Resume: TxID{30, "h30"}, Head: TxID{40, "h40"}}, true},
+		{"сбой посреди пропуска — листаем его заново", gap, partial, 2, true,
+			watcherCursor{TxID: TxID{5, "h5"}, Utime: 150, Resume: TxID{30, "h30"}, Head: TxID{40, "h40"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, move := nextCursor(tt.cur, tt.res, tt.processed, tt.failed)
+			if got != tt.want || move != tt.move {
+				t.Errorf("nextCursor() = %+v, %v; want %+v, %v", got, move, tt.want, tt.move)
+			}
+		})
+	}
+}
+
+// Длинный простой кошелька: за несколько опросов с лимитом страниц разбираем все переводы по разу
+func TestPollTransfersResumesGap(t *testing.T) {
+	src := history(300)
+	cur := watcherCursor{TxID: TxID{10, "h10"}}
+	seen := map[int64]int{}
+	process := func(tr Transfer) error {
+		seen[tr.Lt]++
+		return nil
+	}
+
+	for poll := 1; poll <= 4; poll++ {
+		next, moved, err := pollTransfers(context.Background(), src, "w", cur, 2, process, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if moved {
+			cur = next
+		}
+		if poll == 1 {
+			// новые переводы приходят между опросами
+			for lt := 301; lt <= 305; lt++ {
+				src.Add(Transfer{Hash: fmt.Sprintf("h%d", lt), Lt: int64(lt), Utime: int64(lt) * 10, AmountNano: big.NewInt(1)})
+			}
+		}
+	}
+
+	if cur.TxID != (TxID{305, "h305"}) || cur.Resume.Lt != 0 || cur.Head.Lt != 0 {
+		t.Fatalf("курсор после опросов: %+v", cur)
+	}
+	for lt := int64(11); lt <= 305; lt++ {
+		if seen[lt] != 1 {
+			t.Errorf("перевод lt=%d обработан %d раз(а)", lt, seen[lt])
+		}
+	}
+	if len(seen) != 295 {
+		t.Errorf("обработано %d переводов, want 295", len(seen))
+	}
+}
+
+// testDB — база для тестов с ton_payments: TEST_DATABASE_URL (DSN для lib/pq), иначе тест пропускается
+func testDB(t *testing.T) *sql.DB {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL не задан")
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Ping(); err != nil {
+		t.Fatal(err)
+	}
+	db.RunMigrations(conn)
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// testChannel — отдельные клиент и канал на каждый прогон; suffix годится для уникальных ключей теста.
+// Клиент удаляется после теста, канал и баланс — каскадом.
+func testChannel(t *testing.T, conn *sql.DB) (channelID, suffix int64) {
+	t.Helper()
+	suffix = time.Now().UnixNano()
+	var clientID int64
+	if err := conn.QueryRow(`INSERT INTO clients (chat_id, username) VALUES ($1, 'test') RETURNING id`,
+		suffix).Scan(&clientID); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Exec(`DELETE FROM clients WHERE id = $1`, clientID) })
+	if err := conn.QueryRow(`INSERT INTO channels (telegram_channel_id, client_id, channel_title) VALUES ($1, $2, $3) RETURNING id`,
+		-suffix, clientID, fmt.Sprintf("test%d", suffix)).Scan(&channelID); err != nil {
+		t.Fatal(err)
+	}
+	return channelID, suffix
+}
+
+func TestCreditTonPaymentIdempotent(t *testing.T) {
+	conn := testDB(t)
+
+	channelID, suffix := testChannel(t, conn)
+	hashes := []string{fmt.Sprintf("test-a-%d", suffix), fmt.Sprintf("test-b-%d", suffix)}
+	t.Cleanup(func() {
+		conn.Exec(`DELETE FROM ton_payments WHERE hash = ANY($1)`, "{"+hashes[0]+","+hashes[1]+"}")
+	})
+
+	steps := []struct {
+		hash     string
+		amount   string
+		credited bool
+		balance  string
+	}{
+		{hashes[0], "5000000000", true, "5000000000"},
+		{hashes[0], "5000000000", false, "5000000000"}, // повтор того же перевода
+		{hashes[1], "7500000000", true, "12500000000"},
+		{hashes[1], "7500000000", false, "12500000000"},
+	}
+	for i, st := range steps {
+		credited, err := db.CreditTonPayment(conn, db.TonPayment{
+			Hash: st.hash, Utime: int64(i), Value: st.amount, Comment: "channel:@test",
+		}, channelID, nano(st.amount))
+		if err != nil {
+			t.Fatalf("шаг %d: %v", i, err)
+		}
+		if credited != st.credited {
+			t.Errorf("шаг %d: credited = %v, want %v", i, credited, st.credited)
+		}
+		balance, err := db.GetChannelBalance(conn, channelID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if balance.Cmp(nano(st.balance)) != 0 {
+			t.Errorf("шаг %d: баланс = %s, want %s", i, balance, st.balance)
+		}
+	}
+
+	// списание за период: хватает один раз, остаток переходит дальше
+	price := big.NewInt(12_000_000_000)
+	for i, want := range []bool{true, false} {
+		charged, err := db.ChargeChannelCredit(conn, channelID, price, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if charged != want {
+			t.Errorf("списание %d: charged = %v, want %v", i, charged, want)
+		}
+	}
+	balance, err := db.GetChannelBalance(conn, channelID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Cmp(big.NewInt(500_000_000)) != 0 {
+		t.Errorf("остаток = %s, want 500000000", balance)
+	}
+}